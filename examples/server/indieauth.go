@@ -4,85 +4,75 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 
 	"go.hacdias.com/indielib/indieauth"
+	"go.hacdias.com/indielib/micropub"
 )
 
-type authorization struct {
-	req  *indieauth.AuthenticationRequest
-	time time.Time
+type token struct {
+	time       time.Time
+	scopes     []string
+	expiration time.Time
+	// dpopThumbprint is the thumbprint of the DPoP key the token is bound to.
+	// If empty, the token is a bearer token.
+	dpopThumbprint string
 }
 
-// isExpired checks if the authorization is expired. It should be a reasonably
-// short lived, as the authorization code should be redeemed for a token at the
-// token endpoint, or the user profile at the authorization endpoint.
-func (ar *authorization) isExpired() bool {
-	return time.Since(ar.time) > time.Minute*10
+func (tk *token) isExpired() bool {
+	return !tk.expiration.IsZero() && tk.expiration.Before(time.Now())
 }
 
-// storeAuthorization stores the authorization request and returns a code for it.
-// Something such as JWT tokens could be used in a production environment.
-func (s *server) storeAuthorization(req *indieauth.AuthenticationRequest) string {
-	s.authorizationsMu.Lock()
-	defer s.authorizationsMu.Unlock()
+// newToken creates a token for the given scope, with the lifetime chosen by the user
+// when authorizing the given code, and returns its ID. In a production server,
+// something like a JWT or a database entry could be created.
+func (s *server) newToken(authorizationCode string, scopes []string, dpopThumbprint string) (string, time.Time) {
+	s.tokensMu.Lock()
+	defer s.tokensMu.Unlock()
 
 	code := randomString()
-	s.authorizations[code] = &authorization{
-		req:  req,
-		time: time.Now(),
+	token := &token{
+		scopes:         scopes,
+		time:           time.Now(),
+		dpopThumbprint: dpopThumbprint,
 	}
+	if lifetime := s.expirations[authorizationCode]; lifetime != 0 {
+		token.expiration = token.time.Add(lifetime)
+	}
+	delete(s.expirations, authorizationCode)
+	s.tokens[code] = token
 
-	return code
+	return code, token.expiration
 }
 
-// getAuthorization retrieves the authorization request corresponding to the code.
-// If it does not exist, or is expired, returns nil.
-func (s *server) getAuthorization(code string) *authorization {
-	s.authorizationsMu.Lock()
-	defer s.authorizationsMu.Unlock()
-
-	t, ok := s.authorizations[code]
-	if !ok {
-		return nil
-	}
-
-	delete(s.authorizations, code)
+// revokeTokens revokes the given tokens. It is called when an authorization code
+// is replayed.
+func (s *server) revokeTokens(req *indieauth.AuthenticationRequest, tokens []string) {
+	s.tokensMu.Lock()
+	defer s.tokensMu.Unlock()
 
-	if t.isExpired() {
-		return nil
+	for _, token := range tokens {
+		delete(s.tokens, token)
 	}
-	return t
-}
-
-type token struct {
-	time       time.Time
-	scopes     []string
-	expiration time.Time
-}
 
-func (tk *token) isExpired() bool {
-	return tk.expiration.Before(time.Now())
+	s.ias.Emit(nil, indieauth.EventTokenRevoked, req, indieauth.ErrCodeReplayed)
 }
 
-// newToken creates a token for the given scope and returns its ID. In a production
-// server, something like a JWT or a database entry could be created.
-func (s *server) newToken(scopes []string) (string, time.Time) {
+// revokeGrant revokes all the tokens of the given grant. It is called when the
+// user revokes the access of an application.
+func (s *server) revokeGrant(_ context.Context, grant *indieauth.Grant) error {
 	s.tokensMu.Lock()
 	defer s.tokensMu.Unlock()
 
-	code := randomString()
-	token := &token{
-		scopes:     scopes,
-		time:       time.Now(),
-		expiration: time.Now().Add(time.Hour * 24),
+	for _, token := range grant.Tokens {
+		delete(s.tokens, token)
 	}
-	s.tokens[code] = token
 
-	return code, token.expiration
+	return nil
 }
 
 // getToken retrieves the token information for the given code. Deletes it if expired.
@@ -103,12 +93,6 @@ func (s *server) getToken(code string) *token {
 	return t
 }
 
-type contextKey string
-
-const (
-	scopesContextKey contextKey = "scopes"
-)
-
 // authorizationHandler handles the authorization endpoint, which can be used to:
 //  1. GET - authorize a request.
 //  2. POST - exchange the authorization code for the user's profile URL.
@@ -128,30 +112,31 @@ func (s *server) authorizationHandler(w http.ResponseWriter, r *http.Request) {
 
 // authorizationGetHandler handles the GET method for the authorization endpoint.
 func (s *server) authorizationGetHandler(w http.ResponseWriter, r *http.Request) {
-	// In a production server, this page would usually be protected. In order for
-	// the user to authorize this request, they must be authenticated. This could
-	// be done in different ways: username/password, passkeys, etc.
+	// In order for the user to authorize this request, they must be authenticated.
+	// Here, the owner authenticates with a passkey when accepting the request and,
+	// if a password is configured, must login beforehand.
+	if s.owner != nil {
+		if _, err := s.owner.Session(r); err != nil {
+			http.Redirect(w, r, "/login?"+url.Values{"redirect": {r.URL.RequestURI()}}.Encode(), http.StatusSeeOther)
+			return
+		}
+	}
 
-	// Parse the authorization request.
-	req, err := s.ias.ParseAuthorization(r)
-	if err != nil {
+	// Parse the authorization request. Repeated failures from the same client
+	// or address are rate limited.
+	req, err := s.limiter.ParseAuthorization(r)
+	if indieauth.ServeRateLimitError(w, err) {
+		return
+	} else if err != nil {
 		serveErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
 		return
 	}
 
-	// Do a best effort attempt at fetching more information about the application
-	// that we can show to the user. Not all applications provide this sort of
-	// information.
-	app, _ := s.ias.DiscoverApplicationMetadata(r.Context(), req.ClientID)
-
-	// Here, we just display a small HTML document where the user has to press
-	// to authorize this request. Please note that this template contains a form
-	// where we dump all the request information. This makes it possible to reuse
-	// [indieauth.Server.ParseAuthorization] when the user authorizes the request.
-	serveHTML(w, "auth.html", map[string]any{
-		"Request":     req,
-		"Application": app,
-	})
+	// Render the consent page, which shows the application information, if
+	// available, and allows the user to narrow the scopes and to choose when
+	// the token expires. The page contains a form where we dump all the request
+	// information, such that it can be parsed again when the user approves.
+	_ = s.consent.Render(w, r, req)
 }
 
 // authorizationPostHandler handles the POST method for the authorization endpoint.
@@ -194,36 +179,67 @@ func (s *server) authorizationCodeExchange(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	t := s.getAuthorization(r.Form.Get("code"))
-	if t == nil {
-		serveErrorJSON(w, http.StatusBadRequest, "invalid_request", "invalid authorization")
+	// Repeated failures, such as guessing codes or code verifiers, are rate
+	// limited per client, address and code.
+	release, err := s.limiter.Reserve(r)
+	if indieauth.ServeRateLimitError(w, err) {
+		return
+	} else if err != nil {
+		serveErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
 		return
 	}
 
-	authRequest := &indieauth.AuthenticationRequest{
-		ClientID:            t.req.ClientID,
-		RedirectURI:         t.req.RedirectURI,
-		CodeChallenge:       t.req.CodeChallenge,
-		CodeChallengeMethod: t.req.CodeChallengeMethod,
+	// Redeem the code. This ensures the code is only used once and has not yet
+	// expired. If the code is being replayed, the tokens issued from it are revoked.
+	code := r.Form.Get("code")
+	authRequest, err := s.codes.Redeem(code)
+	if err != nil {
+		_ = s.limiter.Failure(r)
+		release()
+		serveErrorJSON(w, http.StatusBadRequest, "invalid_grant", err.Error())
+		return
 	}
+	release()
 
-	err := s.ias.ValidateTokenExchange(authRequest, r)
-	if err != nil {
+	// When exchanging the code for a token, the client may send a DPoP proof, in
+	// which case the token is bound to the client's key.
+	var proof *indieauth.DPoPProof
+	if withToken {
+		proof, err = s.limiter.ValidateDPoPTokenExchange(authRequest, r)
+	} else {
+		err = s.limiter.ValidateTokenExchange(authRequest, r)
+	}
+	if indieauth.ServeRateLimitError(w, err) {
+		return
+	} else if errors.Is(err, indieauth.ErrDPoPUseNonce) {
+		s.ias.DPoP.SetNonce(w)
+		serveErrorJSON(w, http.StatusBadRequest, "use_dpop_nonce", err.Error())
+		return
+	} else if err != nil {
 		serveErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
 		return
 	}
 
 	response := &tokenResponse{
-		Me: s.profileURL,
+		Me: authRequest.Me,
 	}
 
-	scope := t.req.Scopes
+	scope := authRequest.Scopes
 
 	if withToken {
-		token, expiration := s.newToken(scope)
+		tokenType, thumbprint := indieauth.TokenTypeBearer, ""
+		if proof != nil {
+			tokenType, thumbprint = indieauth.TokenTypeDPoP, proof.Thumbprint
+		}
+
+		token, expiration := s.newToken(code, scope, thumbprint)
+		_ = s.codes.AddToken(code, token)
+		_, _ = s.grants.Record(r.Context(), authRequest, token)
 		response.AccessToken = token
-		response.TokenType = "Bearer"
-		response.ExpiresIn = int64(time.Until(expiration).Seconds())
+		response.TokenType = tokenType
+		if !expiration.IsZero() {
+			response.ExpiresIn = int64(time.Until(expiration).Seconds())
+		}
 		response.Scope = strings.Join(scope, " ")
 	}
 
@@ -233,18 +249,33 @@ func (s *server) authorizationCodeExchange(w http.ResponseWriter, r *http.Reques
 }
 
 func (s *server) authorizationAcceptHandler(w http.ResponseWriter, r *http.Request) {
-	// Parse authorization information. This only works because our authorization page
-	// includes all the required information. This can be done in other ways: database,
-	// whether temporary or not, cookies, etc.
-	req, err := s.ias.ParseAuthorization(r)
+	// Parse the decision of the user. The authorization request was stored when
+	// rendering the consent page, and the form only identifies it, such that it
+	// cannot be tampered with. The approval hooks, which authenticate the owner
+	// of this server, are run as well.
+	decision, err := s.consent.Decide(r)
+	if errors.Is(err, indieauth.ErrConsentDenied) {
+		http.Redirect(w, r, indieauth.DenyRedirectURL(decision.Request), http.StatusFound)
+		return
+	} else if err != nil {
+		serveErrorJSON(w, http.StatusUnauthorized, "access_denied", err.Error())
+		return
+	}
+
+	req := decision.Request
+
+	// Generate a random code bound to the authorization request. The code can
+	// only be used once and expires after a short period of time.
+	code, err := s.codes.Issue(req)
 	if err != nil {
-		serveErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
+		serveErrorJSON(w, http.StatusInternalServerError, "server_error", err.Error())
 		return
 	}
 
-	// Generate a random code and persist the information associated to that code.
-	// You could do this in other ways: database, or JWT tokens, or both, for example.
-	code := s.storeAuthorization(req)
+	// Remember the token expiration chosen by the user until the code is redeemed.
+	s.tokensMu.Lock()
+	s.expirations[code] = decision.Expiration
+	s.tokensMu.Unlock()
 
 	// Redirect to client callback.
 	query := url.Values{}
@@ -254,12 +285,11 @@ func (s *server) authorizationAcceptHandler(w http.ResponseWriter, r *http.Reque
 }
 
 // mustAuth is a middleware to ensure that the request is authorized. The way this
-// works depends on the implementation. It then stores the scopes in the context.
+// works depends on the implementation. It then stores the token information in
+// the context, such that it can be used by the Micropub implementation.
 func (s *server) mustAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
-		token = strings.TrimPrefix(token, "Bearer")
-		token = strings.TrimSpace(token)
+		scheme, token := indieauth.AccessTokenFromRequest(r)
 
 		tk := s.getToken(token)
 		if tk == nil {
@@ -267,7 +297,31 @@ func (s *server) mustAuth(next http.Handler) http.Handler {
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), scopesContextKey, tk.scopes)
+		// Tokens bound to a DPoP key must be used with the DPoP scheme and a
+		// proof made with the same key.
+		if tk.dpopThumbprint != "" {
+			if scheme != indieauth.TokenTypeDPoP {
+				serveErrorJSON(w, http.StatusUnauthorized, "invalid_token", "dpop bound token")
+				return
+			}
+
+			_, err := s.ias.DPoP.VerifyBound(r, token, tk.dpopThumbprint)
+			if errors.Is(err, indieauth.ErrDPoPUseNonce) {
+				s.ias.DPoP.SetNonce(w)
+				w.Header().Set("WWW-Authenticate", `DPoP error="use_dpop_nonce"`)
+				serveErrorJSON(w, http.StatusUnauthorized, "use_dpop_nonce", err.Error())
+				return
+			} else if err != nil {
+				w.Header().Set("WWW-Authenticate", `DPoP error="invalid_dpop_proof"`)
+				serveErrorJSON(w, http.StatusUnauthorized, "invalid_dpop_proof", err.Error())
+				return
+			}
+		}
+
+		ctx := micropub.ContextWithTokenInfo(r.Context(), &micropub.TokenInfo{
+			Me:     s.profileURL,
+			Scopes: tk.scopes,
+		})
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -6,9 +6,12 @@ import (
 	"flag"
 	"html/template"
 	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
 	"sync"
+	"time"
 
 	"go.hacdias.com/indielib/indieauth"
 	"go.hacdias.com/indielib/micropub"
@@ -18,6 +21,8 @@ func main() {
 	// Setup flags.
 	portPtr := flag.Int("port", 80, "port to listen on")
 	addressPtr := flag.String("profile", "http://localhost/", "client URL and front facing address to listen on")
+	passwordPtr := flag.String("password", "", "password of the owner, required to authorize requests if set")
+	totpPtr := flag.String("totp-secret", "", "base32 TOTP secret of the owner, requires a password")
 	flag.Parse()
 
 	profileURL := *addressPtr
@@ -30,11 +35,58 @@ func main() {
 
 	// Create a new client.
 	s := &server{
-		profileURL:     profileURL,
-		authorizations: map[string]*authorization{},
-		tokens:         map[string]*token{},
-		posts:          map[string]post{},
-		ias:            indieauth.NewServer(true, nil),
+		profileURL:  profileURL,
+		tokens:      map[string]*token{},
+		expirations: map[string]time.Duration{},
+		posts:       map[string]post{},
+		ias:         indieauth.NewServer(true, nil),
+	}
+	s.codes = indieauth.NewCodeIssuer(0, s.revokeTokens)
+	s.ias.Policy = &indieauth.StrictPolicy
+	s.ias.DPoP = indieauth.NewDPoPVerifier(false)
+	s.ias.Observer = indieauth.NewSlogObserver(slog.Default())
+	s.limiter = indieauth.NewRateLimitedServer(s.ias, nil)
+	s.grants = indieauth.NewGrants(s.ias, nil, s.revokeGrant)
+
+	// Passkeys are used to authenticate the owner before authorizing requests.
+	// The relying party is the host of the profile URL.
+	u, _ := url.Parse(profileURL)
+	s.webauthn = indieauth.NewWebAuthn(u.Hostname(), []string{u.Scheme + "://" + u.Host}, nil)
+	// The user authorizing requests is always the owner of this server.
+	s.ias.ApprovalHooks = append(s.ias.ApprovalHooks, func(_ *http.Request, req *indieauth.AuthenticationRequest) error {
+		req.Me = profileURL
+		return nil
+	})
+	s.ias.ApprovalHooks = append(s.ias.ApprovalHooks, s.verifyPasskey)
+
+	// If a password is given, the owner must login with it, and optionally with
+	// a TOTP code, before authorizing requests. In a production server, only the
+	// hash of the password would be stored.
+	if *passwordPtr != "" {
+		hash, err := indieauth.HashPassword(*passwordPtr)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		s.owner = indieauth.NewOwnerAuth(profileURL, hash, nil)
+		s.owner.TOTPSecret = *totpPtr
+		s.ias.ApprovalHooks = append(s.ias.ApprovalHooks, s.owner.ApprovalHook)
+	}
+
+	// The consent page is rendered with our own template, and is submitted to
+	// the accept handler.
+	s.consent = indieauth.NewConsent(s.ias)
+	s.consent.Template = templates.Lookup("auth.html")
+	s.consent.Action = "/authorization/accept"
+	s.consent.CSRFToken = func(r *http.Request) string {
+		if s.owner == nil {
+			return ""
+		}
+		session, err := s.owner.Session(r)
+		if err != nil {
+			return ""
+		}
+		return session.CSRFToken
 	}
 
 	// Mount general handler, which will handle the index page, as well as the
@@ -47,11 +99,33 @@ func main() {
 	http.HandleFunc("/authorization", s.authorizationHandler)
 	http.HandleFunc("/authorization/accept", s.authorizationAcceptHandler)
 	http.HandleFunc("/token", s.tokenHandler)
+	http.HandleFunc("/webauthn/register", s.webAuthnRegisterHandler)
+	http.HandleFunc("/webauthn/login", s.webAuthnLoginHandler)
+	http.HandleFunc("/login", s.loginHandler)
+	http.HandleFunc("/logout", s.logoutHandler)
+
+	// Mounts the connected applications page, where the user can see which
+	// applications have access and revoke it. It is only accessible to the
+	// authenticated owner, and revoking requires the CSRF token of the session.
+	if s.owner != nil {
+		s.grants.CSRFToken = s.consent.CSRFToken
+		http.Handle("/apps", s.owner.Require("/login", s.grants.Handler(func(r *http.Request) string {
+			verify := s.owner.Session
+			if r.Method != http.MethodGet {
+				verify = s.owner.VerifyCSRF
+			}
+			session, err := verify(r)
+			if err != nil {
+				return ""
+			}
+			return session.Me
+		})))
+	}
 
 	// Mounts the Micropub handler. We don't send any special configuration besides our
 	// implementation. Note that we wrap it with [server.mustAuth] which ensures that
 	// only authenticated requests pass through.
-	http.Handle("/micropub", s.mustAuth(micropub.NewHandler(&micropubImplementation{s})))
+	http.Handle("/micropub", s.mustAuth(micropub.NewContextHandler(&micropubImplementation{s})))
 
 	// Start it!
 	log.Printf("Listening on http://localhost:%d", *portPtr)
@@ -67,14 +141,20 @@ type post struct {
 }
 
 type server struct {
-	profileURL       string
-	authorizations   map[string]*authorization
-	authorizationsMu sync.Mutex
-	tokens           map[string]*token
-	tokensMu         sync.Mutex
-	posts            map[string]post
-	postsMu          sync.RWMutex
-	ias              *indieauth.Server
+	profileURL string
+	tokens     map[string]*token
+	// expirations are the token lifetimes chosen by the user, by authorization code.
+	expirations map[string]time.Duration
+	tokensMu    sync.Mutex
+	posts       map[string]post
+	postsMu     sync.RWMutex
+	ias         *indieauth.Server
+	limiter     *indieauth.RateLimitedServer
+	codes       *indieauth.CodeIssuer
+	grants      *indieauth.Grants
+	webauthn    *indieauth.WebAuthn
+	owner       *indieauth.OwnerAuth
+	consent     *indieauth.Consent
 }
 
 var (
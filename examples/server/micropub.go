@@ -1,13 +1,15 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"net/http"
+	"maps"
 	urlpkg "net/url"
-	"reflect"
+	"slices"
+	"strings"
 	"time"
 
+	"go.hacdias.com/indielib/microformats"
 	"go.hacdias.com/indielib/micropub"
 )
 
@@ -15,20 +17,11 @@ type micropubImplementation struct {
 	*server
 }
 
-func (s *micropubImplementation) HasScope(r *http.Request, scope string) bool {
-	v := r.Context().Value(scopesContextKey)
-	if scopes, ok := v.([]string); ok {
-		for _, sc := range scopes {
-			if sc == scope {
-				return true
-			}
-		}
-	}
-
-	return false
+func (s *micropubImplementation) HasScope(ctx context.Context, scope string) bool {
+	return micropub.TokenInfoFromContext(ctx).HasScope(scope)
 }
 
-func (s *micropubImplementation) Source(urlStr string) (map[string]any, error) {
+func (s *micropubImplementation) Source(_ context.Context, urlStr string) (map[string]any, error) {
 	url, err := urlpkg.Parse(urlStr)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %w", micropub.ErrBadRequest, err)
@@ -47,12 +40,74 @@ func (s *micropubImplementation) Source(urlStr string) (map[string]any, error) {
 	return nil, micropub.ErrNotFound
 }
 
-func (s *micropubImplementation) SourceMany(limit, offset int) ([]map[string]any, error) {
-	return nil, micropub.ErrNotImplemented
+func (s *micropubImplementation) SourceList(_ context.Context, query *micropub.SourceQuery) (*micropub.SourceList, error) {
+	if query.Before != "" {
+		return nil, fmt.Errorf("%w: before cursor", micropub.ErrNotImplemented)
+	}
+
+	s.postsMu.RLock()
+	defer s.postsMu.RUnlock()
+
+	// Posts are listed by path, which is used as the cursor.
+	paths := slices.Sorted(maps.Keys(s.posts))
+	if query.After != "" {
+		i, _ := slices.BinarySearch(paths, query.After)
+		if i < len(paths) && paths[i] == query.After {
+			i++
+		}
+		paths = paths[i:]
+	}
+	paths = paths[min(query.Offset, len(paths)):]
+
+	var (
+		list = &micropub.SourceList{Items: []map[string]any{}}
+		last string
+	)
+	for _, path := range paths {
+		if query.Limit >= 0 && len(list.Items) == query.Limit {
+			if last != "" {
+				list.Paging = &micropub.Paging{After: last}
+			}
+			break
+		}
+
+		post := s.posts[path]
+		source := map[string]any{
+			"type":       []string{post.Type},
+			"properties": post.Properties,
+		}
+
+		if query.PostType != "" {
+			if typ, _ := microformats.DiscoverType(source); string(typ) != query.PostType {
+				continue
+			}
+		}
+
+		if query.Search != "" && !strings.Contains(fmt.Sprint(post.Properties["content"], post.Properties["name"]), query.Search) {
+			continue
+		}
+
+		list.Items = append(list.Items, source)
+		last = path
+	}
+
+	return list, nil
 }
 
-func (s *micropubImplementation) Create(req *micropub.Request) (string, error) {
+func (s *micropubImplementation) Create(_ context.Context, req *micropub.Request) (string, error) {
+	// The commands have already been validated by the handler.
+	commands, err := req.ParseCommands()
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", micropub.ErrBadRequest, err)
+	}
+
 	newPath := "/" + time.Now().Format(time.RFC3339)
+	if commands.Slug != "" {
+		newPath = "/" + urlpkg.PathEscape(commands.Slug)
+	}
+
+	s.postsMu.Lock()
+	defer s.postsMu.Unlock()
 
 	s.posts[newPath] = post{
 		Type:       req.Type,
@@ -62,7 +117,7 @@ func (s *micropubImplementation) Create(req *micropub.Request) (string, error) {
 	return s.profileURL + newPath, nil
 }
 
-func (s *micropubImplementation) Update(req *micropub.Request) (string, error) {
+func (s *micropubImplementation) Update(_ context.Context, req *micropub.Request) (string, error) {
 	url, err := urlpkg.Parse(req.URL)
 	if err != nil {
 		return "", fmt.Errorf("%w: %w", micropub.ErrBadRequest, err)
@@ -75,15 +130,16 @@ func (s *micropubImplementation) Update(req *micropub.Request) (string, error) {
 		return "", fmt.Errorf("%w does not exist", micropub.ErrBadRequest)
 	}
 
-	post.Properties, err = updateProperties(post.Properties, req)
+	post.Properties, _, err = micropub.ApplyUpdate(post.Properties, req.Updates)
 	if err != nil {
 		return "", fmt.Errorf("%w: %w", micropub.ErrBadRequest, err)
 	}
+	s.posts[url.Path] = post
 
 	return s.profileURL + url.Path, nil
 }
 
-func (s *micropubImplementation) Delete(urlStr string) error {
+func (s *micropubImplementation) Delete(_ context.Context, urlStr string) error {
 	url, err := urlpkg.Parse(urlStr)
 	if err != nil {
 		return fmt.Errorf("%w: %w", micropub.ErrBadRequest, err)
@@ -96,92 +152,6 @@ func (s *micropubImplementation) Delete(urlStr string) error {
 	return nil
 }
 
-func (s *micropubImplementation) Undelete(url string) error {
+func (s *micropubImplementation) Undelete(_ context.Context, url string) error {
 	return micropub.ErrNotImplemented
 }
-
-// updateProperties applies the updates (additions, deletions, replacements)
-// in the given [micropub.Request] to a set of existing microformats properties.
-func updateProperties(properties map[string][]any, req *micropub.Request) (map[string][]any, error) {
-	if req.Updates.Replace != nil {
-		for key, value := range req.Updates.Replace {
-			properties[key] = value
-		}
-	}
-
-	if req.Updates.Add != nil {
-		for key, value := range req.Updates.Add {
-			switch key {
-			case "name":
-				return nil, errors.New("cannot add a new name")
-			case "content":
-				return nil, errors.New("cannot add content")
-			default:
-				if key == "published" {
-					if _, ok := properties["published"]; ok {
-						return nil, errors.New("cannot replace published through add method")
-					}
-				}
-
-				if _, ok := properties[key]; !ok {
-					properties[key] = []any{}
-				}
-
-				properties[key] = append(properties[key], value...)
-			}
-		}
-	}
-
-	if req.Updates.Delete != nil {
-		if reflect.TypeOf(req.Updates.Delete).Kind() == reflect.Slice {
-			toDelete, ok := req.Updates.Delete.([]any)
-			if !ok {
-				return nil, errors.New("invalid delete array")
-			}
-
-			for _, key := range toDelete {
-				delete(properties, fmt.Sprint(key))
-			}
-		} else {
-			toDelete, ok := req.Updates.Delete.(map[string]any)
-			if !ok {
-				return nil, fmt.Errorf("invalid delete object: expected map[string]any, got: %s", reflect.TypeOf(req.Updates.Delete))
-			}
-
-			for key, v := range toDelete {
-				value, ok := v.([]any)
-				if !ok {
-					return nil, fmt.Errorf("invalid value: expected []any, got: %s", reflect.TypeOf(value))
-				}
-
-				if _, ok := properties[key]; !ok {
-					properties[key] = []any{}
-				}
-
-				properties[key] = filter(properties[key], func(ss any, _ int) bool {
-					for _, s := range value {
-						if s == ss {
-							return false
-						}
-					}
-					return true
-				})
-			}
-		}
-	}
-
-	return properties, nil
-}
-
-// From https://github.com/samber/lo
-func filter[V any](collection []V, predicate func(item V, index int) bool) []V {
-	result := make([]V, 0, len(collection))
-
-	for i, item := range collection {
-		if predicate(item, i) {
-			result = append(result, item)
-		}
-	}
-
-	return result
-}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"go.hacdias.com/indielib/indieauth"
+)
+
+// loginHandler handles the login of the owner with their password and, if
+// configured, their TOTP code.
+func (s *server) loginHandler(w http.ResponseWriter, r *http.Request) {
+	if s.owner == nil {
+		httpError(w, http.StatusNotFound)
+		return
+	}
+
+	redirect := r.FormValue("redirect")
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
+		redirect = "/"
+	}
+
+	data := map[string]any{
+		"Redirect": redirect,
+		"TOTP":     false,
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		serveHTML(w, "login.html", data)
+	case http.MethodPost:
+		// Failed password and TOTP attempts are limited per IP address, such
+		// that they cannot be guessed.
+		key := "login ip:" + remoteIP(r)
+		release, err := s.limiter.Limiter.Reserve(r.Context(), key)
+		if err != nil {
+			data["Error"] = err.Error()
+			w.WriteHeader(http.StatusTooManyRequests)
+			serveHTML(w, "login.html", data)
+			return
+		}
+		defer release()
+
+		err = s.owner.Login(w, r)
+		if err == nil {
+			_ = s.limiter.Limiter.Success(r.Context(), key)
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
+			return
+		}
+
+		if errors.Is(err, indieauth.ErrInvalidCredentials) {
+			_ = s.limiter.Limiter.Failure(r.Context(), key)
+		}
+
+		if errors.Is(err, indieauth.ErrTOTPRequired) {
+			data["TOTP"] = true
+		} else {
+			data["Error"] = err.Error()
+			data["TOTP"] = r.PostForm.Get("totp") != "" || r.PostForm.Get("recovery_code") != ""
+		}
+
+		w.WriteHeader(http.StatusUnauthorized)
+		serveHTML(w, "login.html", data)
+	default:
+		httpError(w, http.StatusMethodNotAllowed)
+	}
+}
+
+// logoutHandler ends the session of the owner.
+func (s *server) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if s.owner != nil {
+		s.owner.Logout(w, r)
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// remoteIP returns the IP address of the request. In a production server that
+// runs behind a reverse proxy, the IP address would be taken from the headers
+// set by the proxy.
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"go.hacdias.com/indielib/indieauth"
+)
+
+// hasPasskeys returns whether the owner has registered any passkey.
+func (s *server) hasPasskeys(r *http.Request) bool {
+	credentials, err := s.webauthn.Store.List(r.Context(), s.profileURL)
+	return err == nil && len(credentials) != 0
+}
+
+// webAuthnRegisterHandler handles the registration of a passkey for the owner.
+// A GET request returns the options for the browser, while a POST request
+// finishes the registration. In a production server, this would only be
+// accessible to the authenticated owner. Here, we only allow registering the
+// first passkey and, if a password is configured, require the owner to login.
+func (s *server) webAuthnRegisterHandler(w http.ResponseWriter, r *http.Request) {
+	if s.owner != nil {
+		if _, err := s.owner.Session(r); err != nil {
+			serveErrorJSON(w, http.StatusUnauthorized, "access_denied", err.Error())
+			return
+		}
+	}
+
+	if s.hasPasskeys(r) {
+		serveErrorJSON(w, http.StatusForbidden, "access_denied", "a passkey is already registered")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		options, err := s.webauthn.BeginRegistration(r.Context(), s.profileURL, "")
+		if err != nil {
+			serveErrorJSON(w, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+
+		serveJSON(w, http.StatusOK, map[string]any{"publicKey": options})
+	case http.MethodPost:
+		var response *indieauth.WebAuthnRegistrationResponse
+		if err := json.NewDecoder(r.Body).Decode(&response); err != nil {
+			serveErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+
+		if _, err := s.webauthn.FinishRegistration(r.Context(), s.profileURL, "Passkey", response); err != nil {
+			serveErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		httpError(w, http.StatusMethodNotAllowed)
+	}
+}
+
+// webAuthnLoginHandler returns the options for the browser to authenticate the
+// owner with a passkey. The assertion is sent along with the authorization.
+func (s *server) webAuthnLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, http.StatusMethodNotAllowed)
+		return
+	}
+
+	options, err := s.webauthn.BeginLogin(r.Context(), s.profileURL)
+	if errors.Is(err, indieauth.ErrWebAuthnCredentialNotFound) {
+		serveErrorJSON(w, http.StatusNotFound, "invalid_request", err.Error())
+		return
+	} else if err != nil {
+		serveErrorJSON(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	serveJSON(w, http.StatusOK, map[string]any{"publicKey": options})
+}
+
+// verifyPasskey verifies the passkey assertion sent with the authorization. If
+// the owner has not registered any passkey, the authorization is allowed. It is
+// used as an [indieauth.ApprovalHook].
+func (s *server) verifyPasskey(r *http.Request, req *indieauth.AuthenticationRequest) error {
+	if !s.hasPasskeys(r) {
+		return nil
+	}
+
+	var response *indieauth.WebAuthnAssertionResponse
+	if err := json.Unmarshal([]byte(r.Form.Get("webauthn")), &response); err != nil {
+		return errors.New("passkey authentication required")
+	}
+
+	credential, err := s.webauthn.FinishLogin(r.Context(), s.profileURL, response)
+	if err != nil {
+		return err
+	}
+
+	req.Me = credential.Me
+	return nil
+}
@@ -6,6 +6,7 @@ toolchain go1.26.1
 
 require (
 	github.com/stretchr/testify v1.11.1
+	golang.org/x/crypto v0.49.0
 	golang.org/x/net v0.52.0
 	golang.org/x/oauth2 v0.36.0
 	willnorris.com/go/microformats v1.2.1-0.20260218044424-22f0c2eff25b
@@ -16,5 +17,6 @@ require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/objx v0.5.2 // indirect
+	golang.org/x/sys v0.42.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -6,16 +6,25 @@ import (
 	"encoding/base64"
 )
 
-// CodeChallengeMethods are the code challenge methods that are supported by
-// this package.
+// CodeChallengeMethods are the code challenge methods that are allowed by
+// default. The "plain" method is not included, since it does not protect the
+// code verifier. It can still be allowed explicitly through
+// [Policy.CodeChallengeMethods], for legacy clients.
 var CodeChallengeMethods = []string{
+	"S256",
+}
+
+// knownCodeChallengeMethods are all the code challenge methods that are
+// implemented by [ValidateCodeChallenge].
+var knownCodeChallengeMethods = []string{
 	"plain", "S256",
 }
 
 // IsValidCodeChallengeMethod returns whether the provided code challenge method
-// is valid or not.
+// is valid or not. Valid methods are not necessarily allowed. See
+// [Policy.AllowsCodeChallengeMethod].
 func IsValidCodeChallengeMethod(ccm string) bool {
-	return containsString(CodeChallengeMethods, ccm)
+	return containsString(knownCodeChallengeMethods, ccm)
 }
 
 // ValidateCodeChallenge validates a code challenge against its code verifier.
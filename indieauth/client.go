@@ -31,6 +31,10 @@ type Client struct {
 
 	ClientID    string
 	RedirectURL string
+
+	// DPoPKey is the key used to generate DPoP proofs. If set, the tokens
+	// requested by the client are bound to this key, if the server supports it.
+	DPoPKey *DPoPKey
 }
 
 // NewClient creates a new [Client] from the provided clientID and redirectURL.
@@ -82,6 +86,9 @@ type Metadata struct {
 	CodeChallengeMethodsSupported              []string `json:"code_challenge_methods_supported"`
 	AuthorizationResponseIssParameterSupported bool     `json:"authorization_response_iss_parameter_supported"`
 	UserInfoEndpoint                           string   `json:"userinfo_endpoint"`
+	PushedAuthorizationRequestEndpoint         string   `json:"pushed_authorization_request_endpoint,omitempty"`
+	RequirePushedAuthorizationRequests         bool     `json:"require_pushed_authorization_requests,omitempty"`
+	DPoPSigningAlgValuesSupported              []string `json:"dpop_signing_alg_values_supported,omitempty"`
 }
 
 // Authenticate takes a profile URL and the desired scope, discovers the required
@@ -89,8 +96,14 @@ type Metadata struct {
 // and builds the authorization URL. It returns the authorization info, redirect
 // URI and an error.
 //
+// If the server advertises a pushed authorization request endpoint, the
+// authorization parameters are pushed to it, as per [RFC 9126], and the
+// returned redirect URI only contains the client ID and the request URI.
+//
 // The returned [AuthInfo] should be stored by the caller of this function in
 // such a way that it can be retrieved to validate the callback.
+//
+// [RFC 9126]: https://datatracker.ietf.org/doc/html/rfc9126
 func (c *Client) Authenticate(ctx context.Context, profile, scope string) (*AuthInfo, string, error) {
 	metadata, err := c.DiscoverMetadata(ctx, profile)
 	if err != nil {
@@ -115,12 +128,32 @@ func (c *Client) Authenticate(ctx context.Context, profile, scope string) (*Auth
 		return nil, "", err
 	}
 
-	authURL := o.AuthCodeURL(
-		state,
-		oauth2.SetAuthURLParam("scope", scope),
-		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
-		oauth2.SetAuthURLParam("code_challenge", s256Challenge(cv)),
-	)
+	params := url.Values{
+		"scope":                 {scope},
+		"code_challenge_method": {"S256"},
+		"code_challenge":        {s256Challenge(cv)},
+	}
+	if c.DPoPKey != nil {
+		params.Set("dpop_jkt", c.DPoPKey.Thumbprint())
+	}
+
+	var authURL string
+	if metadata.PushedAuthorizationRequestEndpoint != "" {
+		params.Set("response_type", "code")
+		params.Set("client_id", c.ClientID)
+		params.Set("redirect_uri", c.RedirectURL)
+		params.Set("state", state)
+		authURL, err = c.pushAuthorization(ctx, metadata, params)
+		if err != nil {
+			return nil, "", err
+		}
+	} else {
+		opts := []oauth2.AuthCodeOption{}
+		for key := range params {
+			opts = append(opts, oauth2.SetAuthURLParam(key, params.Get(key)))
+		}
+		authURL = o.AuthCodeURL(state, opts...)
+	}
 
 	return &AuthInfo{
 		Metadata:     *metadata,
@@ -130,6 +163,55 @@ func (c *Client) Authenticate(ctx context.Context, profile, scope string) (*Auth
 	}, authURL, nil
 }
 
+// pushAuthorization pushes the authorization parameters to the pushed authorization
+// request endpoint and returns the authorization URL with the request URI.
+func (c *Client) pushAuthorization(ctx context.Context, metadata *Metadata, v url.Values) (string, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.PushedAuthorizationRequestEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return "", err
+	}
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Add("Accept", "application/json")
+
+	res, err := c.Client.Do(r)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if res.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("status code: expected 201, got %d", res.StatusCode)
+	}
+
+	var par *pushedAuthorizationResponse
+	err = json.Unmarshal(data, &par)
+	if err != nil {
+		return "", err
+	}
+
+	if par.RequestURI == "" {
+		return "", ErrInvalidRequestURI
+	}
+
+	authURL, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	query := authURL.Query()
+	query.Set("client_id", c.ClientID)
+	query.Set("request_uri", par.RequestURI)
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
+
 // newState generates a new state value.
 func newState() (string, error) {
 	// OAuth 2.0 requires state to be printable ASCII, so base64 fits.
@@ -222,6 +304,10 @@ func ProfileFromToken(token *oauth2.Token) *Profile {
 //
 // You can now use httpClient to make requests to, for example, a Micropub endpoint. They
 // are authenticated with token. See https://pkg.go.dev/golang.org/x/oauth2 for more details.
+//
+// If [Client.DPoPKey] is set, the token request includes a DPoP proof. In that case,
+// use [Client.HTTPClient] instead, such that requests made with a DPoP-bound token
+// include the required proofs.
 func (c *Client) GetToken(ctx context.Context, i *AuthInfo, code string) (*oauth2.Token, *oauth2.Config, error) {
 	if i.TokenEndpoint == "" {
 		return nil, nil, ErrNoEndpointFound
@@ -230,7 +316,7 @@ func (c *Client) GetToken(ctx context.Context, i *AuthInfo, code string) (*oauth
 	o := c.GetOAuth2(&i.Metadata)
 
 	tok, err := o.Exchange(
-		context.WithValue(ctx, oauth2.HTTPClient, c.Client),
+		context.WithValue(ctx, oauth2.HTTPClient, c.httpClient()),
 		code,
 		oauth2.SetAuthURLParam("client_id", c.ClientID),
 		oauth2.SetAuthURLParam("code_verifier", i.CodeVerifier),
@@ -254,6 +340,24 @@ func (c *Client) GetOAuth2(m *Metadata) *oauth2.Config {
 	}
 }
 
+// HTTPClient returns an [http.Client] that authenticates its requests with the
+// given token. If [Client.DPoPKey] is set, a DPoP proof is added to each request.
+func (c *Client) HTTPClient(ctx context.Context, m *Metadata, token *oauth2.Token) *http.Client {
+	return c.GetOAuth2(m).Client(context.WithValue(ctx, oauth2.HTTPClient, c.httpClient()), token)
+}
+
+// httpClient returns the HTTP client to use for token related requests. If
+// [Client.DPoPKey] is set, the returned client adds DPoP proofs.
+func (c *Client) httpClient() *http.Client {
+	if c.DPoPKey == nil {
+		return c.Client
+	}
+
+	hc := *c.Client
+	hc.Transport = c.DPoPKey.Transport(c.Client.Transport)
+	return &hc
+}
+
 // FetchProfile fetches the user [Profile], exchanging the authentication code from
 // their authentication endpoint, as per [specification]. Please note that
 // this action consumes the code.
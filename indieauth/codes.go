@@ -0,0 +1,177 @@
+package indieauth
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCodeLifetime is the default lifetime of an authorization code. The
+	// [specification] recommends a maximum lifetime of 10 minutes.
+	//
+	// [specification]: https://indieauth.spec.indieweb.org/#authorization-response
+	DefaultCodeLifetime = time.Minute * 10
+
+	// DefaultCodeReplayWindow is the default amount of time during which a
+	// redeemed authorization code is remembered in order to detect replays.
+	DefaultCodeReplayWindow = time.Hour * 24
+)
+
+var (
+	ErrInvalidCode  error = errors.New("authorization code is invalid")
+	ErrCodeExpired  error = errors.New("authorization code is expired")
+	ErrCodeReplayed error = errors.New("authorization code has already been used")
+)
+
+type issuedCode struct {
+	req        *AuthenticationRequest
+	expiration time.Time
+	redeemedAt time.Time
+	tokens     []string
+}
+
+// CodeIssuer issues and redeems authorization codes. Each code is bound to the
+// [AuthenticationRequest] it was issued for, can only be redeemed once, and
+// expires after [CodeIssuer.Lifetime].
+//
+// As recommended by [RFC 6749], redeemed codes are remembered for the duration
+// of [CodeIssuer.ReplayWindow]. If a code is redeemed more than once, the request
+// is denied and [CodeIssuer.OnReplay] is called with the tokens that were issued
+// based on that code, such that they can be revoked.
+//
+// A CodeIssuer is safe for concurrent use. Codes are kept in memory.
+//
+// [RFC 6749]: https://datatracker.ietf.org/doc/html/rfc6749#section-4.1.2
+type CodeIssuer struct {
+	Lifetime     time.Duration
+	ReplayWindow time.Duration
+	OnReplay     func(req *AuthenticationRequest, tokens []string)
+
+	mu    sync.Mutex
+	codes map[string]*issuedCode
+	now   func() time.Time
+}
+
+// NewCodeIssuer creates a new [CodeIssuer] whose codes are valid for the given
+// lifetime. If lifetime is 0, [DefaultCodeLifetime] will be used. The onReplay
+// function is optional.
+func NewCodeIssuer(lifetime time.Duration, onReplay func(req *AuthenticationRequest, tokens []string)) *CodeIssuer {
+	if lifetime == 0 {
+		lifetime = DefaultCodeLifetime
+	}
+
+	return &CodeIssuer{
+		Lifetime:     lifetime,
+		ReplayWindow: DefaultCodeReplayWindow,
+		OnReplay:     onReplay,
+		codes:        map[string]*issuedCode{},
+		now:          time.Now,
+	}
+}
+
+// Issue generates a new random authorization code bound to the given request.
+// The request should include the client identifier, redirect URI, the code
+// challenge, the approved scopes, as well as the user's profile URL.
+func (ci *CodeIssuer) Issue(req *AuthenticationRequest) (string, error) {
+	code, err := newCode()
+	if err != nil {
+		return "", err
+	}
+
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	ci.cleanup()
+
+	cp := *req
+	cp.Scopes = append([]string{}, req.Scopes...)
+	ci.codes[code] = &issuedCode{
+		req:        &cp,
+		expiration: ci.now().Add(ci.Lifetime),
+	}
+
+	return code, nil
+}
+
+// Redeem consumes the given authorization code and returns the request it was
+// bound to. The returned request can be passed to [Server.ValidateTokenExchange].
+//
+// An [ErrInvalidCode] error is returned if the code is unknown, [ErrCodeExpired]
+// if it has expired, and [ErrCodeReplayed] if it has already been redeemed.
+func (ci *CodeIssuer) Redeem(code string) (*AuthenticationRequest, error) {
+	ci.mu.Lock()
+
+	c, ok := ci.codes[code]
+	if !ok {
+		ci.mu.Unlock()
+		return nil, ErrInvalidCode
+	}
+
+	if !c.redeemedAt.IsZero() {
+		tokens := c.tokens
+		c.tokens = nil
+		ci.mu.Unlock()
+
+		if ci.OnReplay != nil {
+			ci.OnReplay(c.req, tokens)
+		}
+		return nil, ErrCodeReplayed
+	}
+
+	now := ci.now()
+	if now.After(c.expiration) {
+		delete(ci.codes, code)
+		ci.mu.Unlock()
+		return nil, ErrCodeExpired
+	}
+
+	c.redeemedAt = now
+	ci.mu.Unlock()
+
+	cp := *c.req
+	cp.Scopes = append([]string{}, c.req.Scopes...)
+	return &cp, nil
+}
+
+// AddToken records that the given token was issued based on the given code.
+// If the code is replayed, the token will be passed to [CodeIssuer.OnReplay].
+func (ci *CodeIssuer) AddToken(code, token string) error {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	c, ok := ci.codes[code]
+	if !ok || c.redeemedAt.IsZero() {
+		return ErrInvalidCode
+	}
+
+	c.tokens = append(c.tokens, token)
+	return nil
+}
+
+// cleanup removes the codes that have expired and that are no longer needed for
+// replay detection. Must be called with the lock held.
+func (ci *CodeIssuer) cleanup() {
+	now := ci.now()
+	for code, c := range ci.codes {
+		if c.redeemedAt.IsZero() {
+			if now.After(c.expiration) {
+				delete(ci.codes, code)
+			}
+		} else if now.After(c.redeemedAt.Add(ci.ReplayWindow)) {
+			delete(ci.codes, code)
+		}
+	}
+}
+
+// newCode generates a new authorization code.
+func newCode() (string, error) {
+	b := make([]byte, 32)
+	_, err := cryptorand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
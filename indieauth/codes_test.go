@@ -0,0 +1,126 @@
+package indieauth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodeIssuer(t *testing.T) {
+	t.Parallel()
+
+	req := &AuthenticationRequest{
+		ClientID:            "https://example.com/",
+		RedirectURI:         "https://example.com/callback",
+		Scopes:              []string{"create", "profile"},
+		CodeChallenge:       strings.Repeat("a", 43),
+		CodeChallengeMethod: "S256",
+		Me:                  "https://example.org/",
+	}
+
+	t.Run("Issue and Redeem", func(t *testing.T) {
+		t.Parallel()
+
+		ci := NewCodeIssuer(0, nil)
+		assert.Equal(t, DefaultCodeLifetime, ci.Lifetime)
+
+		code, err := ci.Issue(req)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, len(code), 43)
+
+		other, err := ci.Issue(req)
+		require.NoError(t, err)
+		assert.NotEqual(t, code, other)
+
+		redeemed, err := ci.Redeem(code)
+		require.NoError(t, err)
+		assert.Equal(t, req, redeemed)
+		assert.NotSame(t, req, redeemed)
+	})
+
+	t.Run("Unknown Code", func(t *testing.T) {
+		t.Parallel()
+
+		ci := NewCodeIssuer(0, nil)
+		_, err := ci.Redeem("does-not-exist")
+		assert.ErrorIs(t, err, ErrInvalidCode)
+		assert.ErrorIs(t, ci.AddToken("does-not-exist", "token"), ErrInvalidCode)
+	})
+
+	t.Run("Expired Code", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+		ci := NewCodeIssuer(time.Minute, nil)
+		ci.now = func() time.Time { return now }
+
+		code, err := ci.Issue(req)
+		require.NoError(t, err)
+
+		now = now.Add(time.Minute * 2)
+		_, err = ci.Redeem(code)
+		assert.ErrorIs(t, err, ErrCodeExpired)
+
+		// An expired code is not marked as redeemed, so it does not trigger
+		// the replay detection.
+		_, err = ci.Redeem(code)
+		assert.ErrorIs(t, err, ErrInvalidCode)
+	})
+
+	t.Run("Replayed Code Revokes Tokens", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			replayedReq    *AuthenticationRequest
+			replayedTokens []string
+		)
+
+		ci := NewCodeIssuer(0, func(req *AuthenticationRequest, tokens []string) {
+			replayedReq = req
+			replayedTokens = tokens
+		})
+
+		code, err := ci.Issue(req)
+		require.NoError(t, err)
+
+		assert.ErrorIs(t, ci.AddToken(code, "token"), ErrInvalidCode)
+
+		_, err = ci.Redeem(code)
+		require.NoError(t, err)
+		require.NoError(t, ci.AddToken(code, "token-1"))
+		require.NoError(t, ci.AddToken(code, "token-2"))
+
+		_, err = ci.Redeem(code)
+		assert.ErrorIs(t, err, ErrCodeReplayed)
+		assert.Equal(t, req, replayedReq)
+		assert.Equal(t, []string{"token-1", "token-2"}, replayedTokens)
+	})
+
+	t.Run("Cleanup", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+		ci := NewCodeIssuer(time.Minute, nil)
+		ci.now = func() time.Time { return now }
+
+		expired, err := ci.Issue(req)
+		require.NoError(t, err)
+
+		redeemed, err := ci.Issue(req)
+		require.NoError(t, err)
+		_, err = ci.Redeem(redeemed)
+		require.NoError(t, err)
+
+		now = now.Add(ci.ReplayWindow + time.Minute)
+		_, err = ci.Issue(req)
+		require.NoError(t, err)
+
+		_, err = ci.Redeem(expired)
+		assert.ErrorIs(t, err, ErrInvalidCode)
+		_, err = ci.Redeem(redeemed)
+		assert.ErrorIs(t, err, ErrInvalidCode)
+	})
+}
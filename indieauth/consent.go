@@ -0,0 +1,418 @@
+package indieauth
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultConsentLifetime is the default time the user has to decide on the
+// consent page.
+const DefaultConsentLifetime = time.Minute * 10
+
+var (
+	// ErrConsentDenied is returned by [Consent.Decide] when the user denies the
+	// authorization request.
+	ErrConsentDenied error = errors.New("authorization request was denied")
+
+	// ErrInvalidConsent is returned by [Consent.Decide] when the authorization
+	// request of the consent form is unknown, has expired, or has already been
+	// decided on.
+	ErrInvalidConsent error = errors.New("consent is invalid or expired")
+)
+
+// ConsentStore stores the authorization requests of the rendered consent pages
+// until the user decides on them.
+type ConsentStore interface {
+	// Store stores the request, identified by the given ID, until the given
+	// expiration time.
+	Store(ctx context.Context, id string, req *AuthenticationRequest, expiration time.Time) error
+
+	// Load retrieves and deletes the request identified by the given ID. Must
+	// return [ErrInvalidConsent] if the request does not exist or has expired.
+	Load(ctx context.Context, id string) (*AuthenticationRequest, error)
+}
+
+// NewMemoryConsentStore creates a new in-memory [ConsentStore].
+func NewMemoryConsentStore() ConsentStore {
+	return &memoryRequestStore{
+		requests: map[string]*storedRequest{},
+		notFound: ErrInvalidConsent,
+	}
+}
+
+// DefaultScopeDescriptions are the descriptions of the scopes defined by the
+// IndieAuth, Micropub and Microsub specifications.
+var DefaultScopeDescriptions = map[string]string{
+	"profile":  "See your profile information",
+	"email":    "See your email address",
+	"create":   "Create new posts",
+	"draft":    "Create draft posts",
+	"update":   "Update existing posts",
+	"delete":   "Delete posts",
+	"undelete": "Restore deleted posts",
+	"media":    "Upload files to your media endpoint",
+	"read":     "Read your feeds",
+	"follow":   "Follow and unfollow feeds",
+	"mute":     "Mute and unmute users",
+	"block":    "Block and unblock users",
+	"channels": "Manage your channels",
+}
+
+// DefaultConsentExpirations are the token expirations the user can choose
+// from in the consent page. Zero means the token does not expire.
+var DefaultConsentExpirations = []time.Duration{
+	time.Hour * 24,
+	time.Hour * 24 * 7,
+	time.Hour * 24 * 30,
+	time.Hour * 24 * 90,
+	0,
+}
+
+// ConsentScope is a scope listed in the consent page.
+type ConsentScope struct {
+	Name        string
+	Description string
+}
+
+// ConsentExpiration is a token expiration option listed in the consent page.
+type ConsentExpiration struct {
+	// Value is the expiration in seconds, or 0 if the token does not expire.
+	Value    int64
+	Label    string
+	Selected bool
+}
+
+// ConsentData is the data given to [Consent.Template].
+type ConsentData struct {
+	Request     *AuthenticationRequest
+	Application *ApplicationMetadata
+	Scopes      []ConsentScope
+	Expirations []ConsentExpiration
+
+	// Action is the URL the consent form must be submitted to.
+	Action string
+
+	// Fields are the hidden fields that must be included in the consent form
+	// such that the authorization request can be found again. The request itself
+	// is kept in the [ConsentStore], such that it cannot be tampered with.
+	Fields url.Values
+
+	// CSRFToken must be included in the consent form in the [CSRFTokenField]
+	// field, if not empty.
+	CSRFToken string
+}
+
+// ConsentDecision is the outcome of an approved consent form.
+type ConsentDecision struct {
+	// Request is the approved request. Its scopes are the ones the user has
+	// approved, which are a subset of the requested ones.
+	Request *AuthenticationRequest
+
+	// Expiration is the lifetime of the tokens issued for the request chosen
+	// by the user. Zero means the tokens do not expire.
+	Expiration time.Duration
+}
+
+// Consent renders the consent page of an authorization request and parses the
+// user's decision. The page shows the application metadata, as discovered by
+// [Server.DiscoverApplicationMetadata], and the requested scopes, which the
+// user can uncheck, as well as the expiration of the tokens.
+//
+// The authorization request is kept in the [ConsentStore] while the user
+// decides, bound to the CSRF token, if any. The consent form only identifies
+// the request, and can only narrow its scopes and choose the expiration.
+type Consent struct {
+	Server *Server
+	Store  ConsentStore
+
+	// Lifetime is how long the user has to decide. If zero,
+	// [DefaultConsentLifetime] is used.
+	Lifetime time.Duration
+
+	// Template is executed with [ConsentData] to render the consent page. The
+	// form must post the hidden fields in [ConsentData.Fields], the checked
+	// scopes as "consent_scope", the expiration in seconds as "expires_in" and,
+	// to deny the request, "action" set to "deny".
+	Template *template.Template
+
+	// Action is the URL the consent form is submitted to. If empty, the form
+	// is submitted to the current URL.
+	Action string
+
+	// ScopeDescriptions are the descriptions of the scopes. Scopes without a
+	// description are shown by their name.
+	ScopeDescriptions map[string]string
+
+	// Expirations are the token expirations the user can choose from. The first
+	// one is selected by default. If empty, tokens do not expire.
+	Expirations []time.Duration
+
+	// CSRFToken returns the CSRF token of the request. It is optional. See
+	// [OwnerSession.CSRFToken].
+	CSRFToken func(r *http.Request) string
+}
+
+// NewConsent creates a new [Consent] with the default template, scope
+// descriptions and expirations. Requests are kept in memory.
+func NewConsent(server *Server) *Consent {
+	return &Consent{
+		Server:            server,
+		Store:             NewMemoryConsentStore(),
+		Template:          consentTemplate,
+		ScopeDescriptions: DefaultScopeDescriptions,
+		Expirations:       DefaultConsentExpirations,
+	}
+}
+
+// Render renders the consent page for the given authorization request, which
+// is stored in [Consent.Store] until the user decides.
+func (c *Consent) Render(w http.ResponseWriter, r *http.Request, req *AuthenticationRequest) error {
+	id, err := newCode()
+	if err != nil {
+		return err
+	}
+
+	lifetime := c.Lifetime
+	if lifetime == 0 {
+		lifetime = DefaultConsentLifetime
+	}
+
+	stored := *req
+	stored.Scopes = slices.Clone(req.Scopes)
+	if err := c.Store.Store(r.Context(), c.storeKey(r, id), &stored, time.Now().Add(lifetime)); err != nil {
+		return err
+	}
+
+	data := &ConsentData{
+		Request: req,
+		Action:  c.Action,
+		Fields:  url.Values{"consent_id": {id}},
+	}
+
+	// Do a best effort attempt at fetching more information about the application.
+	data.Application, _ = c.Server.DiscoverApplicationMetadata(r.Context(), req.ClientID)
+
+	for _, scope := range req.Scopes {
+		data.Scopes = append(data.Scopes, ConsentScope{
+			Name:        scope,
+			Description: c.ScopeDescriptions[scope],
+		})
+	}
+
+	for i, expiration := range c.Expirations {
+		data.Expirations = append(data.Expirations, ConsentExpiration{
+			Value:    int64(expiration / time.Second),
+			Label:    expirationLabel(expiration),
+			Selected: i == 0,
+		})
+	}
+
+	if c.CSRFToken != nil {
+		data.CSRFToken = c.CSRFToken(r)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return c.Template.Execute(w, data)
+}
+
+// Decide parses the submitted consent form. The authorization request is taken
+// from [Consent.Store], such that it can only be decided on once, and only
+// within the session it was rendered in. If the user approved the request,
+// [Server.Approve] is called, and the request with the approved scopes is
+// returned. If the user denied the request, [ErrConsentDenied] is returned,
+// together with the original request, such that the user can be redirected
+// with [DenyRedirectURL].
+func (c *Consent) Decide(r *http.Request) (*ConsentDecision, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	id := r.PostForm.Get("consent_id")
+	if id == "" {
+		c.Server.Emit(r, EventVerificationFailed, nil, ErrInvalidConsent)
+		return nil, ErrInvalidConsent
+	}
+
+	req, err := c.Store.Load(r.Context(), c.storeKey(r, id))
+	if err != nil {
+		c.Server.Emit(r, EventVerificationFailed, nil, err)
+		return nil, err
+	}
+
+	decision := &ConsentDecision{Request: req}
+
+	if r.PostForm.Get("action") == "deny" {
+		c.Server.Emit(r, EventAuthorizationDenied, req, ErrConsentDenied)
+		return decision, ErrConsentDenied
+	}
+
+	// Users can only narrow the requested scopes.
+	scopes := []string{}
+	for _, scope := range r.PostForm["consent_scope"] {
+		if slices.Contains(req.Scopes, scope) && !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	req.Scopes = scopes
+
+	if len(c.Expirations) != 0 {
+		seconds, err := strconv.ParseInt(r.PostForm.Get("expires_in"), 10, 64)
+		if err != nil || !slices.Contains(c.Expirations, time.Duration(seconds)*time.Second) {
+			return nil, errors.New("expires_in is invalid")
+		}
+		decision.Expiration = time.Duration(seconds) * time.Second
+	}
+
+	if err := c.Server.Approve(r, req); err != nil {
+		return nil, err
+	}
+
+	return decision, nil
+}
+
+// Handler returns an [http.Handler] that renders the consent page on GET, and
+// handles the consent form on POST. The approved function is called with the
+// decision once the user approves the request, and must issue the code and
+// redirect the user. Denied requests are redirected back to the client.
+//
+// Since the authorization endpoint also receives POST requests to redeem
+// authorization codes, set [Consent.Action] to a different URL and mount the
+// handler there as well.
+func (c *Consent) Handler(approved func(w http.ResponseWriter, r *http.Request, decision *ConsentDecision)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			req, err := c.Server.ParseAuthorization(r)
+			if err != nil {
+				serveErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
+				return
+			}
+
+			if err := c.Render(w, r, req); err != nil {
+				serveInternalError(w, c.Server.logger(), err)
+			}
+		case http.MethodPost:
+			decision, err := c.Decide(r)
+			if errors.Is(err, ErrConsentDenied) {
+				http.Redirect(w, r, DenyRedirectURL(decision.Request), http.StatusFound)
+				return
+			} else if err != nil {
+				serveErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
+				return
+			}
+
+			approved(w, r, decision)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			serveErrorJSON(w, http.StatusMethodNotAllowed, "invalid_request", "method not allowed")
+		}
+	})
+}
+
+// DenyRedirectURL returns the URL the user must be redirected to when they
+// deny the given authorization request.
+func DenyRedirectURL(req *AuthenticationRequest) string {
+	query := url.Values{}
+	query.Set("error", "access_denied")
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+
+	separator := "?"
+	if strings.Contains(req.RedirectURI, "?") {
+		separator = "&"
+	}
+
+	return req.RedirectURI + separator + query.Encode()
+}
+
+// storeKey returns the key of the consent with the given ID in the store. The
+// key includes the CSRF token, such that the consent can only be decided on
+// within the same session.
+func (c *Consent) storeKey(r *http.Request, id string) string {
+	if c.CSRFToken == nil {
+		return id
+	}
+	return id + "." + c.CSRFToken(r)
+}
+
+func expirationLabel(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "Never"
+	case d%(time.Hour*24) == 0:
+		days := int64(d / (time.Hour * 24))
+		if days == 1 {
+			return "1 day"
+		}
+		return strconv.FormatInt(days, 10) + " days"
+	default:
+		return d.String()
+	}
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+  <head>
+    <title>Authorize {{ with .Application }}{{ .Name }}{{ else }}{{ .Request.ClientID }}{{ end }}</title>
+  </head>
+  <body>
+    <h1>
+      {{ with .Application }}{{ with .Logo }}<img style="width: 1em; vertical-align: middle;" src="{{ . }}"> {{ end }}{{ end }}
+      {{ if and .Application .Application.Name }}{{ .Application.Name }}{{ else }}{{ .Request.ClientID }}{{ end }}
+      would like to access your account
+    </h1>
+
+    {{ with .Application }}
+      {{ with .Summary }}<p>{{ . }}</p>{{ end }}
+      {{ with .Author }}<p>By {{ . }}</p>{{ end }}
+    {{ end }}
+
+    <ul>
+      <li><strong>Client:</strong> <code>{{ .Request.ClientID }}</code></li>
+      <li><strong>Redirect:</strong> <code>{{ .Request.RedirectURI }}</code></li>
+    </ul>
+
+    <form method="post"{{ with .Action }} action="{{ . }}"{{ end }}>
+      {{ range $name, $values := .Fields }}{{ range $values }}
+        <input type="hidden" name="{{ $name }}" value="{{ . }}">
+      {{ end }}{{ end }}
+      {{ with .CSRFToken }}<input type="hidden" name="csrf_token" value="{{ . }}">{{ end }}
+
+      {{ if .Scopes }}
+        <p>The application is requesting permission to:</p>
+        {{ range $scope := .Scopes }}
+          <p>
+            <label>
+              <input type="checkbox" name="consent_scope" value="{{ .Name }}" checked>
+              {{ with .Description }}{{ . }} (<code>{{ $scope.Name }}</code>){{ else }}<code>{{ .Name }}</code>{{ end }}
+            </label>
+          </p>
+        {{ end }}
+      {{ else }}
+        <p>The application is only requesting to confirm your identity.</p>
+      {{ end }}
+
+      {{ if .Expirations }}
+        <p>
+          <label>
+            Access expires after
+            <select name="expires_in">
+              {{ range .Expirations }}<option value="{{ .Value }}"{{ if .Selected }} selected{{ end }}>{{ .Label }}</option>{{ end }}
+            </select>
+          </label>
+        </p>
+      {{ end }}
+
+      <button name="action" value="approve">Approve</button>
+      <button name="action" value="deny">Deny</button>
+    </form>
+  </body>
+</html>`))
@@ -0,0 +1,229 @@
+package indieauth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsent(t *testing.T) {
+	t.Parallel()
+
+	server := NewServer(false, &http.Client{
+		Transport: &handlerRoundTripper{
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				_, _ = w.Write([]byte(`<div class="h-app"><a href="/" class="u-url p-name">Example App</a><p class="p-summary">Posts notes.</p></div>`))
+			}),
+		},
+	})
+
+	req := &AuthenticationRequest{
+		ClientID:    "https://example.com/",
+		RedirectURI: "https://example.com/callback",
+		State:       "state",
+		Scopes:      []string{"create", "update", "custom"},
+	}
+
+	consent := NewConsent(server)
+	consent.Action = "/consent"
+	consent.CSRFToken = func(r *http.Request) string { return "csrf-token" }
+
+	t.Run("Render", func(t *testing.T) {
+		t.Parallel()
+
+		w := httptest.NewRecorder()
+		require.NoError(t, consent.Render(w, httptest.NewRequest(http.MethodGet, "/auth", nil), req))
+
+		body := w.Body.String()
+		assert.Contains(t, body, "Example App")
+		assert.Contains(t, body, "Posts notes.")
+		assert.Contains(t, body, `action="/consent"`)
+		assert.Regexp(t, `<input type="hidden" name="consent_id" value="[\w-]+">`, body)
+		assert.NotContains(t, body, `name="client_id"`)
+		assert.NotContains(t, body, `name="scope"`)
+		assert.Contains(t, body, `<input type="hidden" name="csrf_token" value="csrf-token">`)
+		assert.Contains(t, body, `Create new posts (<code>create</code>)`)
+		assert.Contains(t, body, `<input type="checkbox" name="consent_scope" value="custom" checked>`)
+		assert.Contains(t, body, `<option value="86400" selected>1 day</option>`)
+		assert.Contains(t, body, `<option value="0">Never</option>`)
+	})
+
+	render := func(t *testing.T, consent *Consent) string {
+		w := httptest.NewRecorder()
+		require.NoError(t, consent.Render(w, httptest.NewRequest(http.MethodGet, "/auth", nil), req))
+		return consentID(t, w.Body.String())
+	}
+
+	post := func(id string, form url.Values) *http.Request {
+		values := url.Values{"consent_id": {id}}
+		for k, v := range form {
+			values[k] = v
+		}
+
+		r := httptest.NewRequest(http.MethodPost, "/consent", strings.NewReader(values.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+
+	decide := func(t *testing.T, consent *Consent, form url.Values) (*ConsentDecision, error) {
+		return consent.Decide(post(render(t, consent), form))
+	}
+
+	t.Run("Decide", func(t *testing.T) {
+		t.Parallel()
+
+		decision, err := decide(t, consent, url.Values{
+			"consent_scope": {"update", "delete", "create", "update"},
+			"expires_in":    {"604800"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"update", "create"}, decision.Request.Scopes)
+		assert.Equal(t, time.Hour*24*7, decision.Expiration)
+		assert.Equal(t, "state", decision.Request.State)
+
+		decision, err = decide(t, consent, url.Values{"expires_in": {"0"}})
+		require.NoError(t, err)
+		assert.Empty(t, decision.Request.Scopes)
+		assert.Zero(t, decision.Expiration)
+
+		_, err = decide(t, consent, url.Values{"expires_in": {"1"}})
+		assert.Error(t, err)
+
+		decision, err = decide(t, consent, url.Values{"action": {"deny"}})
+		assert.ErrorIs(t, err, ErrConsentDenied)
+		assert.Equal(t, "https://example.com/callback?error=access_denied&state=state", DenyRedirectURL(decision.Request))
+	})
+
+	t.Run("Decide Ignores Tampering", func(t *testing.T) {
+		t.Parallel()
+
+		// The authorization request cannot be changed through the form.
+		id := render(t, consent)
+		tampered := authorizationValues(&AuthenticationRequest{
+			ClientID:    "https://evil.example/",
+			RedirectURI: "https://evil.example/callback",
+			Scopes:      []string{"delete"},
+		})
+		tampered["consent_scope"] = []string{"create", "delete"}
+		tampered["expires_in"] = []string{"0"}
+
+		decision, err := consent.Decide(post(id, tampered))
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/", decision.Request.ClientID)
+		assert.Equal(t, "https://example.com/callback", decision.Request.RedirectURI)
+		assert.Equal(t, []string{"create"}, decision.Request.Scopes)
+
+		// Each consent can only be decided on once.
+		_, err = consent.Decide(post(id, url.Values{"expires_in": {"0"}}))
+		assert.ErrorIs(t, err, ErrInvalidConsent)
+
+		_, err = consent.Decide(post("", url.Values{"expires_in": {"0"}}))
+		assert.ErrorIs(t, err, ErrInvalidConsent)
+
+		// The consent is bound to the CSRF token of the session it was rendered in.
+		other := NewConsent(server)
+		other.CSRFToken = func(r *http.Request) string { return r.FormValue(CSRFTokenField) }
+		id = render(t, other)
+		_, err = other.Decide(post(id, url.Values{"expires_in": {"0"}, CSRFTokenField: {"other"}}))
+		assert.ErrorIs(t, err, ErrInvalidConsent)
+	})
+
+	t.Run("Handler", func(t *testing.T) {
+		t.Parallel()
+
+		errHook := errors.New("not authenticated")
+		ias := NewServer(false, nil)
+		ias.ApprovalHooks = []ApprovalHook{func(r *http.Request, req *AuthenticationRequest) error {
+			if r.PostForm.Get(CSRFTokenField) != "csrf-token" {
+				return errHook
+			}
+			req.Me = "https://example.org/"
+			return nil
+		}}
+
+		consent := NewConsent(ias)
+		consent.Expirations = nil
+
+		var decision *ConsentDecision
+		handler := consent.Handler(func(w http.ResponseWriter, r *http.Request, d *ConsentDecision) {
+			decision = d
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		get := func() *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/consent?"+authorizationValues(req).Encode(), nil))
+			return w
+		}
+
+		post := func(form url.Values) *httptest.ResponseRecorder {
+			form.Set("consent_id", consentID(t, get().Body.String()))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/consent", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			handler.ServeHTTP(w, r)
+			return w
+		}
+
+		w := get()
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "expires_in")
+
+		w = post(url.Values{"consent_scope": {"create"}})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Nil(t, decision)
+
+		w = post(url.Values{"consent_scope": {"create"}, CSRFTokenField: {"csrf-token"}})
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		require.NotNil(t, decision)
+		assert.Equal(t, "https://example.org/", decision.Request.Me)
+		assert.Equal(t, []string{"create"}, decision.Request.Scopes)
+
+		w = post(url.Values{"action": {"deny"}})
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "https://example.com/callback?error=access_denied&state=state", w.Header().Get("Location"))
+	})
+}
+
+// consentID returns the ID of the consent rendered in the given page.
+func consentID(t *testing.T, body string) string {
+	match := regexp.MustCompile(`name="consent_id" value="([\w-]+)"`).FindStringSubmatch(body)
+	require.Len(t, match, 2)
+	return match[1]
+}
+
+// authorizationValues returns the parameters of the authorization request, such
+// that it can be parsed again.
+func authorizationValues(req *AuthenticationRequest) url.Values {
+	values := url.Values{}
+	values.Set("response_type", "code")
+	values.Set("client_id", req.ClientID)
+	values.Set("redirect_uri", req.RedirectURI)
+
+	optional := map[string]string{
+		"state":                 req.State,
+		"scope":                 strings.Join(req.Scopes, " "),
+		"code_challenge":        req.CodeChallenge,
+		"code_challenge_method": req.CodeChallengeMethod,
+		"me":                    req.Me,
+		"dpop_jkt":              req.DPoPJKT,
+	}
+
+	for k, v := range optional {
+		if v != "" {
+			values.Set(k, v)
+		}
+	}
+
+	return values
+}
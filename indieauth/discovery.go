@@ -20,6 +20,7 @@ const (
 	AuthorizationEndpointRel string = "authorization_endpoint"
 	TokenEndpointRel         string = "token_endpoint"
 	IndieAuthMetadataRel     string = "indieauth-metadata"
+	RedirectURIRel           string = "redirect_uri"
 )
 
 // ErrNoEndpointFound is returned when no endpoint can be found for a certain
@@ -301,11 +302,11 @@ func resolveReferences(base string, refs ...*endpointRequest) error {
 }
 
 type ApplicationMetadata struct {
-	Name    string
-	Logo    string
-	URL     string
-	Summary string
-	Author  string
+	Name    string `json:"name,omitempty"`
+	Logo    string `json:"logo,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Summary string `json:"summary,omitempty"`
+	Author  string `json:"author,omitempty"`
 }
 
 // ErrNoApplicationMetadata is returned when no `h-app` or `h-x-app` Microformat
@@ -394,6 +395,121 @@ func (s *Server) DiscoverApplicationMetadata(ctx context.Context, clientID strin
 	return nil, ErrNoApplicationMetadata
 }
 
+// maxRedirectURIsDocumentSize is the maximum size of the client document that
+// is read by [Server.DiscoverRedirectURIs].
+const maxRedirectURIsDocumentSize = 1 << 20
+
+// DiscoverRedirectURIs fetches the redirect URIs published by the client with
+// the given identifier, as per the [specification]. Redirect URIs can be given
+// by HTTP Link headers or HTML <link> elements with the "redirect_uri" relation,
+// or by the "redirect_uris" property of a JSON client metadata document. Only
+// the first MiB of the document is read.
+//
+// [specification]: https://indieauth.spec.indieweb.org/#redirect-url
+func (s *Server) DiscoverRedirectURIs(ctx context.Context, clientID string) ([]string, error) {
+	err := IsValidClientIdentifier(clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, clientID, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Add("Accept", "application/json, text/html;q=0.9")
+
+	res, err := s.Client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code: expected 200, got %d", res.StatusCode)
+	}
+
+	body := io.LimitReader(res.Body, maxRedirectURIsDocumentSize)
+
+	var uris []string
+	for _, h := range header.ParseList(res.Header, "Link") {
+		link := header.ParseLink(h)
+		for _, rel := range link.Rel {
+			if rel == RedirectURIRel {
+				uris = append(uris, link.Href)
+				break
+			}
+		}
+	}
+
+	contentType := res.Header.Get("Content-Type")
+	if strings.Contains(contentType, "application/json") {
+		var metadata struct {
+			RedirectURIs []string `json:"redirect_uris"`
+		}
+		if err := json.NewDecoder(body).Decode(&metadata); err != nil {
+			return nil, err
+		}
+		uris = append(uris, metadata.RedirectURIs...)
+	} else if strings.Contains(contentType, "text/html") {
+		links, err := htmlLinks(body, RedirectURIRel)
+		if err != nil {
+			return nil, err
+		}
+		uris = append(uris, links...)
+	}
+
+	for i, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, err
+		}
+		uris[i] = res.Request.URL.ResolveReference(u).String()
+	}
+
+	return uris, nil
+}
+
+// htmlLinks parses r as HTML and returns the URLs of all the <link> elements
+// that contain the rel value.
+func htmlLinks(r io.Reader, rel string) ([]string, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		links []string
+		f     func(n *html.Node)
+	)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Link {
+			var href, rels string
+			for _, a := range n.Attr {
+				switch a.Key {
+				case atom.Href.String():
+					href = a.Val
+				case atom.Rel.String():
+					rels = a.Val
+				}
+			}
+			for _, v := range strings.Fields(rels) {
+				if v == rel && href != "" {
+					links = append(links, href)
+					break
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(doc)
+
+	return links, nil
+}
+
 func getFirstStringProperty(item *microformats.Microformat, key string) string {
 	vv, ok := item.Properties[key]
 	if !ok {
@@ -0,0 +1,340 @@
+package indieauth
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TokenTypeBearer is the token type of bearer tokens.
+	TokenTypeBearer = "Bearer"
+
+	// TokenTypeDPoP is the token type of tokens bound to a DPoP key, as per [RFC 9449].
+	//
+	// [RFC 9449]: https://datatracker.ietf.org/doc/html/rfc9449
+	TokenTypeDPoP = "DPoP"
+
+	// DPoPHeader is the HTTP header that carries DPoP proofs.
+	DPoPHeader = "DPoP"
+
+	// DPoPNonceHeader is the HTTP header that carries server provided DPoP nonces.
+	DPoPNonceHeader = "DPoP-Nonce"
+
+	dpopJWTType   = "dpop+jwt"
+	dpopAlgorithm = "ES256"
+)
+
+// DPoPSigningAlgorithms are the DPoP proof signing algorithms supported by this
+// package. Can be used to fill [Metadata.DPoPSigningAlgValuesSupported].
+var DPoPSigningAlgorithms = []string{dpopAlgorithm}
+
+// DPoPKey is a key used by a client to generate [DPoP] proofs. Tokens issued
+// for a proof generated with this key are bound to the key, and can only be
+// used together with a proof generated with the same key.
+//
+// [DPoP]: https://datatracker.ietf.org/doc/html/rfc9449
+type DPoPKey struct {
+	key *ecdsa.PrivateKey
+	jwk *dpopJWK
+
+	mu     sync.Mutex
+	nonces map[string]string
+}
+
+// NewDPoPKey generates a new P-256 [DPoPKey].
+func NewDPoPKey() (*DPoPKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDPoPKeyFromECDSA(key)
+}
+
+// NewDPoPKeyFromECDSA creates a [DPoPKey] from an existing P-256 private key.
+// This can be used to persist the key across sessions.
+func NewDPoPKeyFromECDSA(key *ecdsa.PrivateKey) (*DPoPKey, error) {
+	if key.Curve != elliptic.P256() {
+		return nil, errors.New("dpop key must use the P-256 curve")
+	}
+
+	pub, err := key.PublicKey.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DPoPKey{
+		key: key,
+		jwk: &dpopJWK{
+			Kty: "EC",
+			Crv: "P-256",
+			X:   base64.RawURLEncoding.EncodeToString(pub[1:33]),
+			Y:   base64.RawURLEncoding.EncodeToString(pub[33:]),
+		},
+		nonces: map[string]string{},
+	}, nil
+}
+
+// Thumbprint returns the JWK SHA-256 thumbprint of the public key, as per
+// [RFC 7638]. This is the value of the dpop_jkt parameter.
+//
+// [RFC 7638]: https://datatracker.ietf.org/doc/html/rfc7638
+func (k *DPoPKey) Thumbprint() string {
+	return k.jwk.thumbprint()
+}
+
+// Proof generates a new DPoP proof for a request with the given method and URL.
+// The accessToken must be set when accessing protected resources, and nonce must
+// be set when the server has provided one.
+func (k *DPoPKey) Proof(method, urlStr, accessToken, nonce string) (string, error) {
+	htu, err := dpopTargetURI(urlStr)
+	if err != nil {
+		return "", err
+	}
+
+	jti, err := newCode()
+	if err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(&dpopHeader{
+		Typ: dpopJWTType,
+		Alg: dpopAlgorithm,
+		JWK: k.jwk,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	claims := &dpopClaims{
+		JTI:   jti,
+		HTM:   method,
+		HTU:   htu,
+		IAT:   time.Now().Unix(),
+		Nonce: nonce,
+	}
+	if accessToken != "" {
+		claims.ATH = dpopAccessTokenHash(accessToken)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	r, s, err := ecdsa.Sign(cryptorand.Reader, k.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Transport returns an [http.RoundTripper] that adds a DPoP proof to every
+// request made through base. If base is nil, [http.DefaultTransport] is used.
+//
+// When the request carries an access token with the DPoP authorization scheme,
+// the proof is bound to the token. Nonces provided by servers through the
+// DPoP-Nonce header are remembered and, if a request is rejected with the
+// use_dpop_nonce error, it is retried once with the new nonce. Nonces are
+// shared by all transports created from the same key.
+func (k *DPoPKey) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &dpopTransport{
+		key:  k,
+		base: base,
+	}
+}
+
+func (k *DPoPKey) nonce(origin string) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.nonces[origin]
+}
+
+func (k *DPoPKey) setNonce(origin, nonce string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.nonces[origin] = nonce
+}
+
+type dpopTransport struct {
+	key  *DPoPKey
+	base http.RoundTripper
+}
+
+func (t *dpopTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	origin := req.URL.Scheme + "://" + req.URL.Host
+	nonce := t.key.nonce(origin)
+
+	res, err := t.roundTrip(req, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	newNonce := res.Header.Get(DPoPNonceHeader)
+	if newNonce == "" || newNonce == nonce {
+		return res, nil
+	}
+
+	t.key.setNonce(origin, newNonce)
+
+	if !isUseDPoPNonceError(res) || (req.Body != nil && req.GetBody == nil) {
+		return res, nil
+	}
+
+	_ = res.Body.Close()
+
+	retry := req
+	if req.Body != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry = req.Clone(req.Context())
+		retry.Body = body
+	}
+
+	return t.roundTrip(retry, newNonce)
+}
+
+func (t *dpopTransport) roundTrip(req *http.Request, nonce string) (*http.Response, error) {
+	var accessToken string
+	if scheme, token := AccessTokenFromRequest(req); scheme == TokenTypeDPoP {
+		accessToken = token
+	}
+
+	proof, err := t.key.Proof(req.Method, req.URL.String(), accessToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	req2 := req.Clone(req.Context())
+	req2.Header.Set(DPoPHeader, proof)
+	return t.base.RoundTrip(req2)
+}
+
+// isUseDPoPNonceError checks whether the response is an use_dpop_nonce error,
+// either from the token endpoint or from a resource server.
+func isUseDPoPNonceError(res *http.Response) bool {
+	switch res.StatusCode {
+	case http.StatusUnauthorized:
+		return strings.Contains(res.Header.Get("WWW-Authenticate"), "use_dpop_nonce")
+	case http.StatusBadRequest:
+		data, err := io.ReadAll(io.LimitReader(res.Body, 1<<16))
+		if err != nil {
+			return false
+		}
+		_ = res.Body.Close()
+		res.Body = io.NopCloser(bytes.NewReader(data))
+
+		var body struct {
+			Error string `json:"error"`
+		}
+		return json.Unmarshal(data, &body) == nil && body.Error == "use_dpop_nonce"
+	default:
+		return false
+	}
+}
+
+// AccessTokenFromRequest extracts the access token from the Authorization header
+// of the request. It returns the authorization scheme, which is either
+// [TokenTypeBearer] or [TokenTypeDPoP], and the token. If no token is found,
+// both values are empty.
+func AccessTokenFromRequest(r *http.Request) (string, string) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok {
+		return "", ""
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", ""
+	}
+
+	switch {
+	case strings.EqualFold(scheme, TokenTypeBearer):
+		return TokenTypeBearer, token
+	case strings.EqualFold(scheme, TokenTypeDPoP):
+		return TokenTypeDPoP, token
+	default:
+		return "", ""
+	}
+}
+
+type dpopJWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	D   string `json:"d,omitempty"`
+}
+
+// thumbprint computes the JWK SHA-256 thumbprint. The members are in
+// lexicographic order, as required by RFC 7638, section 3.
+func (j *dpopJWK) thumbprint() string {
+	s := fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, j.Crv, j.Kty, j.X, j.Y)
+	sum := sha256.Sum256([]byte(s))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+type dpopHeader struct {
+	Typ string   `json:"typ"`
+	Alg string   `json:"alg"`
+	JWK *dpopJWK `json:"jwk"`
+}
+
+type dpopClaims struct {
+	JTI   string `json:"jti"`
+	HTM   string `json:"htm"`
+	HTU   string `json:"htu"`
+	IAT   int64  `json:"iat"`
+	ATH   string `json:"ath,omitempty"`
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// dpopTargetURI returns the URI without query and fragment, as per RFC 9449,
+// section 4.2.
+func dpopTargetURI(urlStr string) (string, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return "", err
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.RawQuery = ""
+	u.ForceQuery = false
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.User = nil
+	return u.String(), nil
+}
+
+func dpopAccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,282 @@
+package indieauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDPoPProof(t *testing.T) {
+	t.Parallel()
+
+	key, err := NewDPoPKey()
+	require.NoError(t, err)
+
+	newRequest := func(t *testing.T, method, target, proof string) *http.Request {
+		r := httptest.NewRequest(method, target, nil)
+		if proof != "" {
+			r.Header.Set(DPoPHeader, proof)
+		}
+		return r
+	}
+
+	t.Run("Valid Proof", func(t *testing.T) {
+		t.Parallel()
+
+		v := NewDPoPVerifier(false)
+		proof, err := key.Proof(http.MethodPost, "http://example.com/token?foo=bar", "", "")
+		require.NoError(t, err)
+
+		p, err := v.Verify(newRequest(t, http.MethodPost, "http://example.com/token", proof), "")
+		require.NoError(t, err)
+		assert.Equal(t, key.Thumbprint(), p.Thumbprint)
+		assert.NotEmpty(t, p.JTI)
+	})
+
+	t.Run("Replayed Proof", func(t *testing.T) {
+		t.Parallel()
+
+		v := NewDPoPVerifier(false)
+		proof, err := key.Proof(http.MethodPost, "http://example.com/token", "", "")
+		require.NoError(t, err)
+
+		_, err = v.Verify(newRequest(t, http.MethodPost, "http://example.com/token", proof), "")
+		require.NoError(t, err)
+
+		_, err = v.Verify(newRequest(t, http.MethodPost, "http://example.com/token", proof), "")
+		assert.ErrorIs(t, err, ErrDPoPProofReplayed)
+	})
+
+	t.Run("Invalid Proofs", func(t *testing.T) {
+		t.Parallel()
+
+		v := NewDPoPVerifier(false)
+
+		_, err := v.Verify(newRequest(t, http.MethodPost, "http://example.com/token", ""), "")
+		assert.ErrorIs(t, err, ErrDPoPRequired)
+
+		_, err = v.Verify(newRequest(t, http.MethodPost, "http://example.com/token", "a.b.c"), "")
+		assert.ErrorIs(t, err, ErrDPoPInvalidProof)
+
+		proof, err := key.Proof(http.MethodGet, "http://example.com/token", "", "")
+		require.NoError(t, err)
+		_, err = v.Verify(newRequest(t, http.MethodPost, "http://example.com/token", proof), "")
+		assert.ErrorIs(t, err, ErrDPoPInvalidRequest)
+
+		proof, err = key.Proof(http.MethodPost, "http://example.com/other", "", "")
+		require.NoError(t, err)
+		_, err = v.Verify(newRequest(t, http.MethodPost, "http://example.com/token", proof), "")
+		assert.ErrorIs(t, err, ErrDPoPInvalidRequest)
+
+		// Tamper with the signature.
+		proof, err = key.Proof(http.MethodPost, "http://example.com/token", "", "")
+		require.NoError(t, err)
+		parts := strings.Split(proof, ".")
+		other, err := key.Proof(http.MethodPost, "http://example.com/token", "", "")
+		require.NoError(t, err)
+		parts[2] = strings.Split(other, ".")[2]
+		_, err = v.Verify(newRequest(t, http.MethodPost, "http://example.com/token", strings.Join(parts, ".")), "")
+		assert.ErrorIs(t, err, ErrDPoPInvalidProof)
+
+		// Expired proof.
+		v.now = func() time.Time { return time.Now().Add(time.Hour) }
+		proof, err = key.Proof(http.MethodPost, "http://example.com/token", "", "")
+		require.NoError(t, err)
+		_, err = v.Verify(newRequest(t, http.MethodPost, "http://example.com/token", proof), "")
+		assert.ErrorIs(t, err, ErrDPoPInvalidProof)
+	})
+
+	t.Run("Access Token Binding", func(t *testing.T) {
+		t.Parallel()
+
+		v := NewDPoPVerifier(false)
+
+		proof, err := key.Proof(http.MethodGet, "http://example.com/micropub", "token", "")
+		require.NoError(t, err)
+		_, err = v.VerifyBound(newRequest(t, http.MethodGet, "http://example.com/micropub", proof), "other-token", key.Thumbprint())
+		assert.ErrorIs(t, err, ErrDPoPTokenMismatch)
+
+		proof, err = key.Proof(http.MethodGet, "http://example.com/micropub", "token", "")
+		require.NoError(t, err)
+		_, err = v.VerifyBound(newRequest(t, http.MethodGet, "http://example.com/micropub", proof), "token", "other-thumbprint")
+		assert.ErrorIs(t, err, ErrDPoPKeyMismatch)
+
+		proof, err = key.Proof(http.MethodGet, "http://example.com/micropub", "token", "")
+		require.NoError(t, err)
+		_, err = v.VerifyBound(newRequest(t, http.MethodGet, "http://example.com/micropub", proof), "token", key.Thumbprint())
+		assert.NoError(t, err)
+	})
+
+	t.Run("Nonce", func(t *testing.T) {
+		t.Parallel()
+
+		v := NewDPoPVerifier(true)
+
+		proof, err := key.Proof(http.MethodPost, "http://example.com/token", "", "")
+		require.NoError(t, err)
+		_, err = v.Verify(newRequest(t, http.MethodPost, "http://example.com/token", proof), "")
+		assert.ErrorIs(t, err, ErrDPoPUseNonce)
+
+		w := httptest.NewRecorder()
+		v.SetNonce(w)
+		nonce := w.Header().Get(DPoPNonceHeader)
+		require.NotEmpty(t, nonce)
+
+		proof, err = key.Proof(http.MethodPost, "http://example.com/token", "", nonce)
+		require.NoError(t, err)
+		_, err = v.Verify(newRequest(t, http.MethodPost, "http://example.com/token", proof), "")
+		assert.NoError(t, err)
+
+		v.now = func() time.Time { return time.Now().Add(-v.NonceLifetime - time.Minute) }
+		w = httptest.NewRecorder()
+		v.SetNonce(w)
+		v.now = time.Now
+
+		proof, err = key.Proof(http.MethodPost, "http://example.com/token", "", w.Header().Get(DPoPNonceHeader))
+		require.NoError(t, err)
+		_, err = v.Verify(newRequest(t, http.MethodPost, "http://example.com/token", proof), "")
+		assert.ErrorIs(t, err, ErrDPoPUseNonce)
+	})
+}
+
+func TestAccessTokenFromRequest(t *testing.T) {
+	t.Parallel()
+
+	for _, testCase := range []struct {
+		header string
+		scheme string
+		token  string
+	}{
+		{"", "", ""},
+		{"Bearer", "", ""},
+		{"Bearer abc", TokenTypeBearer, "abc"},
+		{"bearer abc", TokenTypeBearer, "abc"},
+		{"DPoP abc", TokenTypeDPoP, "abc"},
+		{"Basic abc", "", ""},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", testCase.header)
+		scheme, token := AccessTokenFromRequest(r)
+		assert.Equal(t, testCase.scheme, scheme)
+		assert.Equal(t, testCase.token, token)
+	}
+}
+
+func TestDPoPTokenExchange(t *testing.T) {
+	t.Parallel()
+
+	key, err := NewDPoPKey()
+	require.NoError(t, err)
+
+	ias := NewServer(true, nil)
+	ias.DPoP = NewDPoPVerifier(true)
+	ias.DPoP.RequestURL = func(r *http.Request) string { return r.URL.String() }
+
+	cv, err := newVerifier()
+	require.NoError(t, err)
+
+	authReq := &AuthenticationRequest{
+		ClientID:            "https://example.com/",
+		RedirectURI:         "https://example.com/callback",
+		CodeChallenge:       s256Challenge(cv),
+		CodeChallengeMethod: "S256",
+		DPoPJKT:             key.Thumbprint(),
+	}
+
+	tokenRequests := 0
+	resourceRequests := 0
+
+	client := NewClient(
+		"https://example.com/",
+		"https://example.com/callback",
+		&http.Client{
+			Transport: &handlerRoundTripper{
+				handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					switch r.URL.Path {
+					case "/token":
+						tokenRequests++
+						proof, err := ias.ValidateDPoPTokenExchange(authReq, r)
+						if errors.Is(err, ErrDPoPUseNonce) {
+							ias.DPoP.SetNonce(w)
+							w.Header().Set("Content-Type", "application/json")
+							w.WriteHeader(http.StatusBadRequest)
+							_, _ = w.Write([]byte(`{"error":"use_dpop_nonce"}`))
+							return
+						}
+						if err != nil {
+							http.Error(w, err.Error(), http.StatusBadRequest)
+							return
+						}
+
+						w.Header().Set("Content-Type", "application/json")
+						_ = json.NewEncoder(w).Encode(map[string]any{
+							"me":           "https://example.com/",
+							"access_token": "token-" + proof.Thumbprint,
+							"token_type":   TokenTypeDPoP,
+						})
+					case "/micropub":
+						resourceRequests++
+						scheme, token := AccessTokenFromRequest(r)
+						if scheme != TokenTypeDPoP {
+							http.Error(w, "wrong scheme", http.StatusUnauthorized)
+							return
+						}
+
+						_, err := ias.DPoP.VerifyBound(r, token, strings.TrimPrefix(token, "token-"))
+						if errors.Is(err, ErrDPoPUseNonce) {
+							ias.DPoP.SetNonce(w)
+							w.Header().Set("WWW-Authenticate", `DPoP error="use_dpop_nonce"`)
+							w.WriteHeader(http.StatusUnauthorized)
+							return
+						}
+						if err != nil {
+							http.Error(w, err.Error(), http.StatusUnauthorized)
+							return
+						}
+						w.WriteHeader(http.StatusOK)
+					}
+				}),
+			},
+		},
+	)
+	client.DPoPKey = key
+
+	authInfo := &AuthInfo{
+		Metadata: Metadata{
+			TokenEndpoint: "https://example.com/token",
+		},
+		CodeVerifier: cv,
+	}
+
+	token, _, err := client.GetToken(context.Background(), authInfo, "code")
+	require.NoError(t, err)
+	assert.Equal(t, TokenTypeDPoP, token.Type())
+	assert.Equal(t, 2, tokenRequests)
+
+	// The nonce from the token endpoint is reused.
+	httpClient := client.HTTPClient(context.Background(), &authInfo.Metadata, token)
+	res, err := httpClient.Get("https://example.com/micropub?q=config")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, 1, resourceRequests)
+
+	// Requests without a proof are rejected, since the code is bound to the key.
+	r := httptest.NewRequest(http.MethodPost, "https://example.com/token", strings.NewReader(url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {authReq.ClientID},
+		"redirect_uri":  {authReq.RedirectURI},
+		"code_verifier": {cv},
+	}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	_, err = ias.ValidateDPoPTokenExchange(authReq, r)
+	assert.ErrorIs(t, err, ErrDPoPRequired)
+}
@@ -0,0 +1,323 @@
+package indieauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDPoPProofMaxAge is the default maximum age of a DPoP proof.
+	DefaultDPoPProofMaxAge = time.Minute * 5
+
+	// DefaultDPoPNonceLifetime is the default lifetime of a DPoP nonce.
+	DefaultDPoPNonceLifetime = time.Minute * 5
+)
+
+var (
+	ErrDPoPRequired       error = errors.New("dpop proof is required")
+	ErrDPoPUnsupported    error = errors.New("dpop is not supported")
+	ErrDPoPInvalidProof   error = errors.New("dpop proof is invalid")
+	ErrDPoPProofReplayed  error = errors.New("dpop proof has already been used")
+	ErrDPoPUseNonce       error = errors.New("dpop proof requires a valid nonce")
+	ErrDPoPKeyMismatch    error = errors.New("dpop proof key does not match the bound key")
+	ErrDPoPTokenMismatch  error = errors.New("dpop proof access token hash does not match")
+	ErrDPoPInvalidRequest error = errors.New("dpop proof does not match the request")
+)
+
+// DPoPProof is a validated DPoP proof.
+type DPoPProof struct {
+	// Thumbprint is the JWK SHA-256 thumbprint of the key used to sign the
+	// proof. Tokens issued for this proof must be bound to this thumbprint.
+	Thumbprint string
+	JTI        string
+	IssuedAt   time.Time
+}
+
+// DPoPVerifier verifies [DPoP] proofs, both at the token endpoint and at the
+// resource server. It keeps track of the proofs that have been used in order
+// to detect replays, and can optionally require server provided nonces.
+//
+// A DPoPVerifier is safe for concurrent use.
+//
+// [DPoP]: https://datatracker.ietf.org/doc/html/rfc9449
+type DPoPVerifier struct {
+	// MaxAge is the maximum age of a proof, as given by its iat claim.
+	MaxAge time.Duration
+
+	// RequireNonce makes the verifier require a nonce, issued with
+	// [DPoPVerifier.SetNonce], in all proofs.
+	RequireNonce bool
+
+	// NonceLifetime is the lifetime of a nonce.
+	NonceLifetime time.Duration
+
+	// RequestURL returns the URL of the request, which must match the htu claim
+	// of the proof. By default, the URL is built from the request's host and
+	// path, using https if the request was made over TLS. Set this if the server
+	// runs behind a reverse proxy.
+	RequestURL func(r *http.Request) string
+
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	secret []byte
+	now    func() time.Time
+}
+
+// NewDPoPVerifier creates a new [DPoPVerifier].
+func NewDPoPVerifier(requireNonce bool) *DPoPVerifier {
+	secret := make([]byte, 32)
+	_, err := cryptorand.Read(secret)
+	if err != nil {
+		panic(err)
+	}
+
+	return &DPoPVerifier{
+		MaxAge:        DefaultDPoPProofMaxAge,
+		RequireNonce:  requireNonce,
+		NonceLifetime: DefaultDPoPNonceLifetime,
+		seen:          map[string]time.Time{},
+		secret:        secret,
+		now:           time.Now,
+	}
+}
+
+// Verify verifies the DPoP proof in the given request, according to [RFC 9449],
+// section 4.3. If accessToken is not empty, the proof must be bound to it, as
+// it is required when accessing protected resources.
+//
+// If a nonce is required and the proof does not include a valid one, an
+// [ErrDPoPUseNonce] error is returned. In that case, call [DPoPVerifier.SetNonce]
+// and reply with an use_dpop_nonce error.
+//
+// [RFC 9449]: https://datatracker.ietf.org/doc/html/rfc9449#section-4.3
+func (v *DPoPVerifier) Verify(r *http.Request, accessToken string) (*DPoPProof, error) {
+	values := r.Header.Values(DPoPHeader)
+	if len(values) == 0 {
+		return nil, ErrDPoPRequired
+	}
+	if len(values) > 1 {
+		return nil, errors.Join(ErrDPoPInvalidProof, errors.New("multiple dpop headers"))
+	}
+
+	header, claims, err := parseDPoPProof(values[0])
+	if err != nil {
+		return nil, errors.Join(ErrDPoPInvalidProof, err)
+	}
+
+	if claims.HTM != r.Method {
+		return nil, errors.Join(ErrDPoPInvalidRequest, errors.New("htm does not match"))
+	}
+
+	htu, err := dpopTargetURI(claims.HTU)
+	if err != nil {
+		return nil, errors.Join(ErrDPoPInvalidProof, err)
+	}
+	if htu != v.requestURL(r) {
+		return nil, errors.Join(ErrDPoPInvalidRequest, errors.New("htu does not match"))
+	}
+
+	now := v.now()
+	iat := time.Unix(claims.IAT, 0)
+	if iat.Before(now.Add(-v.MaxAge)) || iat.After(now.Add(time.Minute)) {
+		return nil, errors.Join(ErrDPoPInvalidProof, errors.New("iat is outside the acceptable window"))
+	}
+
+	if accessToken != "" {
+		if subtle.ConstantTimeCompare([]byte(claims.ATH), []byte(dpopAccessTokenHash(accessToken))) != 1 {
+			return nil, ErrDPoPTokenMismatch
+		}
+	}
+
+	if v.RequireNonce && !v.validNonce(claims.Nonce) {
+		return nil, ErrDPoPUseNonce
+	}
+
+	thumbprint := header.JWK.thumbprint()
+	if !v.markSeen(thumbprint+"."+claims.JTI, iat.Add(v.MaxAge)) {
+		return nil, ErrDPoPProofReplayed
+	}
+
+	return &DPoPProof{
+		Thumbprint: thumbprint,
+		JTI:        claims.JTI,
+		IssuedAt:   iat,
+	}, nil
+}
+
+// VerifyBound verifies the DPoP proof of a request to a protected resource, made
+// with the given access token, and checks that the proof was made with the key
+// the token is bound to, identified by its thumbprint.
+func (v *DPoPVerifier) VerifyBound(r *http.Request, accessToken, thumbprint string) (*DPoPProof, error) {
+	proof, err := v.Verify(r, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(proof.Thumbprint), []byte(thumbprint)) != 1 {
+		return nil, ErrDPoPKeyMismatch
+	}
+
+	return proof, nil
+}
+
+// SetNonce sets a new nonce in the DPoP-Nonce header of the response. Clients
+// must include it in subsequent proofs.
+func (v *DPoPVerifier) SetNonce(w http.ResponseWriter) {
+	w.Header().Set(DPoPNonceHeader, v.newNonce())
+}
+
+// newNonce generates a stateless nonce, composed of the current time and its
+// HMAC with the verifier's secret.
+func (v *DPoPVerifier) newNonce() string {
+	b := make([]byte, 8, 8+sha256.Size)
+	binary.BigEndian.PutUint64(b, uint64(v.now().Unix()))
+	mac := hmac.New(sha256.New, v.secret)
+	_, _ = mac.Write(b)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(b))
+}
+
+func (v *DPoPVerifier) validNonce(nonce string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(b) != 8+sha256.Size {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, v.secret)
+	_, _ = mac.Write(b[:8])
+	if !hmac.Equal(mac.Sum(nil), b[8:]) {
+		return false
+	}
+
+	issued := time.Unix(int64(binary.BigEndian.Uint64(b[:8])), 0)
+	return !v.now().After(issued.Add(v.NonceLifetime))
+}
+
+// markSeen records the proof identifier until the given expiration. Returns
+// false if the identifier has already been seen.
+func (v *DPoPVerifier) markSeen(id string, expiration time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := v.now()
+	for k, exp := range v.seen {
+		if now.After(exp) {
+			delete(v.seen, k)
+		}
+	}
+
+	if _, ok := v.seen[id]; ok {
+		return false
+	}
+
+	v.seen[id] = expiration
+	return true
+}
+
+func (v *DPoPVerifier) requestURL(r *http.Request) string {
+	var urlStr string
+	if v.RequestURL != nil {
+		urlStr = v.RequestURL(r)
+	} else {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		urlStr = scheme + "://" + r.Host + r.URL.EscapedPath()
+	}
+
+	u, err := dpopTargetURI(urlStr)
+	if err != nil {
+		return ""
+	}
+	return u
+}
+
+// parseDPoPProof parses a DPoP proof JWT and verifies its signature with the
+// embedded public key.
+func parseDPoPProof(proof string) (*dpopHeader, *dpopClaims, error) {
+	parts := strings.Split(proof, ".")
+	if len(parts) != 3 {
+		return nil, nil, errors.New("malformed jwt")
+	}
+
+	var header dpopHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, nil, err
+	}
+
+	if header.Typ != dpopJWTType {
+		return nil, nil, errors.New("typ must be dpop+jwt")
+	}
+
+	if header.Alg != dpopAlgorithm {
+		return nil, nil, errors.New("unsupported alg")
+	}
+
+	if header.JWK == nil || header.JWK.Kty != "EC" || header.JWK.Crv != "P-256" {
+		return nil, nil, errors.New("jwk must be a P-256 public key")
+	}
+
+	if header.JWK.D != "" {
+		return nil, nil, errors.New("jwk must not contain a private key")
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(header.JWK.X)
+	if err != nil || len(x) != 32 {
+		return nil, nil, errors.New("invalid jwk x coordinate")
+	}
+
+	y, err := base64.RawURLEncoding.DecodeString(header.JWK.Y)
+	if err != nil || len(y) != 32 {
+		return nil, nil, errors.New("invalid jwk y coordinate")
+	}
+
+	key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(signature) != 64 {
+		return nil, nil, errors.New("invalid signature")
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	rInt := new(big.Int).SetBytes(signature[:32])
+	sInt := new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(key, digest[:], rInt, sInt) {
+		return nil, nil, errors.New("invalid signature")
+	}
+
+	var claims dpopClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, nil, err
+	}
+
+	if claims.JTI == "" || claims.HTM == "" || claims.HTU == "" || claims.IAT == 0 {
+		return nil, nil, errors.New("missing required claims")
+	}
+
+	return &header, &claims, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,155 @@
+package indieauth
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// EventType is the type of an [Event].
+type EventType string
+
+const (
+	EventAuthorizationRequested EventType = "authorization_requested"
+	EventAuthorizationApproved  EventType = "authorization_approved"
+	EventAuthorizationDenied    EventType = "authorization_denied"
+	EventCodeExchanged          EventType = "code_exchanged"
+	EventTokenRefreshed         EventType = "token_refreshed"
+	EventTokenRevoked           EventType = "token_revoked"
+	EventVerificationFailed     EventType = "verification_failed"
+)
+
+// Event is a structured audit event emitted during the IndieAuth flow.
+type Event struct {
+	Type        EventType `json:"type"`
+	Time        time.Time `json:"time"`
+	Me          string    `json:"me,omitempty"`
+	ClientID    string    `json:"client_id,omitempty"`
+	RedirectURI string    `json:"redirect_uri,omitempty"`
+	Scopes      []string  `json:"scopes,omitempty"`
+	RemoteAddr  string    `json:"remote_addr,omitempty"`
+
+	// Reason is the reason of a failure, denial or revocation.
+	Reason string `json:"reason,omitempty"`
+
+	// Err is the error that caused a failure, if any.
+	Err error `json:"-"`
+}
+
+// EventObserver observes audit events.
+type EventObserver interface {
+	Observe(ctx context.Context, e *Event)
+}
+
+// EventObserverFunc is an adapter to allow the use of ordinary functions as
+// an [EventObserver].
+type EventObserverFunc func(ctx context.Context, e *Event)
+
+func (f EventObserverFunc) Observe(ctx context.Context, e *Event) {
+	f(ctx, e)
+}
+
+// MultiObserver creates an [EventObserver] that forwards each event to all
+// the given observers.
+func MultiObserver(observers ...EventObserver) EventObserver {
+	return EventObserverFunc(func(ctx context.Context, e *Event) {
+		for _, o := range observers {
+			o.Observe(ctx, e)
+		}
+	})
+}
+
+// NewSlogObserver creates an [EventObserver] that logs events with the given
+// logger. Verification failures are logged at the warning level, while all
+// other events are logged at the info level.
+func NewSlogObserver(logger *slog.Logger) EventObserver {
+	return EventObserverFunc(func(ctx context.Context, e *Event) {
+		level := slog.LevelInfo
+		if e.Type == EventVerificationFailed {
+			level = slog.LevelWarn
+		}
+
+		attrs := []slog.Attr{slog.String("type", string(e.Type))}
+		if e.Me != "" {
+			attrs = append(attrs, slog.String("me", e.Me))
+		}
+		if e.ClientID != "" {
+			attrs = append(attrs, slog.String("client_id", e.ClientID))
+		}
+		if e.RedirectURI != "" {
+			attrs = append(attrs, slog.String("redirect_uri", e.RedirectURI))
+		}
+		if len(e.Scopes) != 0 {
+			attrs = append(attrs, slog.Any("scopes", e.Scopes))
+		}
+		if e.RemoteAddr != "" {
+			attrs = append(attrs, slog.String("remote_addr", e.RemoteAddr))
+		}
+		if e.Reason != "" {
+			attrs = append(attrs, slog.String("reason", e.Reason))
+		}
+
+		logger.LogAttrs(ctx, level, "indieauth event", attrs...)
+	})
+}
+
+// NewJSONLinesObserver creates an [EventObserver] that writes each event as a
+// single line of JSON to the given writer. Write errors are ignored.
+func NewJSONLinesObserver(w io.Writer) EventObserver {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+
+	return EventObserverFunc(func(_ context.Context, e *Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		_ = enc.Encode(e)
+	})
+}
+
+// Emit emits an event of the given type to [Server.Observer]. The event is
+// filled with the information from the request and the authentication request,
+// both of which may be nil. If err is not nil, it is used as the reason.
+//
+// The server emits [EventAuthorizationRequested], [EventCodeExchanged] and
+// [EventVerificationFailed] on its own. Use this function to emit the events
+// that depend on your implementation, such as [EventAuthorizationApproved]
+// when the user approves a request, or [EventTokenRevoked] when a token is
+// revoked.
+func (s *Server) Emit(r *http.Request, typ EventType, req *AuthenticationRequest, err error) {
+	if s.Observer == nil {
+		return
+	}
+
+	e := &Event{
+		Type: typ,
+		Time: time.Now(),
+		Err:  err,
+	}
+
+	if err != nil {
+		e.Reason = err.Error()
+	}
+
+	ctx := context.Background()
+	if r != nil {
+		ctx = r.Context()
+		e.RemoteAddr = r.RemoteAddr
+		if r.Form != nil {
+			e.ClientID = r.Form.Get("client_id")
+			e.RedirectURI = r.Form.Get("redirect_uri")
+		}
+	}
+
+	if req != nil {
+		e.Me = req.Me
+		e.ClientID = req.ClientID
+		e.RedirectURI = req.RedirectURI
+		e.Scopes = req.Scopes
+	}
+
+	s.Observer.Observe(ctx, e)
+}
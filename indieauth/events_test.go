@@ -0,0 +1,129 @@
+package indieauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvents(t *testing.T) {
+	t.Parallel()
+
+	var events []*Event
+	ias := NewServer(false, &http.Client{
+		Transport: &handlerRoundTripper{
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				_, _ = w.Write([]byte(`<html></html>`))
+			}),
+		},
+	})
+	ias.Observer = EventObserverFunc(func(_ context.Context, e *Event) {
+		events = append(events, e)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/auth?"+url.Values{
+		"response_type": {"code"},
+		"client_id":     {"https://example.com/"},
+		"redirect_uri":  {"https://example.com/callback"},
+		"scope":         {"create profile"},
+	}.Encode(), nil)
+	req, err := ias.ParseAuthorization(r)
+	require.NoError(t, err)
+
+	r = httptest.NewRequest(http.MethodGet, "/auth?client_id=https://example.com/&redirect_uri=https://example.org/", nil)
+	_, err = ias.ParseAuthorization(r)
+	require.Error(t, err)
+
+	req.Me = "https://example.org/"
+	ias.Emit(r, EventAuthorizationApproved, req, nil)
+
+	r = httptest.NewRequest(http.MethodPost, "/token", nil)
+	r.Form = url.Values{
+		"client_id":    {"https://example.com/"},
+		"redirect_uri": {"https://example.com/callback"},
+	}
+	require.NoError(t, ias.ValidateTokenExchange(req, r))
+
+	r.Form.Set("client_id", "https://example.net/")
+	require.ErrorIs(t, ias.ValidateTokenExchange(req, r), ErrNoMatchClientID)
+
+	require.Len(t, events, 5)
+
+	assert.Equal(t, EventAuthorizationRequested, events[0].Type)
+	assert.Equal(t, "https://example.com/", events[0].ClientID)
+	assert.Equal(t, []string{"create", "profile"}, events[0].Scopes)
+	assert.Equal(t, "192.0.2.1:1234", events[0].RemoteAddr)
+	assert.False(t, events[0].Time.IsZero())
+
+	assert.Equal(t, EventVerificationFailed, events[1].Type)
+	assert.Equal(t, "https://example.com/", events[1].ClientID)
+	assert.ErrorIs(t, events[1].Err, ErrInvalidRedirectURI)
+	assert.ErrorIs(t, events[1].Err, ErrRedirectURINotPublished)
+	assert.NotEmpty(t, events[1].Reason)
+
+	assert.Equal(t, EventAuthorizationApproved, events[2].Type)
+	assert.Equal(t, "https://example.org/", events[2].Me)
+
+	assert.Equal(t, EventCodeExchanged, events[3].Type)
+	assert.Equal(t, EventVerificationFailed, events[4].Type)
+	assert.ErrorIs(t, events[4].Err, ErrNoMatchClientID)
+}
+
+func TestEventSinks(t *testing.T) {
+	t.Parallel()
+
+	e := &Event{
+		Type:     EventVerificationFailed,
+		ClientID: "https://example.com/",
+		Scopes:   []string{"create"},
+		Reason:   "code challenge failed",
+		Err:      errors.New("code challenge failed"),
+	}
+
+	t.Run("JSON Lines", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+		o := NewJSONLinesObserver(&buf)
+		o.Observe(context.Background(), e)
+		o.Observe(context.Background(), e)
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		require.Len(t, lines, 2)
+
+		var decoded map[string]any
+		require.NoError(t, json.Unmarshal([]byte(lines[0]), &decoded))
+		assert.Equal(t, "verification_failed", decoded["type"])
+		assert.Equal(t, "https://example.com/", decoded["client_id"])
+		assert.Equal(t, "code challenge failed", decoded["reason"])
+		assert.NotContains(t, decoded, "me")
+	})
+
+	t.Run("Slog", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+		o := NewSlogObserver(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+		var count int
+		MultiObserver(o, EventObserverFunc(func(context.Context, *Event) { count++ })).Observe(context.Background(), e)
+		assert.Equal(t, 1, count)
+
+		var decoded map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+		assert.Equal(t, "WARN", decoded["level"])
+		assert.Equal(t, "verification_failed", decoded["type"])
+		assert.Equal(t, "https://example.com/", decoded["client_id"])
+	})
+}
@@ -0,0 +1,420 @@
+package indieauth
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrGrantNotFound is returned when a grant does not exist.
+var ErrGrantNotFound error = errors.New("grant not found")
+
+// Grant represents the access a user (me) has granted to a client. A grant
+// aggregates all the tokens issued to the client on behalf of the user.
+type Grant struct {
+	Me          string               `json:"me"`
+	ClientID    string               `json:"client_id"`
+	Scopes      []string             `json:"scopes"`
+	IssuedAt    time.Time            `json:"issued_at"`
+	LastUsed    time.Time            `json:"last_used,omitzero"`
+	Application *ApplicationMetadata `json:"application,omitempty"`
+
+	// Tokens are the tokens issued as part of this grant. They are not exposed
+	// by the management handler.
+	Tokens []string `json:"-"`
+}
+
+// GrantStore stores grants, keyed by the user's profile URL and the client ID.
+type GrantStore interface {
+	// Get returns the grant for the given user and client. Must return
+	// [ErrGrantNotFound] if the grant does not exist.
+	Get(ctx context.Context, me, clientID string) (*Grant, error)
+
+	// Update atomically updates the grant for the given user and client. The
+	// update function receives the existing grant, or nil if there is none, and
+	// returns the grant to store. If the update function returns an error, the
+	// grant is not stored and the error is returned.
+	Update(ctx context.Context, me, clientID string, update func(grant *Grant) (*Grant, error)) (*Grant, error)
+
+	// List returns all the grants of the given user.
+	List(ctx context.Context, me string) ([]*Grant, error)
+
+	// Delete atomically deletes and returns the grant for the given user and
+	// client. Must return [ErrGrantNotFound] if the grant does not exist.
+	Delete(ctx context.Context, me, clientID string) (*Grant, error)
+}
+
+type grantKey struct {
+	me       string
+	clientID string
+}
+
+type memoryGrantStore struct {
+	mu     sync.RWMutex
+	grants map[grantKey]*Grant
+}
+
+// NewMemoryGrantStore creates a new in-memory [GrantStore].
+func NewMemoryGrantStore() GrantStore {
+	return &memoryGrantStore{
+		grants: map[grantKey]*Grant{},
+	}
+}
+
+func (m *memoryGrantStore) Get(_ context.Context, me, clientID string) (*Grant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	g, ok := m.grants[grantKey{me, clientID}]
+	if !ok {
+		return nil, ErrGrantNotFound
+	}
+
+	return copyGrant(g), nil
+}
+
+func (m *memoryGrantStore) Update(_ context.Context, me, clientID string, update func(grant *Grant) (*Grant, error)) (*Grant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := grantKey{me, clientID}
+
+	var existing *Grant
+	if g, ok := m.grants[key]; ok {
+		existing = copyGrant(g)
+	}
+
+	grant, err := update(existing)
+	if err != nil {
+		return nil, err
+	}
+
+	m.grants[key] = copyGrant(grant)
+	return copyGrant(grant), nil
+}
+
+func (m *memoryGrantStore) List(_ context.Context, me string) ([]*Grant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	grants := []*Grant{}
+	for k, g := range m.grants {
+		if k.me == me {
+			grants = append(grants, copyGrant(g))
+		}
+	}
+
+	return grants, nil
+}
+
+func (m *memoryGrantStore) Delete(_ context.Context, me, clientID string) (*Grant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := grantKey{me, clientID}
+	g, ok := m.grants[key]
+	if !ok {
+		return nil, ErrGrantNotFound
+	}
+
+	delete(m.grants, key)
+	return g, nil
+}
+
+func copyGrant(g *Grant) *Grant {
+	cp := *g
+	cp.Scopes = slices.Clone(g.Scopes)
+	cp.Tokens = slices.Clone(g.Tokens)
+	if g.Application != nil {
+		app := *g.Application
+		cp.Application = &app
+	}
+	return &cp
+}
+
+// Grants manages the grants, or "connected apps", of the users of a server. It
+// allows users to see which applications have access to their account and to
+// revoke that access.
+type Grants struct {
+	Store  GrantStore
+	Server *Server
+
+	// OnRevoke is called when a grant is revoked, such that all the tokens of
+	// the grant can be invalidated.
+	OnRevoke func(ctx context.Context, grant *Grant) error
+
+	// CSRFToken returns the CSRF token of the request, which is included in the
+	// forms of the management page. It is optional. See [OwnerSession.CSRFToken].
+	CSRFToken func(r *http.Request) string
+}
+
+// NewGrants creates a new [Grants]. The server is used to discover the metadata
+// of the applications. If no store is given, grants are kept in memory.
+func NewGrants(server *Server, store GrantStore, onRevoke func(ctx context.Context, grant *Grant) error) *Grants {
+	if store == nil {
+		store = NewMemoryGrantStore()
+	}
+
+	return &Grants{
+		Store:    store,
+		Server:   server,
+		OnRevoke: onRevoke,
+	}
+}
+
+// Record records that the given token was issued for the given request. The
+// request must have [AuthenticationRequest.Me] set. The scopes are aggregated
+// with the ones of previous tokens issued to the same client. The application
+// metadata is discovered on a best effort basis, the first time a grant is
+// recorded, and before the grant is updated.
+func (g *Grants) Record(ctx context.Context, req *AuthenticationRequest, token string) (*Grant, error) {
+	var app *ApplicationMetadata
+	if g.Server != nil {
+		existing, err := g.Store.Get(ctx, req.Me, req.ClientID)
+		if err != nil && !errors.Is(err, ErrGrantNotFound) {
+			return nil, err
+		}
+		if existing == nil || existing.Application == nil {
+			app, _ = g.Server.DiscoverApplicationMetadata(ctx, req.ClientID)
+		}
+	}
+
+	return g.Store.Update(ctx, req.Me, req.ClientID, func(grant *Grant) (*Grant, error) {
+		if grant == nil {
+			grant = &Grant{
+				Me:       req.Me,
+				ClientID: req.ClientID,
+				Scopes:   []string{},
+				IssuedAt: time.Now(),
+			}
+		}
+
+		for _, scope := range req.Scopes {
+			if !slices.Contains(grant.Scopes, scope) {
+				grant.Scopes = append(grant.Scopes, scope)
+			}
+		}
+
+		if token != "" {
+			grant.Tokens = append(grant.Tokens, token)
+		}
+
+		if grant.Application == nil {
+			grant.Application = app
+		}
+
+		return grant, nil
+	})
+}
+
+// Touch updates the last use time of the grant for the given user and client.
+// Call it whenever a token of the grant is used.
+func (g *Grants) Touch(ctx context.Context, me, clientID string) error {
+	_, err := g.Store.Update(ctx, me, clientID, func(grant *Grant) (*Grant, error) {
+		if grant == nil {
+			return nil, ErrGrantNotFound
+		}
+
+		grant.LastUsed = time.Now()
+		return grant, nil
+	})
+	return err
+}
+
+// List returns the grants of the given user, most recently used first.
+func (g *Grants) List(ctx context.Context, me string) ([]*Grant, error) {
+	grants, err := g.Store.List(ctx, me)
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(grants, func(a, b *Grant) int {
+		if c := grantLastActivity(b).Compare(grantLastActivity(a)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ClientID, b.ClientID)
+	})
+
+	return grants, nil
+}
+
+// Revoke revokes the grant for the given user and client.
+func (g *Grants) Revoke(ctx context.Context, me, clientID string) error {
+	return g.revoke(ctx, me, clientID)
+}
+
+// RevokeAll revokes all the grants of the given user.
+func (g *Grants) RevokeAll(ctx context.Context, me string) error {
+	grants, err := g.Store.List(ctx, me)
+	if err != nil {
+		return err
+	}
+
+	for _, grant := range grants {
+		err := g.revoke(ctx, grant.Me, grant.ClientID)
+		if err != nil && !errors.Is(err, ErrGrantNotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// revoke deletes the grant before revoking its tokens, such that tokens that
+// are recorded concurrently end up in a new grant, instead of being lost. If
+// the tokens cannot be revoked, they are recorded again, such that revoking
+// can be retried.
+func (g *Grants) revoke(ctx context.Context, me, clientID string) error {
+	grant, err := g.Store.Delete(ctx, me, clientID)
+	if err != nil {
+		return err
+	}
+
+	if g.OnRevoke != nil {
+		if err := g.OnRevoke(ctx, grant); err != nil {
+			_, restoreErr := g.Store.Update(ctx, me, clientID, func(current *Grant) (*Grant, error) {
+				if current == nil {
+					return grant, nil
+				}
+				current.Tokens = append(grant.Tokens, current.Tokens...)
+				return current, nil
+			})
+			return errors.Join(err, restoreErr)
+		}
+	}
+
+	if g.Server != nil {
+		g.Server.Emit(nil, EventTokenRevoked, &AuthenticationRequest{
+			Me:       grant.Me,
+			ClientID: grant.ClientID,
+			Scopes:   grant.Scopes,
+		}, nil)
+	}
+
+	return nil
+}
+
+func grantLastActivity(g *Grant) time.Time {
+	if g.LastUsed.After(g.IssuedAt) {
+		return g.LastUsed
+	}
+	return g.IssuedAt
+}
+
+var grantsTemplate = template.Must(template.New("grants").Parse(`<!DOCTYPE html>
+<html>
+  <head>
+    <title>Connected Applications</title>
+  </head>
+  <body>
+    <h1>Connected Applications</h1>
+    {{ range .Grants }}
+      <form method="post">
+        <h2>
+          {{ with .Application }}{{ with .Logo }}<img style="width: 1em; vertical-align: middle;" src="{{ . }}"> {{ end }}{{ end }}
+          {{ if and .Application .Application.Name }}{{ .Application.Name }}{{ else }}{{ .ClientID }}{{ end }}
+        </h2>
+        <ul>
+          <li><strong>Client:</strong> <code>{{ .ClientID }}</code></li>
+          <li><strong>Scopes:</strong>{{ range .Scopes }} <code>{{ . }}</code>{{ end }}</li>
+          <li><strong>Authorized:</strong> {{ .IssuedAt.Format "2006-01-02 15:04" }}</li>
+          {{ if not .LastUsed.IsZero }}<li><strong>Last used:</strong> {{ .LastUsed.Format "2006-01-02 15:04" }}</li>{{ end }}
+        </ul>
+        <input type="hidden" name="client_id" value="{{ .ClientID }}">
+        {{ with $.CSRFToken }}<input type="hidden" name="csrf_token" value="{{ . }}">{{ end }}
+        <button>Revoke</button>
+      </form>
+    {{ else }}
+      <p>No applications have access to your account.</p>
+    {{ end }}
+    {{ if .Grants }}
+      <form method="post">
+        <input type="hidden" name="action" value="revoke-all">
+        {{ with $.CSRFToken }}<input type="hidden" name="csrf_token" value="{{ . }}">{{ end }}
+        <button>Revoke all</button>
+      </form>
+    {{ end }}
+  </body>
+</html>`))
+
+// Handler returns an [http.Handler] that allows a user to manage their grants.
+// The me function must return the profile URL of the authenticated user, or an
+// empty string if the user is not authenticated. The handler must be protected
+// against cross-site request forgery, e.g., by having the me function verify the
+// CSRF token of POST requests with [OwnerAuth.VerifyCSRF], and setting
+// [Grants.CSRFToken].
+//
+// A GET request lists the grants, either as HTML or as JSON, depending on the
+// Accept header. A POST request revokes the grant of the client given by the
+// client_id parameter or, if the action parameter is "revoke-all", all grants.
+func (g *Grants) Handler(me func(r *http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := me(r)
+		if user == "" {
+			serveErrorJSON(w, http.StatusUnauthorized, "unauthorized", "authentication required")
+			return
+		}
+
+		wantsJSON := strings.Contains(r.Header.Get("Accept"), "application/json")
+
+		switch r.Method {
+		case http.MethodGet:
+			grants, err := g.List(r.Context(), user)
+			if err != nil {
+				serveInternalError(w, g.Server.logger(), err)
+				return
+			}
+
+			if wantsJSON {
+				serveJSON(w, http.StatusOK, map[string]any{"grants": grants})
+				return
+			}
+
+			var csrfToken string
+			if g.CSRFToken != nil {
+				csrfToken = g.CSRFToken(r)
+			}
+
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_ = grantsTemplate.Execute(w, map[string]any{"Grants": grants, "CSRFToken": csrfToken})
+		case http.MethodPost:
+			if err := r.ParseForm(); err != nil {
+				serveErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
+				return
+			}
+
+			var err error
+			if r.Form.Get("action") == "revoke-all" {
+				err = g.RevokeAll(r.Context(), user)
+			} else if clientID := r.Form.Get("client_id"); clientID != "" {
+				err = g.Revoke(r.Context(), user, clientID)
+			} else {
+				serveErrorJSON(w, http.StatusBadRequest, "invalid_request", "client_id or action must be provided")
+				return
+			}
+
+			if errors.Is(err, ErrGrantNotFound) {
+				serveErrorJSON(w, http.StatusNotFound, "invalid_request", err.Error())
+				return
+			} else if err != nil {
+				serveInternalError(w, g.Server.logger(), err)
+				return
+			}
+
+			if wantsJSON {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			http.Redirect(w, r, r.URL.String(), http.StatusSeeOther)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			serveErrorJSON(w, http.StatusMethodNotAllowed, "invalid_request", "method not allowed")
+		}
+	})
+}
@@ -0,0 +1,222 @@
+package indieauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGrants(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	server := NewServer(false, &http.Client{
+		Transport: &handlerRoundTripper{
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				_, _ = w.Write([]byte(`<div class="h-app"><a href="/" class="u-url p-name">Example App</a></div>`))
+			}),
+		},
+	})
+
+	newGrants := func(t *testing.T) (*Grants, *[]string) {
+		revoked := []string{}
+		grants := NewGrants(server, nil, func(_ context.Context, grant *Grant) error {
+			revoked = append(revoked, grant.Tokens...)
+			return nil
+		})
+
+		_, err := grants.Record(ctx, &AuthenticationRequest{
+			Me:       "https://example.org/",
+			ClientID: "https://example.com/",
+			Scopes:   []string{"create"},
+		}, "token-1")
+		require.NoError(t, err)
+
+		grant, err := grants.Record(ctx, &AuthenticationRequest{
+			Me:       "https://example.org/",
+			ClientID: "https://example.com/",
+			Scopes:   []string{"create", "update"},
+		}, "token-2")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"create", "update"}, grant.Scopes)
+		assert.Equal(t, []string{"token-1", "token-2"}, grant.Tokens)
+		require.NotNil(t, grant.Application)
+		assert.Equal(t, "Example App", grant.Application.Name)
+
+		_, err = grants.Record(ctx, &AuthenticationRequest{
+			Me:       "https://example.org/",
+			ClientID: "https://example.net/",
+			Scopes:   []string{"profile"},
+		}, "token-3")
+		require.NoError(t, err)
+
+		_, err = grants.Record(ctx, &AuthenticationRequest{
+			Me:       "https://example.net/",
+			ClientID: "https://example.com/",
+		}, "token-4")
+		require.NoError(t, err)
+
+		return grants, &revoked
+	}
+
+	t.Run("List and Revoke", func(t *testing.T) {
+		t.Parallel()
+
+		grants, revoked := newGrants(t)
+
+		require.NoError(t, grants.Touch(ctx, "https://example.org/", "https://example.com/"))
+		assert.ErrorIs(t, grants.Touch(ctx, "https://example.org/", "https://unknown.com/"), ErrGrantNotFound)
+
+		list, err := grants.List(ctx, "https://example.org/")
+		require.NoError(t, err)
+		require.Len(t, list, 2)
+		assert.Equal(t, "https://example.com/", list[0].ClientID)
+		assert.False(t, list[0].LastUsed.IsZero())
+		assert.Equal(t, "https://example.net/", list[1].ClientID)
+
+		require.NoError(t, grants.Revoke(ctx, "https://example.org/", "https://example.com/"))
+		assert.Equal(t, []string{"token-1", "token-2"}, *revoked)
+		assert.ErrorIs(t, grants.Revoke(ctx, "https://example.org/", "https://example.com/"), ErrGrantNotFound)
+
+		require.NoError(t, grants.RevokeAll(ctx, "https://example.org/"))
+		assert.Equal(t, []string{"token-1", "token-2", "token-3"}, *revoked)
+
+		list, err = grants.List(ctx, "https://example.org/")
+		require.NoError(t, err)
+		assert.Empty(t, list)
+
+		list, err = grants.List(ctx, "https://example.net/")
+		require.NoError(t, err)
+		assert.Len(t, list, 1)
+	})
+
+	t.Run("Concurrent Record and Revoke", func(t *testing.T) {
+		t.Parallel()
+
+		var mu sync.Mutex
+		revoked := []string{}
+		grants := NewGrants(nil, nil, func(_ context.Context, grant *Grant) error {
+			mu.Lock()
+			defer mu.Unlock()
+			revoked = append(revoked, grant.Tokens...)
+			return nil
+		})
+
+		var wg sync.WaitGroup
+		for i := range 50 {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				_, err := grants.Record(ctx, &AuthenticationRequest{
+					Me:       "https://example.org/",
+					ClientID: "https://example.com/",
+				}, fmt.Sprintf("token-%d", i))
+				assert.NoError(t, err)
+			}()
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, grants.RevokeAll(ctx, "https://example.org/"))
+			}()
+		}
+		wg.Wait()
+
+		require.NoError(t, grants.RevokeAll(ctx, "https://example.org/"))
+
+		// Every recorded token is revoked exactly once.
+		assert.Len(t, revoked, 50)
+		assert.Len(t, slices.Compact(slices.Sorted(slices.Values(revoked))), 50)
+	})
+
+	t.Run("Failed Revoke", func(t *testing.T) {
+		t.Parallel()
+
+		grants, _ := newGrants(t)
+		grants.OnRevoke = func(_ context.Context, grant *Grant) error {
+			return errors.New("revocation failed")
+		}
+
+		assert.Error(t, grants.Revoke(ctx, "https://example.org/", "https://example.com/"))
+
+		grant, err := grants.Store.Get(ctx, "https://example.org/", "https://example.com/")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"token-1", "token-2"}, grant.Tokens)
+	})
+
+	t.Run("Handler", func(t *testing.T) {
+		t.Parallel()
+
+		grants, revoked := newGrants(t)
+		grants.CSRFToken = func(r *http.Request) string { return "csrf" }
+		handler := grants.Handler(func(r *http.Request) string {
+			if r.Header.Get("Authorization") == "" {
+				return ""
+			}
+			return "https://example.org/"
+		})
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/apps", nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/apps", nil)
+		r.Header.Set("Authorization", "yes")
+		r.Header.Set("Accept", "application/json")
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var body struct {
+			Grants []map[string]any `json:"grants"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		require.Len(t, body.Grants, 2)
+		assert.NotContains(t, body.Grants[0], "tokens")
+		assert.NotContains(t, body.Grants[0], "Tokens")
+
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodGet, "/apps", nil)
+		r.Header.Set("Authorization", "yes")
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "Example App")
+		assert.Contains(t, w.Body.String(), "https://example.net/")
+		assert.Contains(t, w.Body.String(), `<input type="hidden" name="csrf_token" value="csrf">`)
+
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodPost, "/apps", strings.NewReader(url.Values{"client_id": {"https://example.net/"}}.Encode()))
+		r.Header.Set("Authorization", "yes")
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		assert.Equal(t, []string{"token-3"}, *revoked)
+
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodPost, "/apps", strings.NewReader(url.Values{"action": {"revoke-all"}}.Encode()))
+		r.Header.Set("Authorization", "yes")
+		r.Header.Set("Accept", "application/json")
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, []string{"token-3", "token-1", "token-2"}, *revoked)
+
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodPost, "/apps", strings.NewReader(url.Values{"client_id": {"https://example.net/"}}.Encode()))
+		r.Header.Set("Authorization", "yes")
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -0,0 +1,27 @@
+package indieauth
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+func serveJSON(w http.ResponseWriter, code int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(data)
+}
+
+func serveErrorJSON(w http.ResponseWriter, code int, err, errDescription string) {
+	serveJSON(w, code, map[string]string{
+		"error":             err,
+		"error_description": errDescription,
+	})
+}
+
+// serveInternalError logs the given error and replies with a generic
+// server_error, such that no internal details are leaked to the client.
+func serveInternalError(w http.ResponseWriter, logger *slog.Logger, err error) {
+	logger.Error("indieauth: internal server error", "error", err)
+	serveErrorJSON(w, http.StatusInternalServerError, "server_error", "Internal server error.")
+}
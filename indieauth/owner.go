@@ -0,0 +1,430 @@
+package indieauth
+
+import (
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// DefaultSessionLifetime is the default lifetime of an owner session.
+	DefaultSessionLifetime = time.Hour * 24
+
+	// DefaultSessionCookieName is the default name of the owner session cookie.
+	DefaultSessionCookieName = "indieauth_session"
+
+	// CSRFTokenField is the name of the form field that must hold the CSRF
+	// token of the session. See [OwnerSession.CSRFToken].
+	CSRFTokenField = "csrf_token"
+)
+
+// Argon2id parameters, as per the second recommended option of RFC 9106.
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+
+	// Upper bounds of the parameters of the hashes given to [VerifyPassword],
+	// such that a crafted hash cannot exhaust the memory or the CPU.
+	argon2MaxTime    = 16
+	argon2MaxMemory  = 1024 * 1024
+	argon2MaxThreads = 16
+	argon2MaxKeyLen  = 128
+)
+
+var (
+	ErrInvalidCredentials      error = errors.New("invalid credentials")
+	ErrTOTPRequired            error = errors.New("totp code required")
+	ErrUnsupportedPasswordHash error = errors.New("password hash is not supported")
+	ErrNoSession               error = errors.New("owner is not authenticated")
+	ErrInvalidCSRFToken        error = errors.New("csrf token is invalid")
+)
+
+// HashPassword hashes the password with argon2id. The hash is encoded in the
+// PHC string format, which can be given to [VerifyPassword].
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := cryptorand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// VerifyPassword reports whether the password matches the hash. Both argon2id
+// hashes in the PHC string format and bcrypt hashes are supported. Argon2id
+// hashes with parameters above 1 GiB of memory, 16 iterations or 16 threads are
+// not supported.
+func VerifyPassword(hash, password string) (bool, error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return verifyArgon2id(hash, password)
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	default:
+		return false, ErrUnsupportedPasswordHash
+	}
+}
+
+func verifyArgon2id(hash, password string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, ErrUnsupportedPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, ErrUnsupportedPasswordHash
+	}
+
+	var (
+		memory, iterations uint32
+		threads            uint8
+	)
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil ||
+		memory > argon2MaxMemory || iterations < 1 || iterations > argon2MaxTime || threads < 1 || threads > argon2MaxThreads {
+		return false, ErrUnsupportedPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, ErrUnsupportedPasswordHash
+	}
+
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(expected) == 0 || len(expected) > argon2MaxKeyLen {
+		return false, ErrUnsupportedPasswordHash
+	}
+
+	key := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(expected)))
+	return subtle.ConstantTimeCompare(key, expected) == 1, nil
+}
+
+// OwnerSession is an authenticated session of the owner.
+type OwnerSession struct {
+	Me         string
+	Expiration time.Time
+
+	// CSRFToken must be included in the forms submitted during the session,
+	// in the [CSRFTokenField] field, such as the consent form.
+	CSRFToken string
+}
+
+type ownerSessionPayload struct {
+	Me         string `json:"me"`
+	Expiration int64  `json:"exp"`
+	ID         string `json:"id"`
+}
+
+// OwnerAuth authenticates the owner of a single-user server with a password
+// and, optionally, a TOTP code or a recovery code. Once authenticated, the
+// owner gets a session cookie, signed with a secret key, which can be used to
+// protect the authorization page. Each session has a CSRF token that must be
+// included in the consent form.
+//
+// Use [OwnerAuth.ApprovalHook] in [Server.ApprovalHooks] to require an owner
+// session before approving an authorization request. Failed login attempts
+// should be rate limited, for example, with a [RateLimiter].
+type OwnerAuth struct {
+	// Me is the profile URL of the owner.
+	Me string
+
+	// PasswordHash is the argon2id or bcrypt hash of the password of the owner.
+	// See [HashPassword].
+	PasswordHash string
+
+	// TOTPSecret is the base32 encoded TOTP secret. If empty, TOTP is disabled.
+	// See [NewTOTPSecret].
+	TOTPSecret string
+
+	// RecoveryCodes are the hashes of the recovery codes, which can be used
+	// instead of a TOTP code. Each code can only be used once. See
+	// [NewRecoveryCodes].
+	RecoveryCodes []string
+
+	// OnRecoveryCodeUsed is called with the hashes of the remaining recovery
+	// codes when a recovery code is used, such that they can be persisted.
+	OnRecoveryCodeUsed func(remaining []string)
+
+	SessionLifetime time.Duration
+	CookieName      string
+
+	key []byte
+
+	mu       sync.Mutex
+	lastStep uint64
+	now      func() time.Time
+}
+
+// NewOwnerAuth creates a new [OwnerAuth] for the owner with the given profile
+// URL and password hash. The key is used to sign the session cookies and
+// should be at least 32 bytes long. If no key is given, a random one is
+// generated, and sessions do not survive restarts.
+func NewOwnerAuth(me, passwordHash string, key []byte) *OwnerAuth {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		_, _ = cryptorand.Read(key)
+	}
+
+	return &OwnerAuth{
+		Me:              me,
+		PasswordHash:    passwordHash,
+		SessionLifetime: DefaultSessionLifetime,
+		CookieName:      DefaultSessionCookieName,
+		key:             key,
+		now:             time.Now,
+	}
+}
+
+// Login authenticates the owner with the "password" form field and, if TOTP is
+// enabled, the "totp" or the "recovery_code" form fields. On success, a session
+// cookie is set. [ErrTOTPRequired] is returned if the password is correct but
+// no TOTP code was given, such that the code can be asked in a second step.
+func (o *OwnerAuth) Login(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	ok, err := VerifyPassword(o.PasswordHash, r.PostForm.Get("password"))
+	if err != nil {
+		return err
+	} else if !ok {
+		return ErrInvalidCredentials
+	}
+
+	if o.TOTPSecret != "" {
+		if code := r.PostForm.Get("totp"); code != "" {
+			if !o.verifyTOTP(code) {
+				return ErrInvalidCredentials
+			}
+		} else if code := r.PostForm.Get("recovery_code"); code != "" {
+			if !o.useRecoveryCode(code) {
+				return ErrInvalidCredentials
+			}
+		} else {
+			return ErrTOTPRequired
+		}
+	}
+
+	_, err = o.StartSession(w, r)
+	return err
+}
+
+// verifyTOTP verifies the TOTP code. Each code can only be used once.
+func (o *OwnerAuth) verifyTOTP(code string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	step, ok := validateTOTP(o.TOTPSecret, code, o.now())
+	if !ok || step <= o.lastStep {
+		return false
+	}
+
+	o.lastStep = step
+	return true
+}
+
+func (o *OwnerAuth) useRecoveryCode(code string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	i := slices.Index(o.RecoveryCodes, HashRecoveryCode(code))
+	if i == -1 {
+		return false
+	}
+
+	o.RecoveryCodes = slices.Delete(slices.Clone(o.RecoveryCodes), i, i+1)
+	if o.OnRecoveryCodeUsed != nil {
+		o.OnRecoveryCodeUsed(slices.Clone(o.RecoveryCodes))
+	}
+
+	return true
+}
+
+// StartSession starts a new session for the owner and sets the session cookie.
+// [OwnerAuth.Login] calls it after verifying the credentials. It can also be
+// used after authenticating the owner in other ways, such as with [WebAuthn].
+func (o *OwnerAuth) StartSession(w http.ResponseWriter, r *http.Request) (*OwnerSession, error) {
+	id, err := newCode()
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &ownerSessionPayload{
+		Me:         o.Me,
+		Expiration: o.now().Add(o.sessionLifetime()).Unix(),
+		ID:         id,
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	value := base64.RawURLEncoding.EncodeToString(data)
+	value += "." + base64.RawURLEncoding.EncodeToString(o.sign("session", value))
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     o.cookieName(),
+		Value:    value,
+		Path:     "/",
+		Expires:  time.Unix(payload.Expiration, 0),
+		Secure:   o.secure(r),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return o.newSession(payload), nil
+}
+
+// Logout ends the session by removing the session cookie.
+func (o *OwnerAuth) Logout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     o.cookieName(),
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   o.secure(r),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Session returns the session of the request. Returns [ErrNoSession] if there
+// is no valid session.
+func (o *OwnerAuth) Session(r *http.Request) (*OwnerSession, error) {
+	cookie, err := r.Cookie(o.cookieName())
+	if err != nil {
+		return nil, ErrNoSession
+	}
+
+	value, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return nil, ErrNoSession
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, o.sign("session", value)) {
+		return nil, ErrNoSession
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrNoSession
+	}
+
+	var payload *ownerSessionPayload
+	if err := json.Unmarshal(data, &payload); err != nil || payload == nil {
+		return nil, ErrNoSession
+	}
+
+	if payload.Me != o.Me || o.now().Unix() >= payload.Expiration {
+		return nil, ErrNoSession
+	}
+
+	return o.newSession(payload), nil
+}
+
+// VerifyCSRF returns the session of the request, after verifying that the
+// form includes the CSRF token of the session.
+func (o *OwnerAuth) VerifyCSRF(r *http.Request) (*OwnerSession, error) {
+	session, err := o.Session(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	token := r.PostForm.Get(CSRFTokenField)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) != 1 {
+		return nil, ErrInvalidCSRFToken
+	}
+
+	return session, nil
+}
+
+// Require is a middleware that redirects requests without a valid session to
+// the given login URL. The URL of the original request is given in the
+// "redirect" query parameter.
+func (o *OwnerAuth) Require(loginURL string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := o.Session(r); err != nil {
+			http.Redirect(w, r, loginURL+"?"+url.Values{"redirect": {r.URL.RequestURI()}}.Encode(), http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ApprovalHook is an [ApprovalHook] that requires the request to have a valid
+// session and CSRF token. [AuthenticationRequest.Me] is set to the owner.
+func (o *OwnerAuth) ApprovalHook(r *http.Request, req *AuthenticationRequest) error {
+	session, err := o.VerifyCSRF(r)
+	if err != nil {
+		return err
+	}
+
+	req.Me = session.Me
+	return nil
+}
+
+func (o *OwnerAuth) newSession(payload *ownerSessionPayload) *OwnerSession {
+	return &OwnerSession{
+		Me:         payload.Me,
+		Expiration: time.Unix(payload.Expiration, 0),
+		CSRFToken:  base64.RawURLEncoding.EncodeToString(o.sign("csrf", payload.ID)),
+	}
+}
+
+func (o *OwnerAuth) sign(purpose, value string) []byte {
+	mac := hmac.New(sha256.New, o.key)
+	_, _ = mac.Write([]byte(purpose + "." + value))
+	return mac.Sum(nil)
+}
+
+func (o *OwnerAuth) sessionLifetime() time.Duration {
+	if o.SessionLifetime == 0 {
+		return DefaultSessionLifetime
+	}
+	return o.SessionLifetime
+}
+
+func (o *OwnerAuth) cookieName() string {
+	if o.CookieName == "" {
+		return DefaultSessionCookieName
+	}
+	return o.CookieName
+}
+
+func (o *OwnerAuth) secure(r *http.Request) bool {
+	return r.TLS != nil || strings.HasPrefix(o.Me, "https://")
+}
@@ -0,0 +1,233 @@
+package indieauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswords(t *testing.T) {
+	t.Parallel()
+
+	hash, err := HashPassword("correct horse")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=4$"))
+
+	ok, err := VerifyPassword(hash, "correct horse")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = VerifyPassword(hash, "battery staple")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	ok, err = VerifyPassword(string(bcryptHash), "correct horse")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = VerifyPassword(string(bcryptHash), "battery staple")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = VerifyPassword("plain text", "plain text")
+	assert.ErrorIs(t, err, ErrUnsupportedPasswordHash)
+
+	_, err = VerifyPassword("$argon2id$v=19$m=65536,t=3$invalid", "plain text")
+	assert.ErrorIs(t, err, ErrUnsupportedPasswordHash)
+
+	// Parameters that would exhaust the resources, or make argon2 panic, are
+	// rejected before hashing.
+	for _, params := range []string{"m=4294967295,t=3,p=4", "m=65536,t=100000,p=4", "m=65536,t=0,p=4", "m=65536,t=3,p=0", "m=65536,t=3,p=255"} {
+		_, err = VerifyPassword("$argon2id$v=19$"+params+"$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5", "plain text")
+		assert.ErrorIs(t, err, ErrUnsupportedPasswordHash, params)
+	}
+}
+
+func TestOwnerAuth(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	login := func(o *OwnerAuth, form url.Values) (*http.Cookie, error) {
+		r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+
+		err := o.Login(w, r)
+		if err != nil {
+			return nil, err
+		}
+
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		return cookies[0], nil
+	}
+
+	t.Run("Password", func(t *testing.T) {
+		t.Parallel()
+
+		o := NewOwnerAuth("https://example.org/", string(hash), nil)
+
+		_, err := login(o, url.Values{"password": {"battery staple"}})
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+		cookie, err := login(o, url.Values{"password": {"correct horse"}})
+		require.NoError(t, err)
+		assert.Equal(t, DefaultSessionCookieName, cookie.Name)
+		assert.True(t, cookie.HttpOnly)
+		assert.True(t, cookie.Secure)
+
+		r := httptest.NewRequest(http.MethodGet, "/auth", nil)
+		r.AddCookie(cookie)
+		session, err := o.Session(r)
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.org/", session.Me)
+		assert.NotEmpty(t, session.CSRFToken)
+
+		// Sessions signed with another key are invalid.
+		_, err = NewOwnerAuth("https://example.org/", string(hash), nil).Session(r)
+		assert.ErrorIs(t, err, ErrNoSession)
+
+		// Tampered sessions are invalid.
+		tampered := *cookie
+		tampered.Value = "x" + tampered.Value
+		r = httptest.NewRequest(http.MethodGet, "/auth", nil)
+		r.AddCookie(&tampered)
+		_, err = o.Session(r)
+		assert.ErrorIs(t, err, ErrNoSession)
+
+		// Expired sessions are invalid.
+		r = httptest.NewRequest(http.MethodGet, "/auth", nil)
+		r.AddCookie(cookie)
+		o.now = func() time.Time { return time.Now().Add(DefaultSessionLifetime) }
+		_, err = o.Session(r)
+		assert.ErrorIs(t, err, ErrNoSession)
+	})
+
+	t.Run("TOTP", func(t *testing.T) {
+		t.Parallel()
+
+		secret, err := NewTOTPSecret()
+		require.NoError(t, err)
+
+		codes, hashes, err := NewRecoveryCodes(2)
+		require.NoError(t, err)
+
+		var remaining []string
+		o := NewOwnerAuth("https://example.org/", string(hash), nil)
+		o.TOTPSecret = secret
+		o.RecoveryCodes = hashes
+		o.OnRecoveryCodeUsed = func(r []string) { remaining = r }
+
+		_, err = login(o, url.Values{"password": {"correct horse"}})
+		assert.ErrorIs(t, err, ErrTOTPRequired)
+
+		_, err = login(o, url.Values{"password": {"battery staple"}})
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+		_, err = login(o, url.Values{"password": {"correct horse"}, "totp": {"000000"}})
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+		code, err := GenerateTOTP(secret, time.Now())
+		require.NoError(t, err)
+
+		_, err = login(o, url.Values{"password": {"correct horse"}, "totp": {code}})
+		require.NoError(t, err)
+
+		// Codes cannot be reused.
+		_, err = login(o, url.Values{"password": {"correct horse"}, "totp": {code}})
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+		_, err = login(o, url.Values{"password": {"correct horse"}, "recovery_code": {codes[1]}})
+		require.NoError(t, err)
+		assert.Equal(t, hashes[:1], remaining)
+
+		_, err = login(o, url.Values{"password": {"correct horse"}, "recovery_code": {codes[1]}})
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+	})
+
+	t.Run("Approval", func(t *testing.T) {
+		t.Parallel()
+
+		o := NewOwnerAuth("http://localhost/", string(hash), nil)
+		cookie, err := login(o, url.Values{"password": {"correct horse"}})
+		require.NoError(t, err)
+		assert.False(t, cookie.Secure)
+
+		r := httptest.NewRequest(http.MethodGet, "/auth", nil)
+		r.AddCookie(cookie)
+		session, err := o.Session(r)
+		require.NoError(t, err)
+
+		var events []EventType
+		ias := NewServer(false, nil)
+		ias.ApprovalHooks = []ApprovalHook{o.ApprovalHook}
+		ias.Observer = EventObserverFunc(func(_ context.Context, e *Event) {
+			events = append(events, e.Type)
+		})
+
+		approve := func(cookie *http.Cookie, csrf string) (*AuthenticationRequest, error) {
+			r := httptest.NewRequest(http.MethodPost, "/auth/accept", strings.NewReader(url.Values{CSRFTokenField: {csrf}}.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if cookie != nil {
+				r.AddCookie(cookie)
+			}
+
+			req := &AuthenticationRequest{ClientID: "https://example.com/"}
+			return req, ias.Approve(r, req)
+		}
+
+		_, err = approve(nil, session.CSRFToken)
+		assert.ErrorIs(t, err, ErrNoSession)
+
+		_, err = approve(cookie, "invalid")
+		assert.ErrorIs(t, err, ErrInvalidCSRFToken)
+
+		req, err := approve(cookie, session.CSRFToken)
+		require.NoError(t, err)
+		assert.Equal(t, "http://localhost/", req.Me)
+
+		assert.Equal(t, []EventType{EventVerificationFailed, EventVerificationFailed, EventAuthorizationApproved}, events)
+	})
+
+	t.Run("Require", func(t *testing.T) {
+		t.Parallel()
+
+		o := NewOwnerAuth("https://example.org/", string(hash), nil)
+		handler := o.Require("/login", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth?client_id=https://example.com/", nil))
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+		assert.Equal(t, "/login?redirect=%2Fauth%3Fclient_id%3Dhttps%3A%2F%2Fexample.com%2F", w.Header().Get("Location"))
+
+		cookie, err := login(o, url.Values{"password": {"correct horse"}})
+		require.NoError(t, err)
+
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/auth", nil)
+		r.AddCookie(cookie)
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = httptest.NewRecorder()
+		o.Logout(w, r)
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.Equal(t, -1, cookies[0].MaxAge)
+	})
+}
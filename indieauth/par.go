@@ -0,0 +1,194 @@
+package indieauth
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPushedAuthorizationLifetime is the default lifetime of a pushed
+	// authorization request. See [RFC 9126], section 2.2.
+	//
+	// [RFC 9126]: https://datatracker.ietf.org/doc/html/rfc9126#section-2.2
+	DefaultPushedAuthorizationLifetime = time.Second * 60
+
+	// RequestURIPrefix is the prefix of the request URIs generated by the
+	// pushed authorization request endpoint.
+	RequestURIPrefix = "urn:ietf:params:oauth:request_uri:"
+)
+
+var (
+	ErrInvalidRequestURI              error = errors.New("request_uri is invalid or expired")
+	ErrRequestURINotAllowed           error = errors.New("request_uri must not be provided in a pushed authorization request")
+	ErrPushedAuthorizationUnsupported error = errors.New("pushed authorization requests are not supported")
+)
+
+// PushedAuthorizationStore stores pushed authorization requests.
+type PushedAuthorizationStore interface {
+	// Store stores the request, identified by the given request URI, until the
+	// given expiration time.
+	Store(ctx context.Context, requestURI string, req *AuthenticationRequest, expiration time.Time) error
+
+	// Load retrieves and deletes the request identified by the given request URI.
+	// Must return [ErrInvalidRequestURI] if the request does not exist or has
+	// expired.
+	Load(ctx context.Context, requestURI string) (*AuthenticationRequest, error)
+}
+
+type storedRequest struct {
+	req        *AuthenticationRequest
+	expiration time.Time
+}
+
+// memoryRequestStore stores authorization requests in memory until they are
+// loaded once. It implements both [PushedAuthorizationStore] and [ConsentStore].
+type memoryRequestStore struct {
+	mu       sync.Mutex
+	requests map[string]*storedRequest
+	notFound error
+}
+
+// NewMemoryPushedAuthorizationStore creates a new in-memory [PushedAuthorizationStore].
+func NewMemoryPushedAuthorizationStore() PushedAuthorizationStore {
+	return &memoryRequestStore{
+		requests: map[string]*storedRequest{},
+		notFound: ErrInvalidRequestURI,
+	}
+}
+
+func (m *memoryRequestStore) Store(_ context.Context, requestURI string, req *AuthenticationRequest, expiration time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for k, v := range m.requests {
+		if now.After(v.expiration) {
+			delete(m.requests, k)
+		}
+	}
+
+	m.requests[requestURI] = &storedRequest{
+		req:        req,
+		expiration: expiration,
+	}
+	return nil
+}
+
+func (m *memoryRequestStore) Load(_ context.Context, requestURI string) (*AuthenticationRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v, ok := m.requests[requestURI]
+	if !ok {
+		return nil, m.notFound
+	}
+
+	delete(m.requests, requestURI)
+
+	if time.Now().After(v.expiration) {
+		return nil, m.notFound
+	}
+
+	return v.req, nil
+}
+
+type pushedAuthorizationResponse struct {
+	RequestURI string `json:"request_uri"`
+	ExpiresIn  int64  `json:"expires_in"`
+}
+
+// PushedAuthorizationHandler handles the [pushed authorization request] endpoint.
+// The request is validated in the same way as [Server.ParseAuthorization] and
+// stored in [Server.PushedAuthorizations]. The response contains a request_uri
+// that the client then sends to the authorization endpoint, where it is resolved
+// by [Server.ParseAuthorization].
+//
+// Remember to advertise the endpoint in the pushed_authorization_request_endpoint
+// field of your server's metadata.
+//
+// [pushed authorization request]: https://datatracker.ietf.org/doc/html/rfc9126
+func (s *Server) PushedAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		serveErrorJSON(w, http.StatusMethodNotAllowed, "invalid_request", "method not allowed")
+		return
+	}
+
+	if s.PushedAuthorizations == nil {
+		serveErrorJSON(w, http.StatusNotImplemented, "invalid_request", ErrPushedAuthorizationUnsupported.Error())
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		serveErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	if r.PostForm.Has("request_uri") {
+		serveErrorJSON(w, http.StatusBadRequest, "invalid_request", ErrRequestURINotAllowed.Error())
+		return
+	}
+
+	req, err := s.parseAuthorization(r.Context(), r.PostForm)
+	if err != nil {
+		serveErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	requestURI, err := newRequestURI()
+	if err != nil {
+		serveInternalError(w, s.logger(), err)
+		return
+	}
+
+	lifetime := s.PushedAuthorizationLifetime
+	if lifetime == 0 {
+		lifetime = DefaultPushedAuthorizationLifetime
+	}
+
+	err = s.PushedAuthorizations.Store(r.Context(), requestURI, req, time.Now().Add(lifetime))
+	if err != nil {
+		serveInternalError(w, s.logger(), err)
+		return
+	}
+
+	serveJSON(w, http.StatusCreated, &pushedAuthorizationResponse{
+		RequestURI: requestURI,
+		ExpiresIn:  int64(lifetime.Seconds()),
+	})
+}
+
+// resolvePushedAuthorization resolves a request URI into the pushed authorization
+// request. As per RFC 9126, section 4, the client_id of the authorization request
+// must match the one of the pushed request.
+func (s *Server) resolvePushedAuthorization(ctx context.Context, requestURI, clientID string) (*AuthenticationRequest, error) {
+	if s.PushedAuthorizations == nil {
+		return nil, ErrPushedAuthorizationUnsupported
+	}
+
+	req, err := s.PushedAuthorizations.Load(ctx, requestURI)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.ClientID != clientID {
+		return nil, ErrNoMatchClientID
+	}
+
+	return req, nil
+}
+
+// newRequestURI generates a new request URI.
+func newRequestURI() (string, error) {
+	b := make([]byte, 32)
+	_, err := cryptorand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return RequestURIPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
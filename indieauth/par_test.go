@@ -0,0 +1,192 @@
+package indieauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushedAuthorization(t *testing.T) {
+	t.Parallel()
+
+	push := func(t *testing.T, ias *Server, form url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/par", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ias.PushedAuthorizationHandler(w, r)
+		return w
+	}
+
+	form := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"https://example.com/"},
+		"redirect_uri":          {"https://example.com/callback"},
+		"state":                 {"abc123"},
+		"scope":                 {"profile create"},
+		"code_challenge":        {strings.Repeat("a", 43)},
+		"code_challenge_method": {"S256"},
+	}
+
+	t.Run("Push and Resolve", func(t *testing.T) {
+		t.Parallel()
+
+		ias := NewServer(true, nil)
+		w := push(t, ias, form)
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		var res pushedAuthorizationResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+		assert.True(t, strings.HasPrefix(res.RequestURI, RequestURIPrefix))
+		assert.EqualValues(t, 60, res.ExpiresIn)
+
+		query := url.Values{
+			"client_id":   {"https://example.com/"},
+			"request_uri": {res.RequestURI},
+		}
+		r := httptest.NewRequest(http.MethodGet, "/auth?"+query.Encode(), nil)
+		authReq, err := ias.ParseAuthorization(r)
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/", authReq.ClientID)
+		assert.Equal(t, "https://example.com/callback", authReq.RedirectURI)
+		assert.Equal(t, "abc123", authReq.State)
+		assert.Equal(t, []string{"profile", "create"}, authReq.Scopes)
+		assert.Equal(t, strings.Repeat("a", 43), authReq.CodeChallenge)
+
+		// Request URIs can only be used once.
+		r = httptest.NewRequest(http.MethodGet, "/auth?"+query.Encode(), nil)
+		_, err = ias.ParseAuthorization(r)
+		assert.ErrorIs(t, err, ErrInvalidRequestURI)
+	})
+
+	t.Run("Mismatched Client ID", func(t *testing.T) {
+		t.Parallel()
+
+		ias := NewServer(true, nil)
+		w := push(t, ias, form)
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		var res pushedAuthorizationResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+
+		query := url.Values{
+			"client_id":   {"https://example.org/"},
+			"request_uri": {res.RequestURI},
+		}
+		r := httptest.NewRequest(http.MethodGet, "/auth?"+query.Encode(), nil)
+		_, err := ias.ParseAuthorization(r)
+		assert.ErrorIs(t, err, ErrNoMatchClientID)
+	})
+
+	t.Run("Invalid Requests", func(t *testing.T) {
+		t.Parallel()
+
+		ias := NewServer(true, nil)
+
+		invalid := url.Values{}
+		for k, v := range form {
+			invalid[k] = v
+		}
+		invalid.Del("code_challenge")
+		w := push(t, ias, invalid)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		invalid = url.Values{"request_uri": {RequestURIPrefix + "abc"}}
+		for k, v := range form {
+			invalid[k] = v
+		}
+		w = push(t, ias, invalid)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = httptest.NewRecorder()
+		ias.PushedAuthorizationHandler(w, httptest.NewRequest(http.MethodGet, "/par", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+		r := httptest.NewRequest(http.MethodGet, "/auth?client_id=https://example.com/&request_uri=unknown", nil)
+		_, err := ias.ParseAuthorization(r)
+		assert.ErrorIs(t, err, ErrInvalidRequestURI)
+	})
+
+	t.Run("Store Failure", func(t *testing.T) {
+		t.Parallel()
+
+		var logs bytes.Buffer
+		ias := NewServer(true, nil)
+		ias.PushedAuthorizations = failingRequestStore{}
+		ias.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+		// Internal errors are logged, but not replied to the client.
+		w := push(t, ias, form)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.NotContains(t, w.Body.String(), "10.0.0.1")
+		assert.Contains(t, logs.String(), "10.0.0.1")
+	})
+
+	t.Run("Client Uses Pushed Authorization Endpoint", func(t *testing.T) {
+		t.Parallel()
+
+		ias := NewServer(true, nil)
+
+		client := NewClient(
+			"https://example.com/",
+			"https://example.com/callback",
+			&http.Client{
+				Transport: &handlerRoundTripper{
+					handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						switch r.URL.Path {
+						case "/metadata":
+							w.Header().Set("Content-Type", "application/json; charset=utf-8")
+							_, _ = w.Write([]byte(`{
+								"issuer": "https://example.com/",
+								"authorization_endpoint": "https://example.com/auth",
+								"token_endpoint": "https://example.com/token",
+								"pushed_authorization_request_endpoint": "https://example.com/par"
+							}`))
+						case "/par":
+							ias.PushedAuthorizationHandler(w, r)
+						default:
+							w.Header().Set("Content-Type", "text/html; charset=utf-8")
+							w.Header().Set("Link", `</metadata>; rel="indieauth-metadata"`)
+							_, _ = w.Write([]byte(`<html></html>`))
+						}
+					}),
+				},
+			},
+		)
+
+		authInfo, redirect, err := client.Authenticate(context.Background(), "https://example.com/", "profile create")
+		require.NoError(t, err)
+
+		redirectURL, err := url.Parse(redirect)
+		require.NoError(t, err)
+		assert.Equal(t, "/auth", redirectURL.Path)
+		assert.Equal(t, "https://example.com/", redirectURL.Query().Get("client_id"))
+		assert.Empty(t, redirectURL.Query().Get("code_challenge"))
+
+		authReq, err := ias.ParseAuthorization(httptest.NewRequest(http.MethodGet, redirect, nil))
+		require.NoError(t, err)
+		assert.Equal(t, authInfo.State, authReq.State)
+		assert.Equal(t, []string{"profile", "create"}, authReq.Scopes)
+		assert.Equal(t, s256Challenge(authInfo.CodeVerifier), authReq.CodeChallenge)
+	})
+}
+
+type failingRequestStore struct{}
+
+func (failingRequestStore) Store(context.Context, string, *AuthenticationRequest, time.Time) error {
+	return errors.New("connection to 10.0.0.1 refused")
+}
+
+func (failingRequestStore) Load(context.Context, string) (*AuthenticationRequest, error) {
+	return nil, ErrInvalidRequestURI
+}
@@ -0,0 +1,142 @@
+package indieauth
+
+import (
+	"errors"
+	"math"
+)
+
+// ErrWeakCodeVerifier is returned when the code verifier does not have the
+// minimum entropy required by the [Policy].
+var ErrWeakCodeVerifier error = errors.New("code_verifier does not have enough entropy")
+
+// Policy controls how strictly the [Server] follows the current IndieAuth
+// specification. See [DefaultPolicy] and [StrictPolicy].
+type Policy struct {
+	// CodeChallengeMethods are the allowed code challenge methods. If empty,
+	// the default [CodeChallengeMethods] are allowed. The "plain" method is
+	// only allowed if listed here.
+	CodeChallengeMethods []string
+
+	// RequirePKCE requires authorization requests to include a code challenge.
+	// PKCE is also required if [Server.RequirePKCE] is set.
+	RequirePKCE bool
+
+	// MinVerifierEntropy is the minimum estimated entropy, in bits, of code
+	// verifiers. The entropy is estimated from the length of the verifier and
+	// the characters it uses. If 0, the entropy is not checked.
+	MinVerifierEntropy int
+
+	// AllowLegacyDefaults accepts authorization requests without response_type
+	// and token requests without grant_type, as sent by legacy clients. Both
+	// default to their only valid value.
+	AllowLegacyDefaults bool
+
+	// AllowScopesArray accepts the scopes given as a "scopes" or "scopes[]"
+	// array, as sent by some clients, when the "scope" parameter is missing.
+	AllowScopesArray bool
+}
+
+// DefaultPolicy is the policy used when [Server.Policy] is nil. It only accepts
+// the "S256" code challenge method, but also accepts legacy requests, for
+// compatibility with older clients. PKCE is required only if
+// [Server.RequirePKCE] is set.
+var DefaultPolicy = Policy{
+	AllowLegacyDefaults: true,
+	AllowScopesArray:    true,
+}
+
+// StrictPolicy follows the current IndieAuth specification: PKCE is required,
+// only the "S256" code challenge method is allowed, code verifiers must have at
+// least 128 bits of entropy, and legacy requests are rejected.
+var StrictPolicy = Policy{
+	CodeChallengeMethods: []string{"S256"},
+	MinVerifierEntropy:   128,
+	RequirePKCE:          true,
+}
+
+// AllowsCodeChallengeMethod returns whether the given code challenge method is
+// allowed by the policy.
+func (p *Policy) AllowsCodeChallengeMethod(ccm string) bool {
+	if !IsValidCodeChallengeMethod(ccm) {
+		return false
+	}
+
+	if len(p.CodeChallengeMethods) == 0 {
+		return containsString(CodeChallengeMethods, ccm)
+	}
+
+	return containsString(p.CodeChallengeMethods, ccm)
+}
+
+// SupportedCodeChallengeMethods returns the code challenge methods allowed by
+// the policy. Can be used to fill [Metadata.CodeChallengeMethodsSupported].
+func (p *Policy) SupportedCodeChallengeMethods() []string {
+	methods := []string{}
+	for _, ccm := range knownCodeChallengeMethods {
+		if p.AllowsCodeChallengeMethod(ccm) {
+			methods = append(methods, ccm)
+		}
+	}
+	return methods
+}
+
+// policy returns the policy of the server, or [DefaultPolicy] if none is set.
+func (s *Server) policy() *Policy {
+	if s.Policy == nil {
+		return &DefaultPolicy
+	}
+	return s.Policy
+}
+
+// requirePKCE returns whether PKCE is required by the server or its policy.
+func (s *Server) requirePKCE() bool {
+	return s.RequirePKCE || s.policy().RequirePKCE
+}
+
+// verifierEntropy estimates the entropy, in bits, of a code verifier from its
+// length and the size of the alphabet it was likely drawn from: the classes of
+// characters it uses, bounded by the number of distinct characters. Characters
+// not allowed by [RFC 7636] result in no entropy.
+//
+// [RFC 7636]: https://datatracker.ietf.org/doc/html/rfc7636#section-4.1
+func verifierEntropy(verifier string) float64 {
+	var lower, upper, digit, symbol bool
+	distinct := map[rune]struct{}{}
+	for _, c := range verifier {
+		distinct[c] = struct{}{}
+
+		switch {
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= '0' && c <= '9':
+			digit = true
+		case c == '-' || c == '.' || c == '_' || c == '~':
+			symbol = true
+		default:
+			return 0
+		}
+	}
+
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 4
+	}
+
+	pool = min(pool, len(distinct))
+	if pool == 0 {
+		return 0
+	}
+
+	return float64(len(verifier)) * math.Log2(float64(pool))
+}
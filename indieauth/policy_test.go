@@ -0,0 +1,123 @@
+package indieauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{"S256"}, DefaultPolicy.SupportedCodeChallengeMethods())
+	assert.Equal(t, []string{"S256"}, StrictPolicy.SupportedCodeChallengeMethods())
+	assert.Equal(t, []string{"plain", "S256"}, legacyPlainPolicy.SupportedCodeChallengeMethods())
+	assert.False(t, DefaultPolicy.AllowsCodeChallengeMethod("plain"))
+	assert.False(t, StrictPolicy.AllowsCodeChallengeMethod("unknown"))
+	assert.True(t, StrictPolicy.RequirePKCE)
+
+	verifier, err := newVerifier()
+	require.NoError(t, err)
+
+	for _, testCase := range []struct {
+		verifier string
+		minimum  float64
+	}{
+		{verifier, 256},
+		{"abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQ", 128},
+		{strings.Repeat("aB3-", 11), 0},
+		{strings.Repeat("0", 43), 0},
+		{strings.Repeat("a", 43) + "!", 0},
+	} {
+		entropy := verifierEntropy(testCase.verifier)
+		assert.GreaterOrEqual(t, entropy, testCase.minimum)
+		if testCase.minimum == 0 {
+			assert.Less(t, entropy, float64(StrictPolicy.MinVerifierEntropy))
+		}
+	}
+}
+
+func TestStrictPolicy(t *testing.T) {
+	t.Parallel()
+
+	// The strict policy requires PKCE on its own.
+	ias := NewServer(false, nil)
+	ias.Policy = &StrictPolicy
+
+	t.Run("Authorization", func(t *testing.T) {
+		t.Parallel()
+
+		for _, testCase := range []struct {
+			responseType        string
+			codeChallengeMethod string
+			expectedError       error
+		}{
+			{"code", "S256", nil},
+			{"", "S256", ErrInvalidResponseType},
+			{"code", "plain", ErrInvalidCodeChallengeMethod},
+			{"code", "", ErrPKCERequired},
+		} {
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.Form = url.Values{}
+			r.Form.Set("response_type", testCase.responseType)
+			r.Form.Set("client_id", "https://example.com/")
+			r.Form.Set("redirect_uri", "https://example.com/callback")
+			if testCase.codeChallengeMethod != "" {
+				r.Form.Set("code_challenge", strings.Repeat("a", 43))
+				r.Form.Set("code_challenge_method", testCase.codeChallengeMethod)
+			}
+			r.Form["scopes[]"] = []string{"create", "update"}
+
+			authReq, err := ias.ParseAuthorization(r)
+			if testCase.expectedError == nil {
+				require.NoError(t, err)
+				assert.Empty(t, authReq.Scopes)
+			} else {
+				assert.ErrorIs(t, err, testCase.expectedError)
+			}
+		}
+	})
+
+	t.Run("Token Exchange", func(t *testing.T) {
+		t.Parallel()
+
+		verifier, err := newVerifier()
+		require.NoError(t, err)
+
+		weak := strings.Repeat("0", 43)
+
+		for _, testCase := range []struct {
+			grantType           string
+			codeChallengeMethod string
+			codeVerifier        string
+			expectedError       error
+		}{
+			{"authorization_code", "S256", verifier, nil},
+			{"", "S256", verifier, ErrInvalidGrantType},
+			{"authorization_code", "plain", verifier, ErrInvalidCodeChallengeMethod},
+			{"authorization_code", "S256", weak, ErrWeakCodeVerifier},
+		} {
+			authReq := &AuthenticationRequest{
+				ClientID:            "https://example.com/",
+				RedirectURI:         "https://example.com/callback",
+				CodeChallenge:       s256Challenge(testCase.codeVerifier),
+				CodeChallengeMethod: testCase.codeChallengeMethod,
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.Form = url.Values{}
+			r.Form.Set("grant_type", testCase.grantType)
+			r.Form.Set("client_id", authReq.ClientID)
+			r.Form.Set("redirect_uri", authReq.RedirectURI)
+			r.Form.Set("code_verifier", testCase.codeVerifier)
+
+			err := ias.ValidateTokenExchange(authReq, r)
+			assert.ErrorIs(t, err, testCase.expectedError)
+		}
+	})
+}
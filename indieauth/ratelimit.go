@@ -0,0 +1,524 @@
+package indieauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRateLimitBaseDelay is the default delay after the first failure.
+	DefaultRateLimitBaseDelay = time.Second
+
+	// DefaultRateLimitMaxDelay is the default maximum backoff delay.
+	DefaultRateLimitMaxDelay = time.Minute * 5
+
+	// DefaultRateLimitMaxFailures is the default number of consecutive failures
+	// after which a key is locked out.
+	DefaultRateLimitMaxFailures = 10
+
+	// DefaultRateLimitLockout is the default lockout duration.
+	DefaultRateLimitLockout = time.Hour
+)
+
+// ErrRateLimited is returned when a request is rejected due to rate limiting.
+// The returned error is always a [*RateLimitError].
+var ErrRateLimited error = errors.New("too many failed attempts")
+
+// RateLimitError is returned when a request is rejected due to rate limiting.
+type RateLimitError struct {
+	// Key is the key that is being limited, such as "ip:127.0.0.1".
+	Key string
+
+	// RetryAfter is the duration after which the request can be retried.
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s: retry after %s", ErrRateLimited.Error(), e.RetryAfter)
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// RateLimitEntry is the state of a rate limited key.
+type RateLimitEntry struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// RateLimitStore stores the state of rate limited keys.
+type RateLimitStore interface {
+	// Load returns the entry for the given key. If there is no entry, a zero
+	// entry and no error must be returned.
+	Load(ctx context.Context, key string) (RateLimitEntry, error)
+
+	// Increment atomically increments the failures of the entry for the given
+	// key, sets its last failure to the given time, and returns the updated
+	// entry. Expired entries start from zero. The entry is no longer needed
+	// after the given expiration time.
+	Increment(ctx context.Context, key string, at, expiration time.Time) (RateLimitEntry, error)
+
+	// Delete deletes the entry for the given key.
+	Delete(ctx context.Context, key string) error
+}
+
+type rateLimitRecord struct {
+	entry      RateLimitEntry
+	expiration time.Time
+}
+
+type memoryRateLimitStore struct {
+	mu      sync.Mutex
+	entries map[string]*rateLimitRecord
+}
+
+// NewMemoryRateLimitStore creates a new in-memory [RateLimitStore].
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryRateLimitStore{
+		entries: map[string]*rateLimitRecord{},
+	}
+}
+
+func (m *memoryRateLimitStore) Load(_ context.Context, key string) (RateLimitEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.entries[key]
+	if !ok || time.Now().After(r.expiration) {
+		return RateLimitEntry{}, nil
+	}
+
+	return r.entry, nil
+}
+
+func (m *memoryRateLimitStore) Increment(_ context.Context, key string, at, expiration time.Time) (RateLimitEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for k, r := range m.entries {
+		if now.After(r.expiration) {
+			delete(m.entries, k)
+		}
+	}
+
+	r, ok := m.entries[key]
+	if !ok {
+		r = &rateLimitRecord{}
+		m.entries[key] = r
+	}
+
+	r.entry.Failures++
+	r.entry.LastFailure = at
+	r.expiration = expiration
+	return r.entry, nil
+}
+
+func (m *memoryRateLimitStore) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
+
+// RateLimiter limits the number of failed attempts per key. After each failure,
+// the key is blocked for an exponentially increasing delay, starting at BaseDelay
+// and capped at MaxDelay. After MaxFailures consecutive failures, the key is
+// locked out for the duration of Lockout. A success resets the key.
+//
+// Checking a key with [RateLimiter.Allow] and then recording the result is not
+// atomic: concurrent attempts can all be allowed before any failure is recorded.
+// Use [RateLimiter.Reserve] to serialize the attempts for the same keys.
+type RateLimiter struct {
+	Store       RateLimitStore
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	MaxFailures int
+	Lockout     time.Duration
+
+	now func() time.Time
+
+	inflightMu sync.Mutex
+	inflight   map[string]chan struct{}
+}
+
+// NewRateLimiter creates a new [RateLimiter] with the default settings. If no
+// store is given, the state is kept in memory.
+func NewRateLimiter(store RateLimitStore) *RateLimiter {
+	if store == nil {
+		store = NewMemoryRateLimitStore()
+	}
+
+	return &RateLimiter{
+		Store:       store,
+		BaseDelay:   DefaultRateLimitBaseDelay,
+		MaxDelay:    DefaultRateLimitMaxDelay,
+		MaxFailures: DefaultRateLimitMaxFailures,
+		Lockout:     DefaultRateLimitLockout,
+		now:         time.Now,
+	}
+}
+
+// Allow checks whether requests for all the given keys are allowed. If any of
+// the keys is blocked, a [*RateLimitError] is returned. Empty keys are ignored.
+func (l *RateLimiter) Allow(ctx context.Context, keys ...string) error {
+	now := l.now()
+
+	var limited *RateLimitError
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+
+		entry, err := l.Store.Load(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		if entry.Failures == 0 {
+			continue
+		}
+
+		until := entry.LastFailure.Add(l.delay(entry.Failures))
+		if now.Before(until) && (limited == nil || until.Sub(now) > limited.RetryAfter) {
+			limited = &RateLimitError{Key: key, RetryAfter: until.Sub(now)}
+		}
+	}
+
+	if limited != nil {
+		return limited
+	}
+
+	return nil
+}
+
+// Reserve waits until no other attempt reserved for any of the given keys is in
+// progress, and then checks whether the attempt is allowed in the same way as
+// [RateLimiter.Allow]. If it is, the returned function must be called once the
+// result of the attempt is recorded with [RateLimiter.Failure] or
+// [RateLimiter.Success]. This ensures that concurrent attempts are counted one
+// after another, such that parallel guesses cannot bypass the backoff. Attempts
+// are only serialized within this limiter, not across processes that share the
+// same [RateLimitStore].
+func (l *RateLimiter) Reserve(ctx context.Context, keys ...string) (func(), error) {
+	keys = slices.Compact(slices.Sorted(slices.Values(keys)))
+
+	// Keys are locked in order, such that overlapping reservations cannot
+	// deadlock.
+	var unlocks []func()
+	release := func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+
+		unlock, err := l.lockKey(ctx, key)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		unlocks = append(unlocks, unlock)
+	}
+
+	if err := l.Allow(ctx, keys...); err != nil {
+		release()
+		return nil, err
+	}
+
+	return release, nil
+}
+
+// lockKey waits until no other attempt with the given key is in progress, and
+// returns the function to unlock it.
+func (l *RateLimiter) lockKey(ctx context.Context, key string) (func(), error) {
+	for {
+		l.inflightMu.Lock()
+		if l.inflight == nil {
+			l.inflight = map[string]chan struct{}{}
+		}
+
+		ch, ok := l.inflight[key]
+		if !ok {
+			ch = make(chan struct{})
+			l.inflight[key] = ch
+			l.inflightMu.Unlock()
+
+			return func() {
+				l.inflightMu.Lock()
+				delete(l.inflight, key)
+				l.inflightMu.Unlock()
+				close(ch)
+			}, nil
+		}
+		l.inflightMu.Unlock()
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Failure records a failed attempt for all the given keys.
+func (l *RateLimiter) Failure(ctx context.Context, keys ...string) error {
+	now := l.now()
+
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+
+		// Keep the entry around long enough to cover the longest delay, such
+		// that consecutive failures are counted.
+		expiration := now.Add(max(l.MaxDelay, l.Lockout))
+		if _, err := l.Store.Increment(ctx, key, now, expiration); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Success resets the state of all the given keys.
+func (l *RateLimiter) Success(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+
+		if err := l.Store.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// delay returns how long a key is blocked after the given number of failures.
+func (l *RateLimiter) delay(failures int) time.Duration {
+	if l.MaxFailures > 0 && failures >= l.MaxFailures {
+		return l.Lockout
+	}
+
+	delay := float64(l.BaseDelay) * math.Pow(2, float64(failures-1))
+	if delay > float64(l.MaxDelay) {
+		return l.MaxDelay
+	}
+	return time.Duration(delay)
+}
+
+// RateLimitFailures are the errors that are counted as failed attempts by the
+// [RateLimitedServer]. These errors indicate that someone may be trying to guess
+// codes, code verifiers or client identifiers.
+var RateLimitFailures = []error{
+	ErrCodeChallengeFailed,
+	ErrNoMatchClientID,
+	ErrNoMatchRedirectURI,
+	ErrWrongCodeVerifierLength,
+	ErrWeakCodeVerifier,
+	ErrInvalidCode,
+	ErrCodeExpired,
+	ErrCodeReplayed,
+	ErrInvalidRequestURI,
+	ErrDPoPKeyMismatch,
+}
+
+// RateLimitedServer wraps a [Server] and limits the amount of failed attempts
+// at the authorization and token endpoints, per IP address, per client identifier
+// and IP address, and per authorization code. Client identifiers are not limited
+// on their own, such that no one can lock out a client for everyone. Concurrent
+// attempts for the same keys are validated one at a time. See
+// [RateLimiter.Reserve].
+type RateLimitedServer struct {
+	*Server
+	Limiter *RateLimiter
+
+	// RemoteIP returns the IP address of the request. By default, the IP address
+	// is taken from [http.Request.RemoteAddr]. Set this if the server runs behind
+	// a reverse proxy.
+	RemoteIP func(r *http.Request) string
+}
+
+// NewRateLimitedServer creates a new [RateLimitedServer]. If no limiter is given,
+// a [RateLimiter] with the default settings and in-memory storage is used.
+func NewRateLimitedServer(s *Server, limiter *RateLimiter) *RateLimitedServer {
+	if limiter == nil {
+		limiter = NewRateLimiter(nil)
+	}
+
+	return &RateLimitedServer{
+		Server:  s,
+		Limiter: limiter,
+	}
+}
+
+// ParseAuthorization calls [Server.ParseAuthorization], limited per IP address
+// and per client identifier and IP address.
+func (s *RateLimitedServer) ParseAuthorization(r *http.Request) (*AuthenticationRequest, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	keys := s.keys(r)
+	release, err := s.Limiter.Reserve(r.Context(), keys...)
+	if err != nil {
+		s.Emit(r, EventVerificationFailed, nil, err)
+		return nil, err
+	}
+
+	defer release()
+
+	req, err := s.Server.ParseAuthorization(r)
+	return req, s.record(r.Context(), err, keys)
+}
+
+// ValidateTokenExchange calls [Server.ValidateTokenExchange], limited per IP
+// address, per client identifier and IP address, and per authorization code.
+func (s *RateLimitedServer) ValidateTokenExchange(authRequest *AuthenticationRequest, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	keys := s.keys(r)
+	release, err := s.Limiter.Reserve(r.Context(), keys...)
+	if err != nil {
+		s.Emit(r, EventVerificationFailed, nil, err)
+		return err
+	}
+
+	defer release()
+
+	return s.record(r.Context(), s.Server.ValidateTokenExchange(authRequest, r), keys)
+}
+
+// ValidateDPoPTokenExchange calls [Server.ValidateDPoPTokenExchange], limited per
+// IP address, per client identifier and IP address, and per authorization code.
+func (s *RateLimitedServer) ValidateDPoPTokenExchange(authRequest *AuthenticationRequest, r *http.Request) (*DPoPProof, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	keys := s.keys(r)
+	release, err := s.Limiter.Reserve(r.Context(), keys...)
+	if err != nil {
+		s.Emit(r, EventVerificationFailed, nil, err)
+		return nil, err
+	}
+
+	defer release()
+
+	proof, err := s.Server.ValidateDPoPTokenExchange(authRequest, r)
+	return proof, s.record(r.Context(), err, keys)
+}
+
+// Allow checks whether the request is allowed, without validating it. Prefer
+// [RateLimitedServer.Reserve], as the attempts are not serialized.
+func (s *RateLimitedServer) Allow(r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	return s.Limiter.Allow(r.Context(), s.keys(r)...)
+}
+
+// Reserve reserves an attempt for the request, without validating it. This can
+// be used before redeeming the authorization code, such that codes cannot be
+// guessed. If the attempt is allowed, the returned function must be called once
+// the result is recorded with [RateLimitedServer.Failure], and before validating
+// the request with the [RateLimitedServer]. See [RateLimiter.Reserve].
+func (s *RateLimitedServer) Reserve(r *http.Request) (func(), error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	return s.Limiter.Reserve(r.Context(), s.keys(r)...)
+}
+
+// Failure records a failed attempt for the request. This can be used to record
+// failures that happen outside of the [Server], such as [ErrInvalidCode].
+func (s *RateLimitedServer) Failure(r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	return s.Limiter.Failure(r.Context(), s.keys(r)...)
+}
+
+// record records the result of a validation, returning the original error. If
+// recording fails, the errors are joined.
+func (s *RateLimitedServer) record(ctx context.Context, err error, keys []string) error {
+	var recordErr error
+	if err == nil {
+		recordErr = s.Limiter.Success(ctx, keys[1:]...)
+	} else if isRateLimitFailure(err) {
+		recordErr = s.Limiter.Failure(ctx, keys...)
+	}
+
+	if recordErr != nil {
+		return errors.Join(err, recordErr)
+	}
+	return err
+}
+
+// keys returns the keys for the given request. The IP address is always the
+// first key. The IP is not reset on success, such that a single address cannot
+// mix valid and invalid attempts to bypass the limits.
+func (s *RateLimitedServer) keys(r *http.Request) []string {
+	var ip string
+	if s.RemoteIP != nil {
+		ip = s.RemoteIP(r)
+	} else if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	} else {
+		ip = r.RemoteAddr
+	}
+
+	keys := []string{"ip:" + ip}
+	if clientID := r.Form.Get("client_id"); clientID != "" {
+		keys = append(keys, "client_id:"+clientID+" ip:"+ip)
+	}
+	if code := r.Form.Get("code"); code != "" {
+		keys = append(keys, "code:"+code)
+	}
+	return keys
+}
+
+func isRateLimitFailure(err error) bool {
+	for _, target := range RateLimitFailures {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeRateLimitError checks if the given error is a [*RateLimitError]. If so,
+// it replies to the request with a 429 Too Many Requests status code, including
+// the Retry-After header, and returns true.
+func ServeRateLimitError(w http.ResponseWriter, err error) bool {
+	var rle *RateLimitError
+	if !errors.As(err, &rle) {
+		return false
+	}
+
+	seconds := int64(math.Ceil(rle.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.FormatInt(max(seconds, 1), 10))
+	serveErrorJSON(w, http.StatusTooManyRequests, "temporarily_unavailable", rle.Error())
+	return true
+}
@@ -0,0 +1,230 @@
+package indieauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("Exponential Backoff", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+		l := NewRateLimiter(nil)
+		l.now = func() time.Time { return now }
+
+		require.NoError(t, l.Allow(ctx, "key"))
+
+		require.NoError(t, l.Failure(ctx, "key"))
+		err := l.Allow(ctx, "key")
+		assert.ErrorIs(t, err, ErrRateLimited)
+
+		var rle *RateLimitError
+		require.True(t, errors.As(err, &rle))
+		assert.Equal(t, "key", rle.Key)
+		assert.Equal(t, time.Second, rle.RetryAfter)
+
+		require.NoError(t, l.Failure(ctx, "key"))
+		require.NoError(t, l.Failure(ctx, "key"))
+		err = l.Allow(ctx, "key")
+		require.True(t, errors.As(err, &rle))
+		assert.Equal(t, time.Second*4, rle.RetryAfter)
+
+		now = now.Add(time.Second * 4)
+		assert.NoError(t, l.Allow(ctx, "key"))
+
+		require.NoError(t, l.Success(ctx, "key"))
+		require.NoError(t, l.Failure(ctx, "key"))
+		err = l.Allow(ctx, "key")
+		require.True(t, errors.As(err, &rle))
+		assert.Equal(t, time.Second, rle.RetryAfter)
+	})
+
+	t.Run("Lockout", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+		l := NewRateLimiter(nil)
+		l.MaxFailures = 3
+		l.now = func() time.Time { return now }
+
+		for range 3 {
+			require.NoError(t, l.Failure(ctx, "key"))
+		}
+
+		var rle *RateLimitError
+		require.True(t, errors.As(l.Allow(ctx, "key", "other"), &rle))
+		assert.Equal(t, l.Lockout, rle.RetryAfter)
+
+		assert.NoError(t, l.Allow(ctx, "other", ""))
+	})
+
+	t.Run("Concurrent Failures", func(t *testing.T) {
+		t.Parallel()
+
+		l := NewRateLimiter(nil)
+
+		var wg sync.WaitGroup
+		for range 50 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, l.Failure(ctx, "key"))
+			}()
+		}
+		wg.Wait()
+
+		entry, err := l.Store.Load(ctx, "key")
+		require.NoError(t, err)
+		assert.Equal(t, 50, entry.Failures)
+	})
+
+	t.Run("Concurrent Reservations", func(t *testing.T) {
+		t.Parallel()
+
+		l := NewRateLimiter(nil)
+
+		// Only one of the concurrent attempts is allowed, as the others are
+		// reserved after its failure is recorded.
+		var (
+			wg       sync.WaitGroup
+			mu       sync.Mutex
+			attempts int
+		)
+		for range 50 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				release, err := l.Reserve(ctx, "ip", "code")
+				if err != nil {
+					assert.ErrorIs(t, err, ErrRateLimited)
+					return
+				}
+				defer release()
+
+				mu.Lock()
+				attempts++
+				mu.Unlock()
+				assert.NoError(t, l.Failure(ctx, "ip", "code"))
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, 1, attempts)
+
+		// Waiting for a reservation stops when the context is done.
+		release, err := l.Reserve(ctx, "other")
+		require.NoError(t, err)
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err = l.Reserve(cancelled, "other")
+		assert.ErrorIs(t, err, context.Canceled)
+		release()
+	})
+}
+
+func TestRateLimitedServer(t *testing.T) {
+	t.Parallel()
+
+	cv, err := newVerifier()
+	require.NoError(t, err)
+
+	authReq := &AuthenticationRequest{
+		ClientID:            "https://example.com/",
+		RedirectURI:         "https://example.com/callback",
+		CodeChallenge:       s256Challenge(cv),
+		CodeChallengeMethod: "S256",
+	}
+
+	newRequest := func(verifier string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {"code"},
+			"client_id":     {authReq.ClientID},
+			"redirect_uri":  {authReq.RedirectURI},
+			"code_verifier": {verifier},
+		}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+
+	s := NewRateLimitedServer(NewServer(true, nil), nil)
+	s.Limiter.MaxFailures = 2
+
+	err = s.ValidateTokenExchange(authReq, newRequest(strings.Repeat("a", 50)))
+	assert.ErrorIs(t, err, ErrCodeChallengeFailed)
+
+	// Blocked even with the right verifier, due to the backoff.
+	err = s.ValidateTokenExchange(authReq, newRequest(cv))
+	assert.ErrorIs(t, err, ErrRateLimited)
+
+	w := httptest.NewRecorder()
+	assert.True(t, ServeRateLimitError(w, err))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	assert.False(t, ServeRateLimitError(httptest.NewRecorder(), ErrCodeChallengeFailed))
+
+	now := time.Now().Add(time.Second * 2)
+	s.Limiter.now = func() time.Time { return now }
+	require.NoError(t, s.Limiter.Failure(context.Background(), "ip:192.0.2.1"))
+
+	err = s.ValidateTokenExchange(authReq, newRequest(cv))
+	var rle *RateLimitError
+	require.True(t, errors.As(err, &rle))
+	assert.Equal(t, s.Limiter.Lockout, rle.RetryAfter)
+
+	// Different IP addresses are limited separately.
+	s.RemoteIP = func(r *http.Request) string { return "192.0.2.2" }
+	err = s.ValidateTokenExchange(authReq, newRequest(cv))
+	assert.NoError(t, err)
+
+	// Failures from one IP address do not lock out the client elsewhere.
+	s.RemoteIP = func(r *http.Request) string { return "192.0.2.3" }
+	for range 2 {
+		require.NoError(t, s.Failure(newRequest(cv)))
+	}
+	assert.ErrorIs(t, s.Allow(newRequest(cv)), ErrRateLimited)
+
+	s.RemoteIP = func(r *http.Request) string { return "192.0.2.4" }
+	assert.NoError(t, s.Allow(httptest.NewRequest(http.MethodGet, "/auth?client_id="+url.QueryEscape(authReq.ClientID), nil)))
+
+	// Concurrent guesses are validated one at a time, such that they cannot all
+	// pass before a failure is recorded.
+	s = NewRateLimitedServer(NewServer(true, nil), nil)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		attempts int
+	)
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.ValidateTokenExchange(authReq, newRequest(strings.Repeat("b", 50)))
+			if errors.Is(err, ErrCodeChallengeFailed) {
+				mu.Lock()
+				attempts++
+				mu.Unlock()
+			} else {
+				assert.ErrorIs(t, err, ErrRateLimited)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, attempts)
+}
@@ -1,14 +1,18 @@
 package indieauth
 
 import (
+	"context"
 	"errors"
+	"log/slog"
 	"net/http"
 	urlpkg "net/url"
 	"strings"
+	"time"
 )
 
 var (
 	ErrInvalidRedirectURI         error = errors.New("redirect_uri is invalid")
+	ErrRedirectURINotPublished    error = errors.New("redirect_uri is not published by the client")
 	ErrInvalidCodeChallengeMethod error = errors.New("invalid code challenge method")
 	ErrInvalidGrantType           error = errors.New("grant_type must be authorization_code")
 	ErrNoMatchClientID            error = errors.New("client_id differs")
@@ -23,13 +27,56 @@ var (
 type Server struct {
 	Client      *http.Client
 	RequirePKCE bool
+
+	// PushedAuthorizations stores the pushed authorization requests. If nil,
+	// pushed authorization requests are not supported.
+	PushedAuthorizations PushedAuthorizationStore
+
+	// PushedAuthorizationLifetime is the lifetime of pushed authorization requests.
+	PushedAuthorizationLifetime time.Duration
+
+	// DPoP verifies DPoP proofs at the token endpoint. If nil, DPoP is not
+	// supported. See [Server.ValidateDPoPTokenExchange].
+	DPoP *DPoPVerifier
+
+	// Observer receives the audit events emitted by the server. If nil, no
+	// events are emitted. See [Server.Emit].
+	Observer EventObserver
+
+	// Logger logs the internal errors of the handlers, which are replied to the
+	// client with a generic description. If nil, [slog.Default] is used.
+	Logger *slog.Logger
+
+	// ApprovalHooks are called, in order, by [Server.Approve] before approving
+	// an authorization request.
+	ApprovalHooks []ApprovalHook
+
+	// Policy controls how strictly the specification is followed. If nil,
+	// [DefaultPolicy] is used. See [StrictPolicy].
+	Policy *Policy
 }
 
+// logger returns the logger of the server, or [slog.Default] if there is none.
+func (s *Server) logger() *slog.Logger {
+	if s == nil || s.Logger == nil {
+		return slog.Default()
+	}
+	return s.Logger
+}
+
+// ApprovalHook is called before approving an authorization request. It must
+// return an error if the request must not be approved, for example, if the
+// owner is not authenticated. The hook may set [AuthenticationRequest.Me].
+type ApprovalHook func(r *http.Request, req *AuthenticationRequest) error
+
 // NewServer creates a new [Server] that from the given options. If
-// no httpClient is given, [http.DefaultClient] will be used.
+// no httpClient is given, [http.DefaultClient] will be used. Pushed
+// authorization requests are stored in memory.
 func NewServer(requirePKCE bool, httpClient *http.Client) *Server {
 	s := &Server{
-		RequirePKCE: requirePKCE,
+		RequirePKCE:                 requirePKCE,
+		PushedAuthorizations:        NewMemoryPushedAuthorizationStore(),
+		PushedAuthorizationLifetime: DefaultPushedAuthorizationLifetime,
 	}
 
 	if httpClient != nil {
@@ -48,17 +95,53 @@ type AuthenticationRequest struct {
 	State               string
 	CodeChallenge       string
 	CodeChallengeMethod string
+
+	// Me is the user's profile URL. When parsing an authorization request, it
+	// is the optional hint given by the client. The server should set it to the
+	// profile URL of the authenticated user before issuing a code.
+	Me string
+
+	// DPoPJKT is the thumbprint of the DPoP key the authorization code must be
+	// bound to, as given by the dpop_jkt parameter.
+	DPoPJKT string
 }
 
 // ParseAuthorization parses an authorization request and returns all the collected
 // information about the request.
+//
+// If the request contains a request_uri parameter, the request is resolved from
+// the pushed authorization requests, as per [RFC 9126]. See
+// [Server.PushedAuthorizationHandler] for more details.
+//
+// [RFC 9126]: https://datatracker.ietf.org/doc/html/rfc9126
 func (s *Server) ParseAuthorization(r *http.Request) (*AuthenticationRequest, error) {
 	if err := r.ParseForm(); err != nil {
 		return nil, err
 	}
 
-	resType := r.FormValue("response_type")
-	if resType == "" {
+	var (
+		req *AuthenticationRequest
+		err error
+	)
+
+	if requestURI := r.Form.Get("request_uri"); requestURI != "" {
+		req, err = s.resolvePushedAuthorization(r.Context(), requestURI, r.Form.Get("client_id"))
+	} else {
+		req, err = s.parseAuthorization(r.Context(), r.Form)
+	}
+
+	if err != nil {
+		s.Emit(r, EventVerificationFailed, nil, err)
+		return nil, err
+	}
+
+	s.Emit(r, EventAuthorizationRequested, req, nil)
+	return req, nil
+}
+
+func (s *Server) parseAuthorization(ctx context.Context, form urlpkg.Values) (*AuthenticationRequest, error) {
+	resType := form.Get("response_type")
+	if resType == "" && s.policy().AllowLegacyDefaults {
 		// Default to support legacy clients.
 		resType = "code"
 	}
@@ -67,13 +150,13 @@ func (s *Server) ParseAuthorization(r *http.Request) (*AuthenticationRequest, er
 		return nil, ErrInvalidResponseType
 	}
 
-	clientID := r.FormValue("client_id")
+	clientID := form.Get("client_id")
 	if err := IsValidClientIdentifier(clientID); err != nil {
 		return nil, err
 	}
 
-	redirectURI := r.FormValue("redirect_uri")
-	if err := s.validateRedirectURI(clientID, redirectURI); err != nil {
+	redirectURI := form.Get("redirect_uri")
+	if err := s.validateRedirectURI(ctx, clientID, redirectURI); err != nil {
 		return nil, err
 	}
 
@@ -82,57 +165,124 @@ func (s *Server) ParseAuthorization(r *http.Request) (*AuthenticationRequest, er
 		ccm string
 	)
 
-	cc = r.Form.Get("code_challenge")
+	cc = form.Get("code_challenge")
 	if cc != "" {
 		if len(cc) < 43 || len(cc) > 128 {
 			return nil, ErrWrongCodeChallengeLength
 		}
 
-		ccm = r.Form.Get("code_challenge_method")
-		if !IsValidCodeChallengeMethod(ccm) {
+		ccm = form.Get("code_challenge_method")
+		if !s.policy().AllowsCodeChallengeMethod(ccm) {
 			return nil, ErrInvalidCodeChallengeMethod
 		}
-	} else if s.RequirePKCE {
+	} else if s.requirePKCE() {
 		return nil, ErrPKCERequired
 	}
 
 	req := &AuthenticationRequest{
 		RedirectURI:         redirectURI,
 		ClientID:            clientID,
-		State:               r.Form.Get("state"),
+		State:               form.Get("state"),
 		Scopes:              []string{},
 		CodeChallenge:       cc,
 		CodeChallengeMethod: ccm,
+		Me:                  form.Get("me"),
+		DPoPJKT:             form.Get("dpop_jkt"),
 	}
 
-	scope := r.Form.Get("scope")
+	scope := form.Get("scope")
 	if scope != "" {
 		req.Scopes = strings.Split(scope, " ")
-	} else if scopes := r.Form["scopes"]; len(scopes) > 0 {
-		req.Scopes = scopes
+	} else if s.policy().AllowScopesArray {
+		if scopes := form["scopes"]; len(scopes) > 0 {
+			req.Scopes = scopes
+		} else if scopes := form["scopes[]"]; len(scopes) > 0 {
+			req.Scopes = scopes
+		}
 	}
 
 	return req, nil
 }
 
-func (s *Server) validateRedirectURI(clientID, redirectURI string) error {
+// Approve must be called when the user approves the authorization request,
+// before issuing the authorization code. It runs [Server.ApprovalHooks] and
+// returns the first error, in which case the request must be denied. On success,
+// [EventAuthorizationApproved] is emitted.
+func (s *Server) Approve(r *http.Request, req *AuthenticationRequest) error {
+	for _, hook := range s.ApprovalHooks {
+		if err := hook(r, req); err != nil {
+			s.Emit(r, EventVerificationFailed, req, err)
+			return err
+		}
+	}
+
+	s.Emit(r, EventAuthorizationApproved, req, nil)
+	return nil
+}
+
+// validateRedirectURI validates the redirect URI of the given client. Redirect
+// URIs on the same host as the client are always allowed. Otherwise, including
+// for private-use URI schemes, the redirect URI must be published by the client.
+// See [Server.DiscoverRedirectURIs].
+//
+// As per [RFC 8252], the port of loopback redirect URIs is ignored, such that
+// native applications can listen on any available port.
+//
+// [RFC 8252]: https://datatracker.ietf.org/doc/html/rfc8252#section-7.3
+func (s *Server) validateRedirectURI(ctx context.Context, clientID, redirectURI string) error {
 	client, err := urlpkg.Parse(clientID)
 	if err != nil {
 		return errors.Join(ErrInvalidRedirectURI, err)
 	}
 
+	if err := IsValidRedirectURI(redirectURI); err != nil {
+		return err
+	}
+
 	redirect, err := urlpkg.Parse(redirectURI)
 	if err != nil {
 		return errors.Join(ErrInvalidRedirectURI, err)
 	}
 
-	if redirect.Host == client.Host {
-		return nil
+	if redirect.Scheme == "http" || redirect.Scheme == "https" {
+		if redirect.Host == client.Host {
+			return nil
+		}
+
+		if isLoopback(redirect) && isLoopback(client) && redirect.Hostname() == client.Hostname() {
+			return nil
+		}
 	}
 
-	// TODO: redirect URI may have a different host. In this case, we do
-	// discovery: https://indieauth.spec.indieweb.org/#redirect-url
-	return errors.Join(ErrInvalidRedirectURI, errors.New("redirect uri has different host from client id"))
+	published, err := s.DiscoverRedirectURIs(ctx, clientID)
+	if err != nil {
+		return errors.Join(ErrInvalidRedirectURI, err)
+	}
+
+	for _, p := range published {
+		if redirectURIMatches(p, redirect) {
+			return nil
+		}
+	}
+
+	return errors.Join(ErrInvalidRedirectURI, ErrRedirectURINotPublished)
+}
+
+// redirectURIMatches returns whether the redirect URI matches the published
+// one. Loopback redirect URIs match regardless of their port.
+func redirectURIMatches(published string, redirect *urlpkg.URL) bool {
+	if published == redirect.String() {
+		return true
+	}
+
+	p, err := urlpkg.Parse(published)
+	if err != nil || !isLoopback(p) || !isLoopback(redirect) {
+		return false
+	}
+
+	return p.Hostname() == redirect.Hostname() &&
+		p.Path == redirect.Path &&
+		p.RawQuery == redirect.RawQuery
 }
 
 // ValidateTokenExchange validates the token exchange request according to the
@@ -148,12 +298,22 @@ func (s *Server) validateRedirectURI(clientID, redirectURI string) error {
 // it to rebuild the [AuthenticationRequest] data. The [AuthenticationRequest]
 // does not need to have the scope or state set for this validation.
 func (s *Server) ValidateTokenExchange(authRequest *AuthenticationRequest, r *http.Request) error {
+	if err := s.validateTokenExchange(authRequest, r); err != nil {
+		s.Emit(r, EventVerificationFailed, authRequest, err)
+		return err
+	}
+
+	s.Emit(r, EventCodeExchanged, authRequest, nil)
+	return nil
+}
+
+func (s *Server) validateTokenExchange(authRequest *AuthenticationRequest, r *http.Request) error {
 	if err := r.ParseForm(); err != nil {
 		return err
 	}
 
 	grantType := r.Form.Get("grant_type")
-	if grantType == "" {
+	if grantType == "" && s.policy().AllowLegacyDefaults {
 		// Default to support legacy clients.
 		grantType = "authorization_code"
 	}
@@ -176,7 +336,7 @@ func (s *Server) ValidateTokenExchange(authRequest *AuthenticationRequest, r *ht
 	}
 
 	if authRequest.CodeChallenge == "" {
-		if s.RequirePKCE {
+		if s.requirePKCE() {
 			return ErrPKCERequired
 		}
 	} else {
@@ -189,9 +349,12 @@ func (s *Server) ValidateTokenExchange(authRequest *AuthenticationRequest, r *ht
 			return ErrWrongCodeChallengeLength
 		}
 		ccm := authRequest.CodeChallengeMethod
-		if !IsValidCodeChallengeMethod(ccm) {
+		if !s.policy().AllowsCodeChallengeMethod(ccm) {
 			return ErrInvalidCodeChallengeMethod
 		}
+		if minEntropy := s.policy().MinVerifierEntropy; minEntropy > 0 && verifierEntropy(codeVerifier) < float64(minEntropy) {
+			return ErrWeakCodeVerifier
+		}
 
 		if !ValidateCodeChallenge(ccm, cc, codeVerifier) {
 			return ErrCodeChallengeFailed
@@ -200,3 +363,53 @@ func (s *Server) ValidateTokenExchange(authRequest *AuthenticationRequest, r *ht
 
 	return nil
 }
+
+// ValidateDPoPTokenExchange validates the token exchange request in the same way
+// as [Server.ValidateTokenExchange] and, in addition, validates the DPoP proof
+// of the request, as per [RFC 9449], using [Server.DPoP].
+//
+// If the request contains a valid proof, the proof is returned, and the token
+// issued must be bound to [DPoPProof.Thumbprint] and have [TokenTypeDPoP] as its
+// type. If the request contains no proof, nil is returned and a bearer token can
+// be issued, unless the authorization code was bound to a DPoP key, in which case
+// an [ErrDPoPRequired] error is returned.
+//
+// [RFC 9449]: https://datatracker.ietf.org/doc/html/rfc9449#section-5
+func (s *Server) ValidateDPoPTokenExchange(authRequest *AuthenticationRequest, r *http.Request) (*DPoPProof, error) {
+	proof, err := s.validateDPoPTokenExchange(authRequest, r)
+	if err != nil {
+		s.Emit(r, EventVerificationFailed, authRequest, err)
+		return nil, err
+	}
+
+	s.Emit(r, EventCodeExchanged, authRequest, nil)
+	return proof, nil
+}
+
+func (s *Server) validateDPoPTokenExchange(authRequest *AuthenticationRequest, r *http.Request) (*DPoPProof, error) {
+	if err := s.validateTokenExchange(authRequest, r); err != nil {
+		return nil, err
+	}
+
+	if r.Header.Get(DPoPHeader) == "" {
+		if authRequest.DPoPJKT != "" {
+			return nil, ErrDPoPRequired
+		}
+		return nil, nil
+	}
+
+	if s.DPoP == nil {
+		return nil, ErrDPoPUnsupported
+	}
+
+	proof, err := s.DPoP.Verify(r, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if authRequest.DPoPJKT != "" && authRequest.DPoPJKT != proof.Thumbprint {
+		return nil, ErrDPoPKeyMismatch
+	}
+
+	return proof, nil
+}
@@ -76,6 +76,7 @@ func TestParseAuthorization(t *testing.T) {
 			{false, strings.Repeat("a", 100), "unknown", "profile", ErrInvalidCodeChallengeMethod},
 		} {
 			ias := NewServer(testCase.requirePKCE, nil)
+			ias.Policy = &legacyPlainPolicy
 
 			r := httptest.NewRequest(http.MethodPost, "/", nil)
 			r.Form = url.Values{}
@@ -105,6 +106,87 @@ func TestParseAuthorization(t *testing.T) {
 	})
 }
 
+func TestParseAuthorizationRedirectURI(t *testing.T) {
+	t.Parallel()
+
+	ias := NewServer(false, &http.Client{
+		Transport: &handlerRoundTripper{
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Host {
+				case "example.com":
+					w.Header().Set("Link", `<https://auth.example.net/callback>; rel="redirect_uri"`)
+					w.Header().Set("Content-Type", "text/html; charset=utf-8")
+					_, _ = w.Write([]byte(`<html><head>
+						<link rel="redirect_uri" href="com.example.app:/callback">
+						<link rel="redirect_uri" href="http://127.0.0.1/callback">
+						<link rel="redirect_uri" href="/relative">
+					</head></html>`))
+				case "example.org":
+					w.Header().Set("Content-Type", "application/json")
+					_, _ = w.Write([]byte(`{"client_id":"https://example.org/","redirect_uris":["org.example.app:/callback"]}`))
+				case "example.io":
+					// Documents are only read up to a limit.
+					w.Header().Set("Content-Type", "text/html; charset=utf-8")
+					_, _ = w.Write([]byte(`<html><body>` + strings.Repeat("a", maxRedirectURIsDocumentSize) + `<link rel="redirect_uri" href="io.example.app:/callback"></body></html>`))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}),
+		},
+	})
+
+	for _, testCase := range []struct {
+		clientID      string
+		redirectURI   string
+		expectedError error
+	}{
+		{"https://example.com/", "https://example.com/callback", nil},
+		{"https://example.com/", "https://auth.example.net/callback", nil},
+		{"https://example.com/", "https://example.com/relative", nil},
+		{"https://example.com/", "com.example.app:/callback", nil},
+		{"https://example.com/", "com.example.app:/other", ErrRedirectURINotPublished},
+		{"https://example.com/", "com.example.evil:/callback", ErrRedirectURINotPublished},
+		{"https://example.com/", "exampleapp:/callback", ErrInvalidRedirectScheme},
+		{"https://example.com/", "javascript:alert(1)", ErrInvalidRedirectScheme},
+		{"https://example.com/", "https://example.com/callback#fragment", ErrInvalidFragment},
+		{"https://example.com/", "http://127.0.0.1/callback", nil},
+		{"https://example.com/", "http://127.0.0.1:51004/callback", nil},
+		{"https://example.com/", "http://127.0.0.1:51004/other", ErrRedirectURINotPublished},
+		{"https://example.com/", "https://127.0.0.1:51004/callback", ErrRedirectURINotPublished},
+		{"https://example.org/", "org.example.app:/callback", nil},
+		{"https://example.org/", "com.example.app:/callback", ErrRedirectURINotPublished},
+		{"https://example.net/", "net.example.app:/callback", ErrInvalidRedirectURI},
+		{"https://example.io/", "io.example.app:/callback", ErrRedirectURINotPublished},
+		{"http://127.0.0.1/", "http://127.0.0.1:8080/callback", nil},
+		{"http://localhost/", "http://localhost:8080/callback", nil},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Form = url.Values{}
+		r.Form.Set("response_type", "code")
+		r.Form.Set("client_id", testCase.clientID)
+		r.Form.Set("redirect_uri", testCase.redirectURI)
+
+		authReq, err := ias.ParseAuthorization(r)
+		if testCase.expectedError == nil {
+			assert.NoError(t, err, testCase.redirectURI)
+			if assert.NotNil(t, authReq) {
+				assert.Equal(t, testCase.redirectURI, authReq.RedirectURI)
+			}
+		} else {
+			assert.ErrorIs(t, err, ErrInvalidRedirectURI, testCase.redirectURI)
+			assert.ErrorIs(t, err, testCase.expectedError, testCase.redirectURI)
+		}
+	}
+}
+
+// legacyPlainPolicy is the [DefaultPolicy] with the "plain" code challenge
+// method explicitly allowed.
+var legacyPlainPolicy = Policy{
+	CodeChallengeMethods: []string{"plain", "S256"},
+	AllowLegacyDefaults:  true,
+	AllowScopesArray:     true,
+}
+
 func TestValidateTokenExchange(t *testing.T) {
 	t.Parallel()
 
@@ -131,6 +213,7 @@ func TestValidateTokenExchange(t *testing.T) {
 			{"authorization_code", "https://example.com/", "https://example.com/callback2", ErrNoMatchRedirectURI},
 		} {
 			ias := NewServer(true, nil)
+			ias.Policy = &legacyPlainPolicy
 			r := httptest.NewRequest(http.MethodPost, "/", nil)
 			r.Form = url.Values{}
 			r.Form.Set("grant_type", testCase.grantType)
@@ -163,6 +246,7 @@ func TestValidateTokenExchange(t *testing.T) {
 			{false, strings.Repeat("a", 50), "plain", strings.Repeat("a", 51), ErrCodeChallengeFailed},
 		} {
 			ias := NewServer(testCase.requirePKCE, nil)
+			ias.Policy = &legacyPlainPolicy
 
 			authReq := &AuthenticationRequest{
 				ClientID:            "https://example.com/",
@@ -0,0 +1,146 @@
+package indieauth
+
+import (
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TOTPPeriod is the time step of TOTP codes, as recommended by [RFC 6238].
+	//
+	// [RFC 6238]: https://datatracker.ietf.org/doc/html/rfc6238
+	TOTPPeriod = time.Second * 30
+
+	// TOTPDigits is the number of digits of TOTP codes.
+	TOTPDigits = 6
+
+	// totpModulo is 10 to the power of [TOTPDigits].
+	totpModulo = 1_000_000
+
+	// totpSkew is the number of time steps before and after the current one
+	// during which a code is still accepted, to account for clock drift.
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret generates a new random base32 encoded TOTP secret.
+func NewTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := cryptorand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPURI returns the otpauth:// URI of the given secret, which can be shown as
+// a QR code to be scanned by authenticator applications.
+func TOTPURI(secret, issuer, account string) string {
+	query := url.Values{
+		"secret": {secret},
+		"issuer": {issuer},
+	}
+
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + query.Encode()
+}
+
+// GenerateTOTP generates the TOTP code of the given base32 encoded secret at
+// the given time, as per [RFC 6238], using HMAC-SHA1.
+//
+// [RFC 6238]: https://datatracker.ietf.org/doc/html/rfc6238
+func GenerateTOTP(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return totpCode(key, totpStep(t)), nil
+}
+
+// ValidateTOTP reports whether the code is valid for the given base32 encoded
+// secret at the given time. Codes from the previous and next time steps are
+// also accepted to account for clock drift.
+func ValidateTOTP(secret, code string, t time.Time) bool {
+	_, ok := validateTOTP(secret, code, t)
+	return ok
+}
+
+// validateTOTP validates the code and returns the time step it matched.
+func validateTOTP(secret, code string, t time.Time) (uint64, bool) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil || len(code) != TOTPDigits {
+		return 0, false
+	}
+
+	step := totpStep(t)
+	for i := -totpSkew; i <= totpSkew; i++ {
+		s := step + uint64(i)
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, s)), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+
+	return 0, false
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	return totpEncoding.DecodeString(strings.TrimRight(secret, "="))
+}
+
+func totpStep(t time.Time) uint64 {
+	return uint64(t.Unix() / int64(TOTPPeriod/time.Second))
+}
+
+// totpCode computes the HOTP value of the given counter, as per RFC 4226.
+func totpCode(key []byte, counter uint64) string {
+	mac := hmac.New(sha1.New, key)
+	_ = binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", TOTPDigits, value%totpModulo)
+}
+
+// NewRecoveryCodes generates n random recovery codes, each with 80 bits of
+// entropy, formatted as four groups of five hexadecimal digits. The codes must
+// be shown to the user once, while only the hashes must be stored. See
+// [HashRecoveryCode].
+func NewRecoveryCodes(n int) (codes []string, hashes []string, err error) {
+	for range n {
+		b := make([]byte, 10)
+		if _, err := cryptorand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		code := hex.EncodeToString(b)
+		code = code[:5] + "-" + code[5:10] + "-" + code[10:15] + "-" + code[15:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode hashes a recovery code for storage with SHA-256. This is only
+// sufficient for codes generated by [NewRecoveryCodes], whose 80 bits of entropy
+// make guessing them from a leaked hash infeasible. Dashes, spaces and letter
+// case are ignored.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
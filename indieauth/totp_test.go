@@ -0,0 +1,58 @@
+package indieauth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTP(t *testing.T) {
+	t.Parallel()
+
+	// Test vectors from RFC 6238, Appendix B, truncated to 6 digits.
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	for _, testCase := range []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	} {
+		code, err := GenerateTOTP(secret, time.Unix(testCase.time, 0))
+		require.NoError(t, err)
+		assert.Equal(t, testCase.code, code)
+		assert.True(t, ValidateTOTP(secret, testCase.code, time.Unix(testCase.time, 0)))
+	}
+
+	now := time.Unix(1234567890, 0)
+	assert.True(t, ValidateTOTP(secret, "005924", now.Add(TOTPPeriod)))
+	assert.False(t, ValidateTOTP(secret, "005924", now.Add(TOTPPeriod*3)))
+	assert.False(t, ValidateTOTP(secret, "005925", now))
+	assert.False(t, ValidateTOTP("invalid!", "005924", now))
+
+	secret, err := NewTOTPSecret()
+	require.NoError(t, err)
+	assert.Len(t, secret, 32)
+	assert.Equal(t, "otpauth://totp/Example:https:%2F%2Fexample.org%2F?issuer=Example&secret="+secret, TOTPURI(secret, "Example", "https://example.org/"))
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	t.Parallel()
+
+	codes, hashes, err := NewRecoveryCodes(10)
+	require.NoError(t, err)
+	require.Len(t, codes, 10)
+	require.Len(t, hashes, 10)
+
+	assert.Regexp(t, `^[0-9a-f]{5}-[0-9a-f]{5}-[0-9a-f]{5}-[0-9a-f]{5}$`, codes[0])
+	assert.NotEqual(t, codes[0], codes[1])
+	assert.Equal(t, hashes[0], HashRecoveryCode(codes[0]))
+	assert.Equal(t, hashes[0], HashRecoveryCode(" "+strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))))
+}
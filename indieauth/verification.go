@@ -19,6 +19,8 @@ var (
 	ErrPortIsSet       error = errors.New("port must not be set")
 	ErrIsIP            error = errors.New("profile cannot be ip address")
 	ErrIsNonLoopback   error = errors.New("client id cannot be non-loopback ip")
+
+	ErrInvalidRedirectScheme error = errors.New("scheme must be http, https or a private-use scheme containing a period")
 )
 
 // IsValidProfileURL validates the profile URL according to the [specification].
@@ -99,6 +101,54 @@ func IsValidClientIdentifier(identifier string) error {
 	return nil
 }
 
+// IsValidRedirectURI validates a redirect URI. Besides http and https URLs,
+// private-use URI schemes, as used by native applications, are allowed, as per
+// [RFC 8252]. Such schemes must contain a period, such as "com.example.app".
+//
+// [RFC 8252]: https://datatracker.ietf.org/doc/html/rfc8252#section-7.1
+func IsValidRedirectURI(redirectURI string) error {
+	url, err := urlpkg.Parse(redirectURI)
+	if err != nil {
+		return errors.Join(ErrInvalidRedirectURI, err)
+	}
+
+	switch url.Scheme {
+	case "http", "https":
+		if url.Host == "" {
+			return errors.Join(ErrInvalidRedirectURI, errors.New("host must not be empty"))
+		}
+	default:
+		if !strings.Contains(url.Scheme, ".") {
+			return errors.Join(ErrInvalidRedirectURI, ErrInvalidRedirectScheme)
+		}
+	}
+
+	if url.Fragment != "" {
+		return errors.Join(ErrInvalidRedirectURI, ErrInvalidFragment)
+	}
+
+	if url.User.String() != "" {
+		return errors.Join(ErrInvalidRedirectURI, ErrUserIsSet)
+	}
+
+	return nil
+}
+
+// isLoopback returns whether the URL is an http URL whose host is a loopback
+// IP address or localhost.
+func isLoopback(url *urlpkg.URL) bool {
+	if url.Scheme != "http" {
+		return false
+	}
+
+	if url.Hostname() == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(url.Hostname())
+	return ip != nil && ip.IsLoopback()
+}
+
 // CanonicalizeURL checks if a URL has a path, and appends a path "/""
 // if it has no path.
 func CanonicalizeURL(urlStr string) string {
@@ -0,0 +1,744 @@
+package indieauth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultWebAuthnTimeout is the default amount of time the user has to
+	// complete a WebAuthn ceremony.
+	DefaultWebAuthnTimeout = time.Minute * 5
+
+	webAuthnCreate = "webauthn.create"
+	webAuthnGet    = "webauthn.get"
+
+	webAuthnFlagUserPresent  = 0x01
+	webAuthnFlagUserVerified = 0x04
+	webAuthnFlagAttested     = 0x40
+	webAuthnFlagExtensions   = 0x80
+
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+)
+
+var (
+	ErrWebAuthnChallenge              error = errors.New("webauthn challenge is invalid or expired")
+	ErrWebAuthnInvalidClientData      error = errors.New("webauthn client data is invalid")
+	ErrWebAuthnInvalidAuthenticator   error = errors.New("webauthn authenticator data is invalid")
+	ErrWebAuthnUnsupportedAttestation error = errors.New("webauthn attestation format is not supported")
+	ErrWebAuthnUnsupportedKey         error = errors.New("webauthn public key is not supported")
+	ErrWebAuthnInvalidSignature       error = errors.New("webauthn signature is invalid")
+	ErrWebAuthnCredentialNotFound     error = errors.New("webauthn credential not found")
+	ErrWebAuthnCredentialExists       error = errors.New("webauthn credential already registered")
+	ErrWebAuthnSignCount              error = errors.New("webauthn signature counter did not increase")
+)
+
+// WebAuthnAlgorithms are the COSE algorithms of the credential public keys
+// supported by [WebAuthn]: ES256, EdDSA (Ed25519) and RS256.
+var WebAuthnAlgorithms = []int{coseAlgES256, coseAlgEdDSA, coseAlgRS256}
+
+// Base64URLBytes is a byte slice that is encoded in JSON as an unpadded base64url
+// string, as used by the JSON serialization of WebAuthn structures.
+type Base64URLBytes []byte
+
+func (b Base64URLBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Base64URLBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	v, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+
+	*b = v
+	return nil
+}
+
+// WebAuthnCredential is a public key credential registered by a user.
+type WebAuthnCredential struct {
+	ID        []byte    `json:"id"`
+	Me        string    `json:"me"`
+	Name      string    `json:"name,omitempty"`
+	PublicKey []byte    `json:"public_key"`
+	Algorithm int       `json:"algorithm"`
+	SignCount uint32    `json:"sign_count"`
+	CreatedAt time.Time `json:"created_at"`
+	LastUsed  time.Time `json:"last_used,omitzero"`
+}
+
+// WebAuthnCredentialStore stores WebAuthn credentials.
+type WebAuthnCredentialStore interface {
+	// Get returns the credential with the given ID. Must return
+	// [ErrWebAuthnCredentialNotFound] if the credential does not exist.
+	Get(ctx context.Context, id []byte) (*WebAuthnCredential, error)
+
+	// Put stores the credential, replacing any existing credential with the
+	// same ID.
+	Put(ctx context.Context, credential *WebAuthnCredential) error
+
+	// List returns all the credentials of the given user.
+	List(ctx context.Context, me string) ([]*WebAuthnCredential, error)
+
+	// Delete deletes the credential with the given ID.
+	Delete(ctx context.Context, id []byte) error
+}
+
+type memoryWebAuthnCredentialStore struct {
+	mu          sync.RWMutex
+	credentials map[string]*WebAuthnCredential
+}
+
+// NewMemoryWebAuthnCredentialStore creates a new in-memory [WebAuthnCredentialStore].
+func NewMemoryWebAuthnCredentialStore() WebAuthnCredentialStore {
+	return &memoryWebAuthnCredentialStore{
+		credentials: map[string]*WebAuthnCredential{},
+	}
+}
+
+func (m *memoryWebAuthnCredentialStore) Get(_ context.Context, id []byte) (*WebAuthnCredential, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c, ok := m.credentials[string(id)]
+	if !ok {
+		return nil, ErrWebAuthnCredentialNotFound
+	}
+
+	return copyWebAuthnCredential(c), nil
+}
+
+func (m *memoryWebAuthnCredentialStore) Put(_ context.Context, credential *WebAuthnCredential) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.credentials[string(credential.ID)] = copyWebAuthnCredential(credential)
+	return nil
+}
+
+func (m *memoryWebAuthnCredentialStore) List(_ context.Context, me string) ([]*WebAuthnCredential, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	credentials := []*WebAuthnCredential{}
+	for _, c := range m.credentials {
+		if c.Me == me {
+			credentials = append(credentials, copyWebAuthnCredential(c))
+		}
+	}
+
+	slices.SortFunc(credentials, func(a, b *WebAuthnCredential) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return credentials, nil
+}
+
+func (m *memoryWebAuthnCredentialStore) Delete(_ context.Context, id []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.credentials, string(id))
+	return nil
+}
+
+func copyWebAuthnCredential(c *WebAuthnCredential) *WebAuthnCredential {
+	cp := *c
+	cp.ID = slices.Clone(c.ID)
+	cp.PublicKey = slices.Clone(c.PublicKey)
+	return &cp
+}
+
+// WebAuthnCredentialDescriptor identifies a credential.
+type WebAuthnCredentialDescriptor struct {
+	Type string         `json:"type"`
+	ID   Base64URLBytes `json:"id"`
+}
+
+// WebAuthnCreationOptions are the options for the registration ceremony. They
+// are the JSON serialization of PublicKeyCredentialCreationOptions and can be
+// given to PublicKeyCredential.parseCreationOptionsFromJSON in the browser.
+type WebAuthnCreationOptions struct {
+	Challenge Base64URLBytes `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          Base64URLBytes `json:"id"`
+		Name        string         `json:"name"`
+		DisplayName string         `json:"displayName"`
+	} `json:"user"`
+	PubKeyCredParams []struct {
+		Type string `json:"type"`
+		Alg  int    `json:"alg"`
+	} `json:"pubKeyCredParams"`
+	Timeout                int64                          `json:"timeout,omitempty"`
+	ExcludeCredentials     []WebAuthnCredentialDescriptor `json:"excludeCredentials,omitempty"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey,omitempty"`
+		UserVerification string `json:"userVerification,omitempty"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+// WebAuthnRequestOptions are the options for the authentication ceremony. They
+// are the JSON serialization of PublicKeyCredentialRequestOptions and can be
+// given to PublicKeyCredential.parseRequestOptionsFromJSON in the browser.
+type WebAuthnRequestOptions struct {
+	Challenge        Base64URLBytes                 `json:"challenge"`
+	Timeout          int64                          `json:"timeout,omitempty"`
+	RPID             string                         `json:"rpId"`
+	AllowCredentials []WebAuthnCredentialDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string                         `json:"userVerification,omitempty"`
+}
+
+// WebAuthnRegistrationResponse is the JSON serialization of the credential
+// created by the browser during the registration ceremony.
+type WebAuthnRegistrationResponse struct {
+	ID       string         `json:"id"`
+	RawID    Base64URLBytes `json:"rawId"`
+	Type     string         `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URLBytes `json:"clientDataJSON"`
+		AttestationObject Base64URLBytes `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// WebAuthnAssertionResponse is the JSON serialization of the assertion created
+// by the browser during the authentication ceremony.
+type WebAuthnAssertionResponse struct {
+	ID       string         `json:"id"`
+	RawID    Base64URLBytes `json:"rawId"`
+	Type     string         `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URLBytes `json:"clientDataJSON"`
+		AuthenticatorData Base64URLBytes `json:"authenticatorData"`
+		Signature         Base64URLBytes `json:"signature"`
+		UserHandle        Base64URLBytes `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+type webAuthnChallenge struct {
+	me         string
+	ceremony   string
+	expiration time.Time
+}
+
+// WebAuthn implements the relying party side of the [WebAuthn] registration
+// and authentication ceremonies. It can be used to authenticate the owner of
+// an IndieAuth server with passkeys or security keys before approving an
+// authorization request.
+//
+// Only the "none" attestation is supported, which means the authenticator
+// itself is not verified. This is the recommended setting for most relying
+// parties. Pending challenges are kept in memory and can only be used once.
+//
+// [WebAuthn]: https://www.w3.org/TR/webauthn-3/
+type WebAuthn struct {
+	// RPID is the relying party identifier, usually the host name of the server.
+	RPID string
+
+	// RPName is the human friendly name of the relying party.
+	RPName string
+
+	// Origins are the allowed origins of the ceremonies, such as
+	// "https://example.com".
+	Origins []string
+
+	// Store stores the registered credentials.
+	Store WebAuthnCredentialStore
+
+	// RequireUserVerification requires the authenticator to verify the user,
+	// for example, with a PIN or biometrics.
+	RequireUserVerification bool
+
+	// Timeout is the amount of time the user has to complete a ceremony.
+	Timeout time.Duration
+
+	mu         sync.Mutex
+	challenges map[string]*webAuthnChallenge
+	now        func() time.Time
+}
+
+// NewWebAuthn creates a new [WebAuthn] for the given relying party identifier
+// and origins. If no store is given, credentials are kept in memory.
+func NewWebAuthn(rpID string, origins []string, store WebAuthnCredentialStore) *WebAuthn {
+	if store == nil {
+		store = NewMemoryWebAuthnCredentialStore()
+	}
+
+	return &WebAuthn{
+		RPID:       rpID,
+		RPName:     rpID,
+		Origins:    origins,
+		Store:      store,
+		Timeout:    DefaultWebAuthnTimeout,
+		challenges: map[string]*webAuthnChallenge{},
+		now:        time.Now,
+	}
+}
+
+// BeginRegistration starts the registration of a new credential for the user
+// identified by me. The name is a human friendly name for the credential. The
+// returned options must be given to the browser, and its response to
+// [WebAuthn.FinishRegistration].
+func (wa *WebAuthn) BeginRegistration(ctx context.Context, me, name string) (*WebAuthnCreationOptions, error) {
+	credentials, err := wa.Store.List(ctx, me)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := wa.newChallenge(me, webAuthnCreate)
+	if err != nil {
+		return nil, err
+	}
+
+	userID := sha256.Sum256([]byte(me))
+
+	options := &WebAuthnCreationOptions{
+		Challenge:   challenge,
+		Timeout:     wa.timeout().Milliseconds(),
+		Attestation: "none",
+	}
+	options.RP.ID = wa.RPID
+	options.RP.Name = wa.RPName
+	options.User.ID = userID[:]
+	options.User.Name = me
+	options.User.DisplayName = me
+	if name != "" {
+		options.User.DisplayName = name
+	}
+	for _, alg := range WebAuthnAlgorithms {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, struct {
+			Type string `json:"type"`
+			Alg  int    `json:"alg"`
+		}{"public-key", alg})
+	}
+	for _, c := range credentials {
+		options.ExcludeCredentials = append(options.ExcludeCredentials, WebAuthnCredentialDescriptor{
+			Type: "public-key",
+			ID:   c.ID,
+		})
+	}
+	options.AuthenticatorSelection.ResidentKey = "preferred"
+	options.AuthenticatorSelection.UserVerification = wa.userVerification()
+
+	return options, nil
+}
+
+// FinishRegistration verifies the response of the registration ceremony for
+// the user identified by me, and stores the new credential.
+func (wa *WebAuthn) FinishRegistration(ctx context.Context, me, name string, response *WebAuthnRegistrationResponse) (*WebAuthnCredential, error) {
+	if response.Type != "public-key" {
+		return nil, fmt.Errorf("%w: invalid credential type", ErrWebAuthnInvalidClientData)
+	}
+
+	if err := wa.verifyClientData(response.Response.ClientDataJSON, webAuthnCreate, me); err != nil {
+		return nil, err
+	}
+
+	attestation, _, err := decodeCBOR(response.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrWebAuthnInvalidAuthenticator, err)
+	}
+
+	fields, ok := attestation.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: attestation object must be a map", ErrWebAuthnInvalidAuthenticator)
+	}
+
+	if format, _ := fields["fmt"].(string); format != "none" {
+		return nil, fmt.Errorf("%w: %q", ErrWebAuthnUnsupportedAttestation, format)
+	}
+
+	if statement, ok := fields["attStmt"].(map[any]any); !ok || len(statement) != 0 {
+		return nil, fmt.Errorf("%w: attestation statement must be empty", ErrWebAuthnInvalidAuthenticator)
+	}
+
+	rawAuthData, ok := fields["authData"].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing authenticator data", ErrWebAuthnInvalidAuthenticator)
+	}
+
+	authData, err := wa.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	if authData.credentialID == nil {
+		return nil, fmt.Errorf("%w: missing attested credential data", ErrWebAuthnInvalidAuthenticator)
+	}
+
+	if len(response.RawID) != 0 && !bytes.Equal(response.RawID, authData.credentialID) {
+		return nil, fmt.Errorf("%w: credential id mismatch", ErrWebAuthnInvalidAuthenticator)
+	}
+
+	alg, _, err := parseCOSEKey(authData.publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = wa.Store.Get(ctx, authData.credentialID)
+	if err == nil {
+		return nil, ErrWebAuthnCredentialExists
+	} else if !errors.Is(err, ErrWebAuthnCredentialNotFound) {
+		return nil, err
+	}
+
+	credential := &WebAuthnCredential{
+		ID:        authData.credentialID,
+		Me:        me,
+		Name:      name,
+		PublicKey: authData.publicKey,
+		Algorithm: alg,
+		SignCount: authData.signCount,
+		CreatedAt: wa.now(),
+	}
+
+	return credential, wa.Store.Put(ctx, credential)
+}
+
+// BeginLogin starts the authentication of the user identified by me. If me is
+// empty, any credential is allowed, which allows discoverable credentials to
+// be used. The returned options must be given to the browser, and its response
+// to [WebAuthn.FinishLogin].
+func (wa *WebAuthn) BeginLogin(ctx context.Context, me string) (*WebAuthnRequestOptions, error) {
+	options := &WebAuthnRequestOptions{
+		Timeout:          wa.timeout().Milliseconds(),
+		RPID:             wa.RPID,
+		UserVerification: wa.userVerification(),
+	}
+
+	if me != "" {
+		credentials, err := wa.Store.List(ctx, me)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(credentials) == 0 {
+			return nil, ErrWebAuthnCredentialNotFound
+		}
+
+		for _, c := range credentials {
+			options.AllowCredentials = append(options.AllowCredentials, WebAuthnCredentialDescriptor{
+				Type: "public-key",
+				ID:   c.ID,
+			})
+		}
+	}
+
+	challenge, err := wa.newChallenge(me, webAuthnGet)
+	if err != nil {
+		return nil, err
+	}
+
+	options.Challenge = challenge
+	return options, nil
+}
+
+// FinishLogin verifies the response of the authentication ceremony for the user
+// identified by me, which must be the same as given to [WebAuthn.BeginLogin].
+// On success, the credential used is returned: [WebAuthnCredential.Me] is the
+// authenticated user.
+func (wa *WebAuthn) FinishLogin(ctx context.Context, me string, response *WebAuthnAssertionResponse) (*WebAuthnCredential, error) {
+	if response.Type != "public-key" {
+		return nil, fmt.Errorf("%w: invalid credential type", ErrWebAuthnInvalidClientData)
+	}
+
+	if err := wa.verifyClientData(response.Response.ClientDataJSON, webAuthnGet, me); err != nil {
+		return nil, err
+	}
+
+	credential, err := wa.Store.Get(ctx, response.RawID)
+	if err != nil {
+		return nil, err
+	}
+
+	if me != "" && credential.Me != me {
+		return nil, ErrWebAuthnCredentialNotFound
+	}
+
+	if len(response.Response.UserHandle) != 0 {
+		userID := sha256.Sum256([]byte(credential.Me))
+		if !bytes.Equal(response.Response.UserHandle, userID[:]) {
+			return nil, ErrWebAuthnCredentialNotFound
+		}
+	}
+
+	authData, err := wa.parseAuthenticatorData(response.Response.AuthenticatorData)
+	if err != nil {
+		return nil, err
+	}
+
+	_, publicKey, err := parseCOSEKey(credential.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(response.Response.ClientDataJSON)
+	signed := append(slices.Clone(response.Response.AuthenticatorData), clientDataHash[:]...)
+	if !verifyWebAuthnSignature(publicKey, signed, response.Response.Signature) {
+		return nil, ErrWebAuthnInvalidSignature
+	}
+
+	// Authenticators that do not support a signature counter always return
+	// zero. Otherwise, the counter must always increase. If it does not, the
+	// credential may have been cloned.
+	if (authData.signCount != 0 || credential.SignCount != 0) && authData.signCount <= credential.SignCount {
+		return nil, ErrWebAuthnSignCount
+	}
+
+	credential.SignCount = authData.signCount
+	credential.LastUsed = wa.now()
+	return credential, wa.Store.Put(ctx, credential)
+}
+
+func (wa *WebAuthn) timeout() time.Duration {
+	if wa.Timeout == 0 {
+		return DefaultWebAuthnTimeout
+	}
+	return wa.Timeout
+}
+
+func (wa *WebAuthn) userVerification() string {
+	if wa.RequireUserVerification {
+		return "required"
+	}
+	return "preferred"
+}
+
+func (wa *WebAuthn) newChallenge(me, ceremony string) ([]byte, error) {
+	challenge := make([]byte, 32)
+	if _, err := cryptorand.Read(challenge); err != nil {
+		return nil, err
+	}
+
+	wa.mu.Lock()
+	defer wa.mu.Unlock()
+
+	if wa.challenges == nil {
+		wa.challenges = map[string]*webAuthnChallenge{}
+	}
+
+	now := wa.now()
+	for k, c := range wa.challenges {
+		if now.After(c.expiration) {
+			delete(wa.challenges, k)
+		}
+	}
+
+	wa.challenges[string(challenge)] = &webAuthnChallenge{
+		me:         me,
+		ceremony:   ceremony,
+		expiration: now.Add(wa.timeout()),
+	}
+
+	return challenge, nil
+}
+
+// consumeChallenge removes the given challenge and checks that it was issued
+// for the given user and ceremony, and that it has not expired.
+func (wa *WebAuthn) consumeChallenge(challenge []byte, me, ceremony string) error {
+	wa.mu.Lock()
+	defer wa.mu.Unlock()
+
+	c, ok := wa.challenges[string(challenge)]
+	if !ok {
+		return ErrWebAuthnChallenge
+	}
+
+	delete(wa.challenges, string(challenge))
+
+	if c.me != me || c.ceremony != ceremony || wa.now().After(c.expiration) {
+		return ErrWebAuthnChallenge
+	}
+
+	return nil
+}
+
+type webAuthnClientData struct {
+	Type        string         `json:"type"`
+	Challenge   Base64URLBytes `json:"challenge"`
+	Origin      string         `json:"origin"`
+	CrossOrigin bool           `json:"crossOrigin"`
+}
+
+func (wa *WebAuthn) verifyClientData(data []byte, ceremony, me string) error {
+	var clientData webAuthnClientData
+	if err := json.Unmarshal(data, &clientData); err != nil {
+		return fmt.Errorf("%w: %w", ErrWebAuthnInvalidClientData, err)
+	}
+
+	if clientData.Type != ceremony {
+		return fmt.Errorf("%w: unexpected type %q", ErrWebAuthnInvalidClientData, clientData.Type)
+	}
+
+	if !slices.Contains(wa.Origins, clientData.Origin) || clientData.CrossOrigin {
+		return fmt.Errorf("%w: unexpected origin %q", ErrWebAuthnInvalidClientData, clientData.Origin)
+	}
+
+	return wa.consumeChallenge(clientData.Challenge, me, ceremony)
+}
+
+type webAuthnAuthenticatorData struct {
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+func (wa *WebAuthn) parseAuthenticatorData(data []byte) (*webAuthnAuthenticatorData, error) {
+	if len(data) < 37 {
+		return nil, fmt.Errorf("%w: too short", ErrWebAuthnInvalidAuthenticator)
+	}
+
+	rpIDHash := sha256.Sum256([]byte(wa.RPID))
+	if !bytes.Equal(data[:32], rpIDHash[:]) {
+		return nil, fmt.Errorf("%w: relying party id mismatch", ErrWebAuthnInvalidAuthenticator)
+	}
+
+	authData := &webAuthnAuthenticatorData{
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+
+	if authData.flags&webAuthnFlagUserPresent == 0 {
+		return nil, fmt.Errorf("%w: user not present", ErrWebAuthnInvalidAuthenticator)
+	}
+
+	if wa.RequireUserVerification && authData.flags&webAuthnFlagUserVerified == 0 {
+		return nil, fmt.Errorf("%w: user not verified", ErrWebAuthnInvalidAuthenticator)
+	}
+
+	rest := data[37:]
+
+	if authData.flags&webAuthnFlagAttested != 0 {
+		// AAGUID (16 bytes) followed by the credential ID length (2 bytes).
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("%w: attested credential data too short", ErrWebAuthnInvalidAuthenticator)
+		}
+
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < idLength {
+			return nil, fmt.Errorf("%w: attested credential data too short", ErrWebAuthnInvalidAuthenticator)
+		}
+
+		authData.credentialID = slices.Clone(rest[:idLength])
+		rest = rest[idLength:]
+
+		_, remaining, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrWebAuthnInvalidAuthenticator, err)
+		}
+
+		authData.publicKey = slices.Clone(rest[:len(rest)-len(remaining)])
+		rest = remaining
+	}
+
+	if authData.flags&webAuthnFlagExtensions != 0 {
+		_, remaining, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrWebAuthnInvalidAuthenticator, err)
+		}
+		rest = remaining
+	}
+
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing data", ErrWebAuthnInvalidAuthenticator)
+	}
+
+	return authData, nil
+}
+
+// parseCOSEKey parses a COSE encoded public key, returning its algorithm and
+// the corresponding Go public key.
+func parseCOSEKey(data []byte) (int, crypto.PublicKey, error) {
+	decoded, _, err := decodeCBOR(data)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %w", ErrWebAuthnUnsupportedKey, err)
+	}
+
+	fields, ok := decoded.(map[any]any)
+	if !ok {
+		return 0, nil, ErrWebAuthnUnsupportedKey
+	}
+
+	kty, _ := fields[int64(1)].(int64)
+	alg, _ := fields[int64(3)].(int64)
+
+	switch {
+	case kty == 2 && alg == coseAlgES256:
+		crv, _ := fields[int64(-1)].(int64)
+		x, _ := fields[int64(-2)].([]byte)
+		y, _ := fields[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return 0, nil, ErrWebAuthnUnsupportedKey
+		}
+
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return 0, nil, fmt.Errorf("%w: %w", ErrWebAuthnUnsupportedKey, err)
+		}
+		return coseAlgES256, key, nil
+	case kty == 1 && alg == coseAlgEdDSA:
+		crv, _ := fields[int64(-1)].(int64)
+		x, _ := fields[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return 0, nil, ErrWebAuthnUnsupportedKey
+		}
+		return coseAlgEdDSA, ed25519.PublicKey(x), nil
+	case kty == 3 && alg == coseAlgRS256:
+		n, _ := fields[int64(-1)].([]byte)
+		e, _ := fields[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return 0, nil, ErrWebAuthnUnsupportedKey
+		}
+		return coseAlgRS256, &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	default:
+		return 0, nil, ErrWebAuthnUnsupportedKey
+	}
+}
+
+func verifyWebAuthnSignature(publicKey crypto.PublicKey, data, signature []byte) bool {
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		hash := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(key, hash[:], signature)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, signature)
+	case *rsa.PublicKey:
+		hash := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) == nil
+	default:
+		return false
+	}
+}
@@ -0,0 +1,336 @@
+package indieauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type cborPair struct {
+	key   any
+	value any
+}
+
+// encodeCBOR is a minimal CBOR encoder used to build authenticator responses.
+// Maps are given as []cborPair to keep a deterministic order.
+func encodeCBOR(v any) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n <= 0xff:
+			return []byte{major<<5 | 24, byte(n)}
+		case n <= 0xffff:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		default:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+		}
+	}
+
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case []cborPair:
+		out := head(5, uint64(len(v)))
+		for _, p := range v {
+			out = append(out, encodeCBOR(p.key)...)
+			out = append(out, encodeCBOR(p.value)...)
+		}
+		return out
+	default:
+		panic("unsupported type")
+	}
+}
+
+// softwareAuthenticator is a WebAuthn authenticator with a P-256 or Ed25519
+// key, which behaves like a browser and an authenticator combined.
+type softwareAuthenticator struct {
+	origin    string
+	id        []byte
+	ecdsa     *ecdsa.PrivateKey
+	ed25519   ed25519.PrivateKey
+	signCount uint32
+	flags     byte
+}
+
+func newSoftwareAuthenticator(t *testing.T, origin string, edwards bool) *softwareAuthenticator {
+	a := &softwareAuthenticator{
+		origin: origin,
+		id:     make([]byte, 16),
+		flags:  webAuthnFlagUserPresent | webAuthnFlagUserVerified,
+	}
+	_, _ = cryptorand.Read(a.id)
+
+	var err error
+	if edwards {
+		_, a.ed25519, err = ed25519.GenerateKey(cryptorand.Reader)
+	} else {
+		a.ecdsa, err = ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	}
+	require.NoError(t, err)
+
+	return a
+}
+
+func (a *softwareAuthenticator) coseKey() []byte {
+	if a.ed25519 != nil {
+		return encodeCBOR([]cborPair{
+			{1, 1},
+			{3, coseAlgEdDSA},
+			{-1, 6},
+			{-2, []byte(a.ed25519.Public().(ed25519.PublicKey))},
+		})
+	}
+
+	pub, _ := a.ecdsa.PublicKey.Bytes()
+	return encodeCBOR([]cborPair{
+		{1, 2},
+		{3, coseAlgES256},
+		{-1, 1},
+		{-2, pub[1:33]},
+		{-3, pub[33:]},
+	})
+}
+
+func (a *softwareAuthenticator) clientData(typ string, challenge []byte) []byte {
+	data, _ := json.Marshal(map[string]any{
+		"type":      typ,
+		"challenge": Base64URLBytes(challenge),
+		"origin":    a.origin,
+	})
+	return data
+}
+
+func (a *softwareAuthenticator) authData(rpID string, flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, a.signCount)
+}
+
+// create returns the JSON serialization of the registration response, as sent
+// by the browser.
+func (a *softwareAuthenticator) create(t *testing.T, options *WebAuthnCreationOptions) []byte {
+	authData := a.authData(options.RP.ID, a.flags|webAuthnFlagAttested)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.id)))
+	authData = append(authData, a.id...)
+	authData = append(authData, a.coseKey()...)
+
+	response := &WebAuthnRegistrationResponse{
+		ID:    "",
+		RawID: a.id,
+		Type:  "public-key",
+	}
+	response.Response.ClientDataJSON = a.clientData(webAuthnCreate, options.Challenge)
+	response.Response.AttestationObject = encodeCBOR([]cborPair{
+		{"fmt", "none"},
+		{"attStmt", []cborPair{}},
+		{"authData", authData},
+	})
+
+	data, err := json.Marshal(response)
+	require.NoError(t, err)
+	return data
+}
+
+// get returns the JSON serialization of the assertion response, as sent by
+// the browser.
+func (a *softwareAuthenticator) get(t *testing.T, options *WebAuthnRequestOptions) []byte {
+	a.signCount++
+
+	authData := a.authData(options.RPID, a.flags)
+	clientData := a.clientData(webAuthnGet, options.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	signed := append(authData, clientDataHash[:]...)
+
+	var signature []byte
+	if a.ed25519 != nil {
+		signature = ed25519.Sign(a.ed25519, signed)
+	} else {
+		hash := sha256.Sum256(signed)
+		var err error
+		signature, err = ecdsa.SignASN1(cryptorand.Reader, a.ecdsa, hash[:])
+		require.NoError(t, err)
+	}
+
+	response := &WebAuthnAssertionResponse{
+		RawID: a.id,
+		Type:  "public-key",
+	}
+	response.Response.ClientDataJSON = clientData
+	response.Response.AuthenticatorData = authData
+	response.Response.Signature = signature
+
+	data, err := json.Marshal(response)
+	require.NoError(t, err)
+	return data
+}
+
+func TestWebAuthn(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	me := "https://example.org/"
+
+	register := func(t *testing.T, wa *WebAuthn, a *softwareAuthenticator) *WebAuthnCredential {
+		options, err := wa.BeginRegistration(ctx, me, "Security Key")
+		require.NoError(t, err)
+
+		var response *WebAuthnRegistrationResponse
+		require.NoError(t, json.Unmarshal(a.create(t, options), &response))
+
+		credential, err := wa.FinishRegistration(ctx, me, "Security Key", response)
+		require.NoError(t, err)
+		return credential
+	}
+
+	login := func(t *testing.T, wa *WebAuthn, a *softwareAuthenticator, me string) (*WebAuthnCredential, error) {
+		options, err := wa.BeginLogin(ctx, me)
+		require.NoError(t, err)
+
+		var response *WebAuthnAssertionResponse
+		require.NoError(t, json.Unmarshal(a.get(t, options), &response))
+
+		return wa.FinishLogin(ctx, me, response)
+	}
+
+	for _, edwards := range []bool{false, true} {
+		t.Run(map[bool]string{false: "ES256", true: "EdDSA"}[edwards], func(t *testing.T) {
+			t.Parallel()
+
+			wa := NewWebAuthn("example.org", []string{"https://example.org"}, nil)
+			a := newSoftwareAuthenticator(t, "https://example.org", edwards)
+
+			credential := register(t, wa, a)
+			assert.Equal(t, a.id, credential.ID)
+			assert.Equal(t, me, credential.Me)
+			assert.Equal(t, "Security Key", credential.Name)
+
+			options, err := wa.BeginRegistration(ctx, me, "")
+			require.NoError(t, err)
+			require.Len(t, options.ExcludeCredentials, 1)
+			assert.Equal(t, Base64URLBytes(a.id), options.ExcludeCredentials[0].ID)
+
+			var response *WebAuthnRegistrationResponse
+			require.NoError(t, json.Unmarshal(a.create(t, options), &response))
+			_, err = wa.FinishRegistration(ctx, me, "", response)
+			assert.ErrorIs(t, err, ErrWebAuthnCredentialExists)
+
+			credential, err = login(t, wa, a, me)
+			require.NoError(t, err)
+			assert.Equal(t, me, credential.Me)
+			assert.EqualValues(t, 1, credential.SignCount)
+
+			credential, err = login(t, wa, a, "")
+			require.NoError(t, err)
+			assert.Equal(t, me, credential.Me)
+			assert.EqualValues(t, 2, credential.SignCount)
+		})
+	}
+
+	t.Run("Invalid Assertions", func(t *testing.T) {
+		t.Parallel()
+
+		wa := NewWebAuthn("example.org", []string{"https://example.org"}, nil)
+		a := newSoftwareAuthenticator(t, "https://example.org", false)
+		register(t, wa, a)
+
+		// Replayed challenge.
+		options, err := wa.BeginLogin(ctx, me)
+		require.NoError(t, err)
+		data := a.get(t, options)
+		var response *WebAuthnAssertionResponse
+		require.NoError(t, json.Unmarshal(data, &response))
+		_, err = wa.FinishLogin(ctx, me, response)
+		require.NoError(t, err)
+		_, err = wa.FinishLogin(ctx, me, response)
+		assert.ErrorIs(t, err, ErrWebAuthnChallenge)
+
+		// Tampered signature.
+		options, err = wa.BeginLogin(ctx, me)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(a.get(t, options), &response))
+		response.Response.Signature[len(response.Response.Signature)-1] ^= 0xff
+		_, err = wa.FinishLogin(ctx, me, response)
+		assert.ErrorIs(t, err, ErrWebAuthnInvalidSignature)
+
+		// Non-increasing signature counter.
+		a.signCount = 0
+		_, err = login(t, wa, a, me)
+		assert.ErrorIs(t, err, ErrWebAuthnSignCount)
+		a.signCount = 10
+
+		// User not present.
+		a.flags = 0
+		_, err = login(t, wa, a, me)
+		assert.ErrorIs(t, err, ErrWebAuthnInvalidAuthenticator)
+		a.flags = webAuthnFlagUserPresent
+
+		// User verification required.
+		wa.RequireUserVerification = true
+		_, err = login(t, wa, a, me)
+		assert.ErrorIs(t, err, ErrWebAuthnInvalidAuthenticator)
+		wa.RequireUserVerification = false
+
+		// Wrong origin.
+		a.origin = "https://evil.com"
+		_, err = login(t, wa, a, me)
+		assert.ErrorIs(t, err, ErrWebAuthnInvalidClientData)
+		a.origin = "https://example.org"
+
+		// Credential of another user.
+		_, err = wa.BeginLogin(ctx, "https://example.net/")
+		assert.ErrorIs(t, err, ErrWebAuthnCredentialNotFound)
+		options, err = wa.BeginLogin(ctx, "")
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(a.get(t, options), &response))
+		_, err = wa.FinishLogin(ctx, "https://example.net/", response)
+		assert.ErrorIs(t, err, ErrWebAuthnChallenge)
+
+		// Expired challenge.
+		options, err = wa.BeginLogin(ctx, me)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(a.get(t, options), &response))
+		wa.now = func() time.Time { return time.Now().Add(DefaultWebAuthnTimeout * 2) }
+		_, err = wa.FinishLogin(ctx, me, response)
+		assert.ErrorIs(t, err, ErrWebAuthnChallenge)
+	})
+
+	t.Run("Unsupported Attestation", func(t *testing.T) {
+		t.Parallel()
+
+		wa := NewWebAuthn("example.org", []string{"https://example.org"}, nil)
+		a := newSoftwareAuthenticator(t, "https://example.org", false)
+
+		options, err := wa.BeginRegistration(ctx, me, "")
+		require.NoError(t, err)
+
+		var response *WebAuthnRegistrationResponse
+		require.NoError(t, json.Unmarshal(a.create(t, options), &response))
+		response.Response.AttestationObject = encodeCBOR([]cborPair{
+			{"fmt", "packed"},
+			{"attStmt", []cborPair{}},
+			{"authData", []byte{}},
+		})
+
+		_, err = wa.FinishRegistration(ctx, me, "", response)
+		assert.ErrorIs(t, err, ErrWebAuthnUnsupportedAttestation)
+	})
+}
@@ -0,0 +1,471 @@
+package micropub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.hacdias.com/indielib/indieauth"
+)
+
+const (
+	// MicropubRel is the rel of the link to the Micropub endpoint.
+	MicropubRel string = "micropub"
+
+	// MediaEndpointRel is the rel of the link to the media endpoint.
+	MediaEndpointRel string = "media-endpoint"
+)
+
+var (
+	ErrNoEndpoint         = errors.New("micropub endpoint is not set")
+	ErrNoMediaEndpoint    = errors.New("media endpoint is not set")
+	ErrNoLocation         = errors.New("response does not contain a location")
+	ErrUnsupportedForm    = errors.New("value cannot be form-encoded")
+	ErrUnexpectedResponse = errors.New("unexpected response")
+)
+
+// Config is the configuration of a Micropub endpoint, as returned by the
+// [configuration query].
+//
+// [configuration query]: https://micropub.spec.indieweb.org/#configuration
+type Config struct {
+	MediaEndpoint string        `json:"media-endpoint,omitempty"`
+	SyndicateTo   []Syndication `json:"syndicate-to,omitempty"`
+	Channels      []Channel     `json:"channels,omitempty"`
+	Categories    []string      `json:"categories,omitempty"`
+	PostStatus    []string      `json:"post-status,omitempty"`
+	PostTypes     []PostType    `json:"post-types,omitempty"`
+	Visibility    []string      `json:"visibility,omitempty"`
+	Q             []string      `json:"q,omitempty"`
+}
+
+// Client is a Micropub client. The [http.Client] must authenticate the requests,
+// for example, by using [indieauth.Client.HTTPClient]. Alternatively, set
+// [Client.AccessToken].
+type Client struct {
+	Client *http.Client
+
+	// Endpoint is the URL of the Micropub endpoint. See [Client.Discover].
+	Endpoint string
+
+	// MediaEndpoint is the URL of the media endpoint. See [Client.Discover].
+	MediaEndpoint string
+
+	// AccessToken, if set, is sent as a bearer token with each request.
+	AccessToken string
+}
+
+// NewClient creates a new [Client] for the given Micropub endpoint. If no
+// httpClient is given, [http.DefaultClient] will be used.
+func NewClient(endpoint string, httpClient *http.Client) *Client {
+	c := &Client{
+		Endpoint: endpoint,
+	}
+
+	if httpClient != nil {
+		c.Client = httpClient
+	} else {
+		c.Client = http.DefaultClient
+	}
+
+	return c
+}
+
+// Discover discovers the Micropub and media endpoints from the links of the
+// given profile URL, as per the [specification]. If the profile does not link
+// to a media endpoint, it is taken from the endpoint configuration, if possible.
+//
+// [specification]: https://micropub.spec.indieweb.org/#endpoint-discovery
+func (c *Client) Discover(ctx context.Context, profile string) error {
+	discovery := indieauth.NewClient("", "", c.Client)
+
+	endpoint, err := discovery.DiscoverLinkEndpoint(ctx, profile, MicropubRel)
+	if err != nil {
+		return err
+	}
+	c.Endpoint = endpoint
+
+	if mediaEndpoint, err := discovery.DiscoverLinkEndpoint(ctx, profile, MediaEndpointRel); err == nil {
+		c.MediaEndpoint = mediaEndpoint
+		return nil
+	}
+
+	// Do a best effort attempt at fetching the media endpoint from the configuration.
+	if config, err := c.Config(ctx); err == nil {
+		c.MediaEndpoint = config.MediaEndpoint
+	}
+
+	return nil
+}
+
+// Config queries the configuration of the Micropub endpoint.
+func (c *Client) Config(ctx context.Context) (*Config, error) {
+	config := &Config{}
+	if err := c.query(ctx, url.Values{"q": {"config"}}, config); err != nil {
+		return nil, err
+	}
+
+	if config.MediaEndpoint != "" {
+		mediaEndpoint, err := c.resolve(config.MediaEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		config.MediaEndpoint = mediaEndpoint
+	}
+
+	return config, nil
+}
+
+// SyndicateTo queries the syndication targets of the Micropub endpoint.
+func (c *Client) SyndicateTo(ctx context.Context) ([]Syndication, error) {
+	var res struct {
+		SyndicateTo []Syndication `json:"syndicate-to"`
+	}
+	if err := c.query(ctx, url.Values{"q": {"syndicate-to"}}, &res); err != nil {
+		return nil, err
+	}
+
+	return res.SyndicateTo, nil
+}
+
+// Source queries the Microformats source of the post at the given URL. If
+// properties are given, only those are requested.
+func (c *Client) Source(ctx context.Context, postURL string, properties ...string) (map[string]any, error) {
+	query := url.Values{"q": {"source"}, "url": {postURL}}
+	for _, property := range properties {
+		query.Add("properties[]", property)
+	}
+
+	source := map[string]any{}
+	if err := c.query(ctx, query, &source); err != nil {
+		return nil, err
+	}
+
+	return source, nil
+}
+
+// SourceList queries the Microformats source of the posts that match the given
+// query, as per the [post list] extension.
+//
+// [post list]: https://indieweb.org/Micropub-extensions#Query_for_Post_List
+func (c *Client) SourceList(ctx context.Context, query *SourceQuery) (*SourceList, error) {
+	list := &SourceList{}
+	if err := c.query(ctx, query.values(), list); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// Create creates a post from the given [Request] using the JSON syntax. Returns
+// the location of the created post.
+func (c *Client) Create(ctx context.Context, req *Request) (string, error) {
+	properties := map[string][]any{}
+	for key, value := range req.Properties {
+		properties[key] = value
+	}
+	for key, value := range req.Commands {
+		properties["mp-"+key] = value
+	}
+
+	body, err := json.Marshal(&requestJSON{
+		Type:       []string{requestType(req)},
+		Properties: properties,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return c.post(ctx, "application/json", bytes.NewReader(body))
+}
+
+// CreateForm creates a post from the given [Request] using the form-encoded
+// syntax. Only strings, numbers and booleans can be form-encoded. Otherwise,
+// [ErrUnsupportedForm] is returned. Returns the location of the created post.
+func (c *Client) CreateForm(ctx context.Context, req *Request) (string, error) {
+	values := url.Values{}
+	values.Set("h", strings.TrimPrefix(requestType(req), "h-"))
+
+	for prefix, fields := range map[string]map[string][]any{"": req.Properties, "mp-": req.Commands} {
+		for key, value := range fields {
+			name := prefix + key
+			if len(value) > 1 {
+				name += "[]"
+			}
+
+			for _, v := range value {
+				s, err := formValue(v)
+				if err != nil {
+					return "", fmt.Errorf("%w: %s", err, key)
+				}
+				values.Add(name, s)
+			}
+		}
+	}
+
+	return c.post(ctx, "application/x-www-form-urlencoded", strings.NewReader(values.Encode()))
+}
+
+// Update updates the post at [Request.URL] with the replacements, additions and
+// deletions in [Request.Updates]. Returns the location of the updated post,
+// which is [Request.URL] unless the server has changed it.
+func (c *Client) Update(ctx context.Context, req *Request) (string, error) {
+	update := &requestJSON{
+		Action:  ActionUpdate,
+		URL:     req.URL,
+		Replace: req.Updates.Replace,
+		Add:     req.Updates.Add,
+	}
+	if !req.Updates.Delete.IsZero() {
+		update.Delete = &req.Updates.Delete
+	}
+
+	body, err := json.Marshal(update)
+	if err != nil {
+		return "", err
+	}
+
+	if len(req.Commands) != 0 {
+		fields := map[string]any{}
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return "", err
+		}
+		for key, value := range req.Commands {
+			fields["mp-"+key] = value
+		}
+		if body, err = json.Marshal(fields); err != nil {
+			return "", err
+		}
+	}
+
+	location, err := c.post(ctx, "application/json", bytes.NewReader(body))
+	if errors.Is(err, ErrNoLocation) {
+		return req.URL, nil
+	}
+
+	return location, err
+}
+
+// Delete deletes the post at the given URL.
+func (c *Client) Delete(ctx context.Context, postURL string) error {
+	return c.action(ctx, ActionDelete, postURL)
+}
+
+// Undelete restores the deleted post at the given URL.
+func (c *Client) Undelete(ctx context.Context, postURL string) error {
+	return c.action(ctx, ActionUndelete, postURL)
+}
+
+// Upload uploads a file with the given name to the media endpoint. Returns the
+// location of the uploaded file.
+func (c *Client) Upload(ctx context.Context, filename string, file io.Reader) (string, error) {
+	if c.MediaEndpoint == "" {
+		return "", ErrNoMediaEndpoint
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(filename))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{
+		"name":     "file",
+		"filename": filepath.Base(filename),
+	}))
+	header.Set("Content-Type", contentType)
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return "", err
+	}
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.MediaEndpoint, body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	return c.location(req)
+}
+
+func (c *Client) action(ctx context.Context, action Action, postURL string) error {
+	values := url.Values{
+		"action": {string(action)},
+		"url":    {postURL},
+	}
+
+	_, err := c.post(ctx, "application/x-www-form-urlencoded", strings.NewReader(values.Encode()))
+	if errors.Is(err, ErrNoLocation) {
+		return nil
+	}
+	return err
+}
+
+func (c *Client) query(ctx context.Context, query url.Values, v any) error {
+	if c.Endpoint == "" {
+		return ErrNoEndpoint
+	}
+
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	// Keep the parameters that may already be part of the endpoint.
+	values := u.Query()
+	for key, value := range query {
+		values[key] = value
+	}
+	u.RawQuery = values.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("%w: %w", ErrUnexpectedResponse, err)
+	}
+
+	return nil
+}
+
+func (c *Client) post(ctx context.Context, contentType string, body io.Reader) (string, error) {
+	if c.Endpoint == "" {
+		return "", ErrNoEndpoint
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Endpoint, body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	return c.location(req)
+}
+
+// location makes the request and returns the resolved Location header of the
+// response, or [ErrNoLocation] if there is none.
+func (c *Client) location(req *http.Request) (string, error) {
+	res, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	_ = res.Body.Close()
+
+	location := res.Header.Get("Location")
+	if location == "" {
+		return "", ErrNoLocation
+	}
+
+	u, err := res.Request.URL.Parse(location)
+	if err != nil {
+		return "", err
+	}
+
+	return u.String(), nil
+}
+
+// do makes the request and returns an [*Error] if the response status code is
+// not successful.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.AccessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.AccessToken)
+	}
+
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Error{}
+	if err := json.Unmarshal(data, e); err != nil || e.Code == "" {
+		e = &Error{Description: strings.TrimSpace(string(data))}
+	}
+	e.StatusCode = res.StatusCode
+	return nil, e
+}
+
+// resolve resolves the given reference against the Micropub endpoint.
+func (c *Client) resolve(ref string) (string, error) {
+	base, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := base.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+
+	return u.String(), nil
+}
+
+func requestType(req *Request) string {
+	if req.Type == "" {
+		return "h-entry"
+	}
+	return req.Type
+}
+
+func formValue(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case json.Number:
+		return v.String(), nil
+	default:
+		return "", ErrUnsupportedForm
+	}
+}
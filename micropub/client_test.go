@@ -0,0 +1,173 @@
+package micropub
+
+import (
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	newServer := func(t *testing.T, impl Implementation) *httptest.Server {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Link", `</micropub>; rel="micropub"`)
+			w.WriteHeader(http.StatusOK)
+		})
+		mux.Handle("/micropub", NewHandler(impl,
+			WithMediaEndpoint("/media"),
+			WithGetSyndicateTo(func() []Syndication {
+				return []Syndication{{UID: "https://social.example.com/", Name: "Social"}}
+			}),
+		))
+		mux.Handle("/media", NewMediaHandler(func(file multipart.File, header *multipart.FileHeader) (string, error) {
+			data, err := io.ReadAll(file)
+			require.NoError(t, err)
+			assert.Equal(t, "hello", string(data))
+			assert.Equal(t, "hello.txt", header.Filename)
+			assert.Equal(t, "text/plain; charset=utf-8", header.Header.Get("Content-Type"))
+			return "https://example.com/media/hello.txt", nil
+		}, func(r *http.Request, scope string) bool {
+			return r.Header.Get("Authorization") == "Bearer token"
+		}))
+
+		ts := httptest.NewServer(mux)
+		t.Cleanup(ts.Close)
+		return ts
+	}
+
+	t.Run("Discovery and Queries", func(t *testing.T) {
+		t.Parallel()
+
+		impl := &mockRouterImplementation{}
+		impl.Mock.On("Source", "https://example.com/1").Return(map[string]any{"type": []any{"h-entry"}}, nil)
+		impl.Mock.On("Source", "https://example.com/2").Return(map[string]any{}, ErrNotFound)
+
+		ts := newServer(t, impl)
+		c := NewClient("", ts.Client())
+		c.AccessToken = "token"
+
+		require.NoError(t, c.Discover(context.Background(), ts.URL+"/"))
+		assert.Equal(t, ts.URL+"/micropub", c.Endpoint)
+		assert.Equal(t, ts.URL+"/media", c.MediaEndpoint)
+
+		syndicateTo, err := c.SyndicateTo(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []Syndication{{UID: "https://social.example.com/", Name: "Social"}}, syndicateTo)
+
+		source, err := c.Source(context.Background(), "https://example.com/1")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"type": []any{"h-entry"}}, source)
+
+		_, err = c.Source(context.Background(), "https://example.com/2")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		var e *Error
+		require.ErrorAs(t, err, &e)
+		assert.Equal(t, http.StatusNotFound, e.StatusCode)
+		assert.Equal(t, "invalid_request", e.Code)
+
+		location, err := c.Upload(context.Background(), "hello.txt", strings.NewReader("hello"))
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/media/hello.txt", location)
+	})
+
+	t.Run("Actions", func(t *testing.T) {
+		t.Parallel()
+
+		impl := &mockRouterImplementation{}
+		impl.Mock.On("HasScope", mock.Anything, mock.Anything).Return(true)
+		impl.Mock.On("Create", &Request{
+			Action:     ActionCreate,
+			Type:       "h-entry",
+			Properties: map[string][]any{"content": {"hello"}, "category": {"a", "b"}},
+			Commands:   map[string][]any{"slug": {"hello"}},
+		}).Return("https://example.com/1", nil)
+		impl.Mock.On("Update", &Request{
+			Action:   ActionUpdate,
+			URL:      "https://example.com/1",
+			Commands: map[string][]any{},
+			Updates: RequestUpdate{
+				Replace: map[string][]any{"content": {"bye"}},
+				Delete:  RequestDelete{Properties: []string{"category"}},
+			},
+		}).Return("https://example.com/1", nil)
+		impl.Mock.On("Delete", "https://example.com/1").Return(nil)
+		impl.Mock.On("Undelete", "https://example.com/1").Return(nil)
+
+		ts := newServer(t, impl)
+		c := NewClient(ts.URL+"/micropub", ts.Client())
+
+		req := &Request{
+			Properties: map[string][]any{"content": {"hello"}, "category": {"a", "b"}},
+			Commands:   map[string][]any{"slug": {"hello"}},
+		}
+
+		location, err := c.Create(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/1", location)
+
+		location, err = c.CreateForm(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/1", location)
+
+		_, err = c.CreateForm(context.Background(), &Request{
+			Properties: map[string][]any{"location": {map[string]any{"type": []any{"h-geo"}}}},
+		})
+		assert.ErrorIs(t, err, ErrUnsupportedForm)
+
+		location, err = c.Update(context.Background(), &Request{
+			URL: "https://example.com/1",
+			Updates: RequestUpdate{
+				Replace: map[string][]any{"content": {"bye"}},
+				Delete:  RequestDelete{Properties: []string{"category"}},
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/1", location)
+
+		require.NoError(t, c.Delete(context.Background(), "https://example.com/1"))
+		require.NoError(t, c.Undelete(context.Background(), "https://example.com/1"))
+
+		_, err = c.Upload(context.Background(), "hello.txt", strings.NewReader("hello"))
+		assert.ErrorIs(t, err, ErrNoMediaEndpoint)
+
+		impl.AssertExpectations(t)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		t.Parallel()
+
+		impl := &mockRouterImplementation{}
+		impl.Mock.On("HasScope", mock.Anything, "delete").Return(false)
+
+		ts := newServer(t, impl)
+		c := NewClient(ts.URL+"/micropub", ts.Client())
+
+		err := c.Delete(context.Background(), "https://example.com/1")
+		var e *Error
+		require.ErrorAs(t, err, &e)
+		assert.Equal(t, &Error{
+			StatusCode:  http.StatusForbidden,
+			Code:        "insufficient_scope",
+			Description: "Insufficient scope.",
+		}, e)
+
+		c.MediaEndpoint = ts.URL + "/media"
+		_, err = c.Upload(context.Background(), "hello.txt", strings.NewReader("hello"))
+		require.ErrorAs(t, err, &e)
+		assert.Equal(t, "insufficient_scope", e.Code)
+
+		_, err = NewClient("", nil).Config(context.Background())
+		assert.ErrorIs(t, err, ErrNoEndpoint)
+	})
+}
@@ -0,0 +1,147 @@
+package micropub
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var ErrInvalidCommand = errors.New("invalid command")
+
+// RequestCommands are the well-known commands of a [Request]. Commands are
+// given with the "mp-" prefix, e.g., "mp-slug". Post status and visibility
+// are also read from the "post-status" and "visibility" properties, as per the
+// [Micropub extensions].
+//
+// [Micropub extensions]: https://indieweb.org/Micropub-extensions
+type RequestCommands struct {
+	Slug        string
+	SyndicateTo []string
+	Channel     string
+	PostStatus  string
+	Visibility  string
+	Destination string
+
+	// PhotoAlt are the alternative texts of the photos, in the same order as
+	// the "photo" property.
+	PhotoAlt []string
+}
+
+// ParseCommands parses the well-known commands of the request. Single-valued
+// commands with more than one value, as well as non-string values, return
+// [ErrInvalidCommand]. Commands are parsed in a fixed order, such that the same
+// error is returned when several commands are invalid.
+func (r *Request) ParseCommands() (*RequestCommands, error) {
+	var (
+		commands = &RequestCommands{}
+		err      error
+	)
+
+	for _, command := range []struct {
+		name   string
+		target *string
+	}{
+		{"slug", &commands.Slug},
+		{"channel", &commands.Channel},
+		{"destination", &commands.Destination},
+	} {
+		if *command.target, err = commandValue("mp-"+command.name, r.Commands[command.name]); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, command := range []struct {
+		name   string
+		target *string
+	}{
+		{"post-status", &commands.PostStatus},
+		{"visibility", &commands.Visibility},
+	} {
+		name, values := "mp-"+command.name, r.Commands[command.name]
+		if _, ok := r.Commands[command.name]; !ok {
+			name, values = command.name, r.Properties[command.name]
+		}
+		if *command.target, err = commandValue(name, values); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, command := range []struct {
+		name   string
+		target *[]string
+	}{
+		{"syndicate-to", &commands.SyndicateTo},
+		{"photo-alt", &commands.PhotoAlt},
+	} {
+		if *command.target, err = commandValues("mp-"+command.name, r.Commands[command.name]); err != nil {
+			return nil, err
+		}
+	}
+
+	return commands, nil
+}
+
+// commandValue returns the single value of a command. The name is the key used
+// by the client, which is used in the errors.
+func commandValue(name string, values []any) (string, error) {
+	if len(values) > 1 {
+		return "", fmt.Errorf("%w: %s must have a single value", ErrInvalidCommand, name)
+	}
+
+	strs, err := commandValues(name, values)
+	if err != nil || len(strs) == 0 {
+		return "", err
+	}
+
+	return strs[0], nil
+}
+
+func commandValues(name string, values []any) ([]string, error) {
+	var strs []string
+	for _, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s must be a string", ErrInvalidCommand, name)
+		}
+		strs = append(strs, str)
+	}
+	return strs, nil
+}
+
+// validateCommands parses the commands of the request and validates them against
+// the configuration: syndication targets, channels, post statuses and visibility
+// must be advertised, if any are advertised.
+func (conf *Configuration) validateCommands(r *Request) error {
+	commands, err := r.ParseCommands()
+	if err != nil {
+		return err
+	}
+
+	if targets := conf.GetSyndicateTo(); len(targets) != 0 {
+		for _, uid := range commands.SyndicateTo {
+			if !slices.ContainsFunc(targets, func(s Syndication) bool { return s.UID == uid }) {
+				return fmt.Errorf("%w: %q is not a syndication target", ErrInvalidCommand, uid)
+			}
+		}
+	}
+
+	if commands.Channel != "" {
+		if channels := conf.GetChannels(); len(channels) != 0 && !slices.ContainsFunc(channels, func(c Channel) bool { return c.UID == commands.Channel }) {
+			return fmt.Errorf("%w: %q is not a channel", ErrInvalidCommand, commands.Channel)
+		}
+	}
+
+	if commands.PostStatus != "" {
+		if statuses := conf.GetPostStatuses(); len(statuses) != 0 && !slices.Contains(statuses, commands.PostStatus) {
+			return fmt.Errorf("%w: %q is not a post status", ErrInvalidCommand, commands.PostStatus)
+		}
+	}
+
+	if commands.Visibility != "" {
+		if visibility := conf.GetVisibility(); len(visibility) != 0 && !slices.Contains(visibility, commands.Visibility) {
+			return fmt.Errorf("%w: %q is not a visibility", ErrInvalidCommand, commands.Visibility)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package micropub
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCommands(t *testing.T) {
+	t.Parallel()
+
+	for _, testCase := range []struct {
+		body        string
+		contentType string
+	}{
+		{
+			"h=entry&content=hello&mp-slug=hello&mp-syndicate-to[]=https://a.example.com/&mp-syndicate-to[]=https://b.example.com/&mp-channel=notes&post-status=draft&visibility=unlisted&mp-destination=https://blog.example.com/&photo=https://example.com/a.jpg&mp-photo-alt=A+cat",
+			"application/x-www-form-urlencoded",
+		},
+		{
+			`{"type":["h-entry"],"properties":{"content":["hello"],"mp-slug":["hello"],"mp-syndicate-to":["https://a.example.com/","https://b.example.com/"],"mp-channel":["notes"],"post-status":["draft"],"visibility":["unlisted"],"mp-destination":["https://blog.example.com/"],"photo":["https://example.com/a.jpg"],"mp-photo-alt":["A cat"]}}`,
+			"application/json",
+		},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/micropub", bytes.NewReader([]byte(testCase.body)))
+		r.Header.Set("Content-Type", testCase.contentType)
+		req, err := ParseRequest(r)
+		require.NoError(t, err)
+
+		commands, err := req.ParseCommands()
+		require.NoError(t, err)
+		assert.Equal(t, &RequestCommands{
+			Slug:        "hello",
+			SyndicateTo: []string{"https://a.example.com/", "https://b.example.com/"},
+			Channel:     "notes",
+			PostStatus:  "draft",
+			Visibility:  "unlisted",
+			Destination: "https://blog.example.com/",
+			PhotoAlt:    []string{"A cat"},
+		}, commands)
+	}
+
+	for _, commands := range []map[string][]any{
+		{"slug": {"a", "b"}},
+		{"channel": {1}},
+		{"syndicate-to": {map[string]any{"uid": "https://a.example.com/"}}},
+	} {
+		_, err := (&Request{Commands: commands}).ParseCommands()
+		assert.ErrorIs(t, err, ErrInvalidCommand)
+	}
+
+	// The first invalid command is always reported, under the name given by the
+	// client.
+	for range 10 {
+		_, err := (&Request{
+			Commands:   map[string][]any{"slug": {"a", "b"}, "channel": {1}, "destination": {1}, "syndicate-to": {1}},
+			Properties: map[string][]any{"post-status": {"a", "b"}},
+		}).ParseCommands()
+		assert.EqualError(t, err, "invalid command: mp-slug must have a single value")
+	}
+
+	_, err := (&Request{Properties: map[string][]any{"post-status": {"a", "b"}}}).ParseCommands()
+	assert.EqualError(t, err, "invalid command: post-status must have a single value")
+
+	_, err = (&Request{Commands: map[string][]any{"post-status": {1}}}).ParseCommands()
+	assert.EqualError(t, err, "invalid command: mp-post-status must be a string")
+}
+
+func TestHandlerValidatesCommands(t *testing.T) {
+	t.Parallel()
+
+	impl := &mockRouterImplementation{}
+	impl.Mock.On("HasScope", mock.Anything, mock.Anything).Return(true)
+	impl.Mock.On("Create", mock.Anything).Return("https://example.com/1", nil)
+
+	handler := NewHandler(impl,
+		WithGetSyndicateTo(func() []Syndication {
+			return []Syndication{{UID: "https://social.example.com/"}}
+		}),
+		WithGetChannels(func() []Channel {
+			return []Channel{{UID: "notes", Name: "Notes"}}
+		}),
+		WithGetPostStatuses(func() []string {
+			return []string{"published", "draft"}
+		}),
+	)
+
+	for _, testCase := range []struct {
+		body string
+		code int
+	}{
+		{"h=entry&content=hello&mp-syndicate-to=https://social.example.com/&mp-channel=notes&post-status=draft", http.StatusAccepted},
+		{"h=entry&content=hello&mp-syndicate-to=https://other.example.com/", http.StatusBadRequest},
+		{"h=entry&content=hello&mp-channel=articles", http.StatusBadRequest},
+		{"h=entry&content=hello&post-status=secret", http.StatusBadRequest},
+		{"h=entry&content=hello&mp-slug=a&mp-slug=b", http.StatusBadRequest},
+		{`h=entry&content=hello&visibility=anything`, http.StatusAccepted},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/micropub", bytes.NewReader([]byte(testCase.body)))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, testCase.code, w.Code, testCase.body)
+	}
+
+	impl.AssertNumberOfCalls(t, "Create", 2)
+
+	// Without advertised syndication targets and channels, any is accepted.
+	r := httptest.NewRequest(http.MethodPost, "/micropub", bytes.NewReader([]byte("h=entry&content=hello&mp-syndicate-to=https://other.example.com/&mp-channel=articles")))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	NewHandler(impl).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+}
@@ -0,0 +1,91 @@
+package micropub
+
+import (
+	"context"
+	"net/http"
+	"slices"
+)
+
+type contextKey string
+
+const (
+	requestContextKey   contextKey = "request"
+	tokenInfoContextKey contextKey = "token-info"
+)
+
+// TokenInfo is the information about the access token of a request. It is
+// usually set by the authentication middleware with [ContextWithTokenInfo].
+type TokenInfo struct {
+	Me       string
+	ClientID string
+	Scopes   []string
+}
+
+// HasScope returns whether the token was granted the given scope. It is safe to
+// call on a nil [TokenInfo], in which case it returns false.
+func (t *TokenInfo) HasScope(scope string) bool {
+	return t != nil && slices.Contains(t.Scopes, scope)
+}
+
+// ContextWithTokenInfo returns a copy of the context with the given [TokenInfo].
+func ContextWithTokenInfo(ctx context.Context, info *TokenInfo) context.Context {
+	return context.WithValue(ctx, tokenInfoContextKey, info)
+}
+
+// TokenInfoFromContext returns the [TokenInfo] of the context, or nil if there
+// is none.
+func TokenInfoFromContext(ctx context.Context) *TokenInfo {
+	info, _ := ctx.Value(tokenInfoContextKey).(*TokenInfo)
+	return info
+}
+
+// RequestFromContext returns the original [http.Request] of the contexts given
+// to a [ContextImplementation], or nil if there is none.
+func RequestFromContext(ctx context.Context) *http.Request {
+	r, _ := ctx.Value(requestContextKey).(*http.Request)
+	return r
+}
+
+// AdaptImplementation adapts an [Implementation] to a [ContextImplementation].
+// The context is only used to retrieve the original request for
+// [Implementation.HasScope].
+func AdaptImplementation(impl Implementation) ContextImplementation {
+	return &implementationAdapter{impl: impl}
+}
+
+type implementationAdapter struct {
+	impl Implementation
+}
+
+func (a *implementationAdapter) HasScope(ctx context.Context, scope string) bool {
+	r := RequestFromContext(ctx)
+	if r == nil {
+		return false
+	}
+
+	return a.impl.HasScope(r, scope)
+}
+
+func (a *implementationAdapter) Source(_ context.Context, url string) (map[string]any, error) {
+	return a.impl.Source(url)
+}
+
+func (a *implementationAdapter) SourceList(_ context.Context, query *SourceQuery) (*SourceList, error) {
+	return sourceList(a.impl, query)
+}
+
+func (a *implementationAdapter) Create(_ context.Context, req *Request) (string, error) {
+	return a.impl.Create(req)
+}
+
+func (a *implementationAdapter) Update(_ context.Context, req *Request) (string, error) {
+	return a.impl.Update(req)
+}
+
+func (a *implementationAdapter) Delete(_ context.Context, url string) error {
+	return a.impl.Delete(url)
+}
+
+func (a *implementationAdapter) Undelete(_ context.Context, url string) error {
+	return a.impl.Undelete(url)
+}
@@ -0,0 +1,82 @@
+package micropub
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type contextImplementation struct {
+	ContextImplementation
+
+	requests []*http.Request
+	infos    []*TokenInfo
+}
+
+func (c *contextImplementation) HasScope(ctx context.Context, scope string) bool {
+	return TokenInfoFromContext(ctx).HasScope(scope)
+}
+
+func (c *contextImplementation) Create(ctx context.Context, req *Request) (string, error) {
+	c.requests = append(c.requests, RequestFromContext(ctx))
+	c.infos = append(c.infos, TokenInfoFromContext(ctx))
+	return "https://example.com/1", nil
+}
+
+func TestContextHandler(t *testing.T) {
+	t.Parallel()
+
+	impl := &contextImplementation{}
+	handler := NewContextHandler(impl)
+	info := &TokenInfo{Me: "https://example.com/", ClientID: "https://app.example.com/", Scopes: []string{"create"}}
+
+	post := func(info *TokenInfo) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/micropub", strings.NewReader("h=entry&content=hello"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r = r.WithContext(ContextWithTokenInfo(r.Context(), info))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := post(info)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	require.Len(t, impl.requests, 1)
+	assert.Equal(t, "/micropub", impl.requests[0].URL.Path)
+	assert.Equal(t, info, impl.infos[0])
+
+	w = post(&TokenInfo{Me: "https://example.com/", Scopes: []string{"update"}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Len(t, impl.requests, 1)
+
+	assert.Nil(t, TokenInfoFromContext(context.Background()))
+	assert.Nil(t, RequestFromContext(context.Background()))
+}
+
+func TestAdaptImplementation(t *testing.T) {
+	t.Parallel()
+
+	impl := &mockRouterImplementation{}
+	impl.Mock.On("HasScope", mock.Anything, "create").Return(true)
+	impl.Mock.On("Source", "https://example.com/1").Return(map[string]any{}, nil)
+
+	adapted := AdaptImplementation(impl)
+
+	// Without the original request, scopes cannot be checked.
+	assert.False(t, adapted.HasScope(context.Background(), "create"))
+
+	r := httptest.NewRequest(http.MethodGet, "/micropub", nil)
+	ctx := context.WithValue(r.Context(), requestContextKey, r)
+	assert.True(t, adapted.HasScope(ctx, "create"))
+
+	_, err := adapted.Source(ctx, "https://example.com/1")
+	require.NoError(t, err)
+	impl.AssertExpectations(t)
+}
@@ -0,0 +1,79 @@
+package micropub
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Error is an error response of a Micropub or media endpoint, as per the
+// [specification]. It is returned by [Client] when the endpoint replies with an
+// error. Implementations, hooks and media uploaders can also return it to reply
+// with a specific status code, error code, such as "forbidden", "unauthorized",
+// "insufficient_scope" or "invalid_request", description and scope.
+//
+// If the status code is not set, it is derived from the error code. If the
+// error code is not set, it is replied as "invalid_request" for 4xx status codes,
+// and "server_error" otherwise.
+//
+// [specification]: https://micropub.spec.indieweb.org/#error-response
+type Error struct {
+	StatusCode  int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("micropub: %d", e.status())
+	if e.Code != "" {
+		msg += " " + e.Code
+	}
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	return msg
+}
+
+// Is allows to match the error against [ErrNotFound], [ErrBadRequest] and
+// [ErrNotImplemented] using [errors.Is], according to the status code.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.status() == http.StatusNotFound
+	case ErrBadRequest:
+		return e.status() == http.StatusBadRequest
+	case ErrNotImplemented:
+		return e.status() == http.StatusNotImplemented
+	default:
+		return false
+	}
+}
+
+func (e *Error) status() int {
+	if e.StatusCode != 0 {
+		return e.StatusCode
+	}
+
+	switch e.Code {
+	case "invalid_request":
+		return http.StatusBadRequest
+	case "unauthorized":
+		return http.StatusUnauthorized
+	case "forbidden", "insufficient_scope":
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (e *Error) code() string {
+	if e.Code != "" {
+		return e.Code
+	}
+
+	if status := e.status(); status >= 400 && status < 500 {
+		return "invalid_request"
+	}
+
+	return "server_error"
+}
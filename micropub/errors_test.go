@@ -0,0 +1,148 @@
+package micropub
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeError(t *testing.T) {
+	t.Parallel()
+
+	for _, testCase := range []struct {
+		err          error
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			&Error{Code: "forbidden", Description: "Posting is disabled."},
+			http.StatusForbidden,
+			`{"error":"forbidden","error_description":"Posting is disabled."}`,
+		},
+		{
+			fmt.Errorf("wrapped: %w", &Error{Code: "unauthorized"}),
+			http.StatusUnauthorized,
+			`{"error":"unauthorized"}`,
+		},
+		{
+			&Error{Code: "insufficient_scope", Description: "Missing scope.", Scope: "draft"},
+			http.StatusForbidden,
+			`{"error":"insufficient_scope","error_description":"Missing scope.","scope":"draft"}`,
+		},
+		{
+			&Error{StatusCode: http.StatusTooManyRequests, Code: "invalid_request", Description: "Quota exceeded."},
+			http.StatusTooManyRequests,
+			`{"error":"invalid_request","error_description":"Quota exceeded."}`,
+		},
+		{
+			&Error{StatusCode: http.StatusConflict, Description: "Post already exists."},
+			http.StatusConflict,
+			`{"error":"invalid_request","error_description":"Post already exists."}`,
+		},
+		{
+			&Error{StatusCode: http.StatusServiceUnavailable},
+			http.StatusServiceUnavailable,
+			`{"error":"server_error"}`,
+		},
+		{
+			fmt.Errorf("%w: missing content", ErrBadRequest),
+			http.StatusBadRequest,
+			`{"error":"invalid_request","error_description":"invalid request: missing content"}`,
+		},
+		{
+			errors.New("connection to 10.0.0.1 refused"),
+			http.StatusInternalServerError,
+			`{"error":"server_error","error_description":"Internal server error."}`,
+		},
+	} {
+		var logs bytes.Buffer
+		w := httptest.NewRecorder()
+		serveError(w, slog.New(slog.NewTextHandler(&logs, nil)), testCase.err)
+		assert.Equal(t, testCase.expectedCode, w.Code, testCase.err.Error())
+		assert.JSONEq(t, testCase.expectedBody, w.Body.String(), testCase.err.Error())
+
+		// Only internal errors are logged.
+		if testCase.expectedBody == `{"error":"server_error","error_description":"Internal server error."}` {
+			assert.Contains(t, logs.String(), testCase.err.Error())
+		} else {
+			assert.Empty(t, logs.String())
+		}
+	}
+
+	assert.ErrorIs(t, &Error{Code: "invalid_request"}, ErrBadRequest)
+	assert.NotErrorIs(t, &Error{Code: "forbidden"}, ErrBadRequest)
+}
+
+func TestHandlersHonorError(t *testing.T) {
+	t.Parallel()
+
+	impl := &mockRouterImplementation{}
+	impl.Mock.On("HasScope", mock.Anything, mock.Anything).Return(true)
+	impl.Mock.On("Create", mock.Anything).Return("", &Error{Code: "forbidden", Description: "Posting is disabled."})
+	impl.Mock.On("Delete", mock.Anything).Return(errors.New("database is locked"))
+
+	var logs bytes.Buffer
+	handler := NewHandler(impl, WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+
+	post := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/micropub", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := post("h=entry&content=hello")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.JSONEq(t, `{"error":"forbidden","error_description":"Posting is disabled."}`, w.Body.String())
+
+	w = post("action=delete&url=https://example.com/1")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, w.Body.String(), "database")
+	assert.Contains(t, logs.String(), "database is locked")
+
+	mediaHandler := NewMediaHandler(func(file multipart.File, header *multipart.FileHeader) (string, error) {
+		return "", &Error{StatusCode: http.StatusRequestEntityTooLarge, Code: "invalid_request", Description: "Quota exceeded."}
+	}, func(r *http.Request, scope string) bool {
+		return true
+	})
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("file", "hello.txt")
+	require.NoError(t, err)
+	_, err = fw.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	r := httptest.NewRequest(http.MethodPost, "/media", bytes.NewReader(body.Bytes()))
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w = httptest.NewRecorder()
+	mediaHandler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.JSONEq(t, `{"error":"invalid_request","error_description":"Quota exceeded."}`, w.Body.String())
+
+	logs.Reset()
+	mediaHandler = NewMediaHandler(func(file multipart.File, header *multipart.FileHeader) (string, error) {
+		return "", errors.New("disk is full")
+	}, func(r *http.Request, scope string) bool {
+		return true
+	}, WithMediaLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+
+	r = httptest.NewRequest(http.MethodPost, "/media", bytes.NewReader(body.Bytes()))
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w = httptest.NewRecorder()
+	mediaHandler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, logs.String(), "disk is full")
+}
@@ -0,0 +1,191 @@
+package micropub
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// formValues are the values of a form key without the "[]" suffix. If there is
+// a single value, it is decoded as such, instead of as an array.
+type formValues []any
+
+// decodeForm decodes form-encoded values using the bracket notation, as used
+// by many Micropub clients, into nested objects and arrays:
+//
+//   - "category[]=a&category[]=b" decodes to {"category": ["a", "b"]}.
+//   - "photo[value]=url&photo[alt]=text" decodes to {"photo": {"value": "url", "alt": "text"}}.
+//   - "photo[][value]=a&photo[][value]=b" decodes to {"photo": [{"value": "a"}, {"value": "b"}]}.
+//     The n-th value of each key is part of the n-th object.
+//
+// Keys without a "[]" suffix with a single value decode to a single value,
+// while keys with multiple values decode to an array. Malformed keys are
+// kept as they are.
+func decodeForm(values url.Values) (map[string]any, error) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	root := map[string]any{}
+	for _, key := range keys {
+		if len(values[key]) == 0 {
+			continue
+		}
+
+		if err := setFormValue(root, parseFormKey(key), values[key]); err != nil {
+			return nil, fmt.Errorf("%w: %s", err, key)
+		}
+	}
+
+	for key, value := range root {
+		root[key] = normalizeFormValue(value)
+	}
+
+	return root, nil
+}
+
+// parseFormKey splits a key such as "a[b][][c]" into its segments: "a", "b",
+// "" and "c".
+func parseFormKey(key string) []string {
+	i := strings.IndexByte(key, '[')
+	if i <= 0 {
+		return []string{key}
+	}
+
+	segments := []string{key[:i]}
+	rest := key[i:]
+	for rest != "" {
+		end := strings.IndexByte(rest, ']')
+		if rest[0] != '[' || end == -1 {
+			return []string{key}
+		}
+
+		segments = append(segments, rest[1:end])
+		rest = rest[end+1:]
+	}
+
+	return segments
+}
+
+func setFormValue(node map[string]any, segments []string, values []string) error {
+	name, rest := segments[0], segments[1:]
+
+	switch {
+	case len(rest) == 0:
+		switch existing := node[name].(type) {
+		case nil:
+			node[name] = append(formValues{}, asAnySlice(values)...)
+		case formValues:
+			node[name] = append(existing, asAnySlice(values)...)
+		case []any:
+			node[name] = append(existing, asAnySlice(values)...)
+		default:
+			return ErrInvalidFormKey
+		}
+	case rest[0] == "" && len(rest) == 1:
+		switch existing := node[name].(type) {
+		case nil:
+			node[name] = asAnySlice(values)
+		case formValues:
+			node[name] = append([]any(existing), asAnySlice(values)...)
+		case []any:
+			node[name] = append(existing, asAnySlice(values)...)
+		default:
+			return ErrInvalidFormKey
+		}
+	case rest[0] == "":
+		var arr []any
+		switch existing := node[name].(type) {
+		case nil:
+		case []any:
+			arr = existing
+		default:
+			return ErrInvalidFormKey
+		}
+
+		for i, value := range values {
+			if i == len(arr) {
+				arr = append(arr, map[string]any{})
+			}
+
+			child, ok := arr[i].(map[string]any)
+			if !ok {
+				return ErrInvalidFormKey
+			}
+
+			if err := setFormValue(child, rest[1:], []string{value}); err != nil {
+				return err
+			}
+		}
+
+		node[name] = arr
+	default:
+		child, ok := node[name].(map[string]any)
+		if node[name] == nil {
+			child = map[string]any{}
+			node[name] = child
+		} else if !ok {
+			return ErrInvalidFormKey
+		}
+
+		return setFormValue(child, rest, values)
+	}
+
+	return nil
+}
+
+// normalizeFormValue converts the decoded values into their final form. Objects
+// with a "type" or "properties" are considered Microformats and their type and
+// properties are always arrays.
+func normalizeFormValue(value any) any {
+	switch value := value.(type) {
+	case formValues:
+		if len(value) == 1 {
+			return value[0]
+		}
+		return normalizeFormValue([]any(value))
+	case []any:
+		arr := make([]any, len(value))
+		for i, v := range value {
+			arr[i] = normalizeFormValue(v)
+		}
+		return arr
+	case map[string]any:
+		_, hasType := value["type"]
+		_, hasProperties := value["properties"]
+		mf2 := hasType || hasProperties
+
+		obj := map[string]any{}
+		for key, v := range value {
+			obj[key] = normalizeFormValue(v)
+		}
+
+		if mf2 {
+			if typ, ok := obj["type"]; ok {
+				obj["type"] = asSlice(typ)
+			}
+
+			if properties, ok := obj["properties"].(map[string]any); ok {
+				for key, v := range properties {
+					properties[key] = asSlice(v)
+				}
+			}
+		}
+
+		return obj
+	default:
+		return value
+	}
+}
+
+// asSlice returns the value as is if it is an array, or wrapped in an array
+// otherwise.
+func asSlice(value any) []any {
+	if arr, ok := value.([]any); ok {
+		return arr
+	}
+	return []any{value}
+}
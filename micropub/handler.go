@@ -1,11 +1,16 @@
 package micropub
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"mime/multipart"
 	"net/http"
-	"strconv"
+	"slices"
+	"sync"
+	"time"
 )
 
 var (
@@ -18,12 +23,42 @@ var (
 // to customize your endpoint.
 type Configuration struct {
 	MediaEndpoint   string
+	MediaUploader   MediaUploader
+	Media           MediaConfiguration
 	GetSyndicateTo  func() []Syndication
 	GetChannels     func() []Channel
 	GetCategories   func() []string
 	GetPostStatuses func() []string
 	GetPostTypes    func() []PostType
 	GetVisibility   func() []string
+
+	CategoryProvider CategoryProvider
+	ContactProvider  ContactProvider
+	GeoProvider      GeoProvider
+
+	// Jobs stores the jobs of asynchronous create requests. See [WithAsyncCreates].
+	Jobs JobStore
+
+	// Idempotency stores the responses of create requests, which are replayed
+	// within the window. See [WithIdempotency].
+	Idempotency       IdempotencyStore
+	IdempotencyWindow time.Duration
+
+	// BeforeHooks and AfterHooks run around each action. See [WithBeforeHooks]
+	// and [WithAfterHooks].
+	BeforeHooks []BeforeHook
+	AfterHooks  []AfterHook
+
+	// Logger logs the internal errors of the handler. See [WithLogger].
+	Logger *slog.Logger
+
+	// QueryHandlers are the custom queries, by name. See [WithQueryHandler].
+	QueryHandlers map[string]QueryHandler
+
+	// ValidatePostTypes enables the validation of create requests with a
+	// [PostTypeValidator]. See [WithPostTypeValidation].
+	ValidatePostTypes bool
+	StrictPostTypes   bool
 }
 
 // PostType is used to provide information regarding the server's [supported vocabulary].
@@ -60,6 +95,28 @@ func WithMediaEndpoint(endpoint string) Option {
 	}
 }
 
+// WithMediaUploader configures the [MediaUploader] used to upload the files of
+// multipart create requests, as per the [specification]. The files are limited
+// by the given [MediaOption]s. If this is not set, multipart requests with files
+// are rejected.
+//
+// [specification]: https://micropub.spec.indieweb.org/#uploading-files
+func WithMediaUploader(mediaUploader MediaUploader, options ...MediaOption) Option {
+	return func(conf *Configuration) {
+		conf.MediaUploader = mediaUploader
+		conf.Media = *newMediaConfiguration(options)
+	}
+}
+
+// WithLogger configures the logger of the internal errors, which are replied
+// to the client with a generic description. By default, [slog.Default] is used.
+// Use [WithMediaLogger] to configure the logger of [NewMediaHandler].
+func WithLogger(logger *slog.Logger) Option {
+	return func(conf *Configuration) {
+		conf.Logger = logger
+	}
+}
+
 // WithGetSyndicateTo configures the getter for syndication targets. This allows
 // for dynamic syndication targets. Return an empty slice if there are no targets.
 func WithGetSyndicateTo(getSyndicateTo func() []Syndication) Option {
@@ -113,6 +170,16 @@ func WithGetPostStatuses(getPostStatuses func() []string) Option {
 	}
 }
 
+// WithPostTypeValidation enables the validation of create requests against the
+// post types configured with [WithGetPostTypes], using a [PostTypeValidator].
+// Invalid requests are rejected with a [ValidationError].
+func WithPostTypeValidation(strict bool) Option {
+	return func(conf *Configuration) {
+		conf.ValidatePostTypes = true
+		conf.StrictPostTypes = strict
+	}
+}
+
 // Implementation is the backend implementation necessary to run a Micropub
 // server with [Router].
 //
@@ -130,7 +197,8 @@ type Implementation interface {
 
 	// Source all returns the Microformats source for a [limit] amount of posts,
 	// offset by the given [offset]. Used to implement [post list]. Limit will be
-	// -1 by default, and offset 0.
+	// -1 by default, and offset 0. Implement [SourceLister] to support filters
+	// and cursors.
 	//
 	// [post list]: https://indieweb.org/Micropub-extensions#Query_for_Post_List
 	SourceMany(limit, offset int) ([]map[string]any, error)
@@ -150,9 +218,47 @@ type Implementation interface {
 	Undelete(url string) error
 }
 
+// ContextImplementation is the backend implementation necessary to run a
+// Micropub server with [NewContextHandler]. It is equivalent to [Implementation],
+// but its methods receive the context of the request, which carries the
+// original [http.Request] and the token information, if any. See
+// [RequestFromContext] and [TokenInfoFromContext].
+//
+// Use [AdaptImplementation] to adapt an existing [Implementation].
+type ContextImplementation interface {
+	// HasScope returns whether or not the request is authorized for a certain scope.
+	HasScope(ctx context.Context, scope string) bool
+
+	// Source returns the Microformats source of a certain URL.
+	Source(ctx context.Context, url string) (map[string]any, error)
+
+	// SourceList returns the Microformats source of the posts that match the
+	// given query. Used to implement [post list].
+	//
+	// [post list]: https://indieweb.org/Micropub-extensions#Query_for_Post_List
+	SourceList(ctx context.Context, query *SourceQuery) (*SourceList, error)
+
+	// Create makes a create request according to the given [Request].
+	// Must return the location (e.g., URL) of the created post.
+	Create(ctx context.Context, req *Request) (string, error)
+
+	// Update makes an update request according to the given [Request].
+	// Must return the location (e.g., URL) of the update post.
+	Update(ctx context.Context, req *Request) (string, error)
+
+	// Delete deletes the post at the given URL.
+	Delete(ctx context.Context, url string) error
+
+	// Undelete reverts a deletion of the post at the given URL.
+	Undelete(ctx context.Context, url string) error
+}
+
 type handler struct {
 	conf Configuration
-	impl Implementation
+	impl ContextImplementation
+
+	inflightMu sync.Mutex
+	inflight   map[string]chan struct{}
 }
 
 // NewHandler creates a new Micropub [http.Handler] conforming to the [specification].
@@ -166,18 +272,33 @@ type handler struct {
 //   - GET /micropub?q=syndicate-to
 //   - GET /micropub?q=category
 //   - GET /micropub?q=channel
+//   - GET /micropub?q=post-types
+//   - GET /micropub?q=contact, if [WithContactProvider] is set
+//   - GET /micropub?q=geo, if [WithGeoProvider] is set
+//   - GET /micropub?q=job, if [WithAsyncCreates] is set
+//   - GET /micropub?q=..., for each [QueryHandler] set with [WithQueryHandler]
 //   - POST /micropub (form-encoded): create, delete, undelete
+//   - POST /micropub (multipart): create, with files if [WithMediaUploader] is set
 //   - POST /micropub (json): create, update, delete, undelete
 //
 // [specification]: https://micropub.spec.indieweb.org/
 func NewHandler(impl Implementation, options ...Option) http.Handler {
+	return NewContextHandler(AdaptImplementation(impl), options...)
+}
+
+// NewContextHandler creates a new Micropub [http.Handler] in the same way as
+// [NewHandler], but using a [ContextImplementation].
+func NewContextHandler(impl ContextImplementation, options ...Option) http.Handler {
 	conf := &Configuration{
-		MediaEndpoint:  "",
-		GetSyndicateTo: func() []Syndication { return nil },
-		GetChannels:    func() []Channel { return nil },
-		GetCategories:  func() []string { return nil },
-		GetPostTypes:   func() []PostType { return nil },
-		GetVisibility:  func() []string { return nil },
+		MediaEndpoint:   "",
+		Media:           *newMediaConfiguration(nil),
+		GetSyndicateTo:  func() []Syndication { return nil },
+		GetChannels:     func() []Channel { return nil },
+		GetCategories:   func() []string { return nil },
+		GetPostStatuses: func() []string { return nil },
+		GetPostTypes:    func() []PostType { return nil },
+		GetVisibility:   func() []string { return nil },
+		Logger:          slog.Default(),
 	}
 
 	for _, opt := range options {
@@ -185,28 +306,43 @@ func NewHandler(impl Implementation, options ...Option) http.Handler {
 	}
 
 	return &handler{
-		conf: *conf,
-		impl: impl,
+		conf:     *conf,
+		impl:     impl,
+		inflight: map[string]chan struct{}{},
 	}
 }
 
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = r.WithContext(context.WithValue(r.Context(), requestContextKey, r))
+
 	switch r.Method {
 	case http.MethodGet:
 		h.micropubGet(w, r)
 	case http.MethodPost:
-		h.micropubPost(w, r)
+		if h.conf.Idempotency != nil {
+			h.serveIdempotent(w, r, h.micropubPost)
+		} else {
+			h.micropubPost(w, r)
+		}
 	default:
-		serveError(w, ErrNotImplemented)
+		serveError(w, h.conf.Logger, ErrNotImplemented)
 	}
 }
 
 func (h *handler) micropubGet(w http.ResponseWriter, r *http.Request) {
-	switch r.URL.Query().Get("q") {
+	q := r.URL.Query().Get("q")
+	if queryHandler, ok := h.conf.QueryHandlers[q]; ok && q != "config" {
+		h.micropubCustomQuery(w, r, queryHandler)
+		return
+	}
+
+	switch q {
 	case "source":
 		h.micropubSource(w, r)
 	case "config":
-		config := map[string]any{}
+		config := map[string]any{
+			"q": h.queries(),
+		}
 		if h.conf.MediaEndpoint != "" {
 			config["media-endpoint"] = h.conf.MediaEndpoint
 		}
@@ -232,90 +368,133 @@ func (h *handler) micropubGet(w http.ResponseWriter, r *http.Request) {
 	case "syndicate-to":
 		syndicateTo := h.conf.GetSyndicateTo()
 		if len(syndicateTo) == 0 {
-			serveError(w, ErrNotFound)
+			serveError(w, h.conf.Logger, ErrNotFound)
 		} else {
 			serveJSON(w, http.StatusOK, map[string]any{"syndicate-to": syndicateTo})
 		}
 	case "category":
-		categories := h.conf.GetCategories()
-		if len(categories) == 0 {
-			serveError(w, ErrNotFound)
-		} else {
-			serveJSON(w, http.StatusOK, map[string]any{"categories": categories})
-		}
+		h.micropubCategory(w, r)
+	case "contact":
+		h.micropubContact(w, r)
+	case "geo":
+		h.micropubGeo(w, r)
 	case "channel":
 		channels := h.conf.GetChannels()
 		if len(channels) == 0 {
-			serveError(w, ErrNotFound)
+			serveError(w, h.conf.Logger, ErrNotFound)
 		} else {
 			serveJSON(w, http.StatusOK, map[string]any{"channels": channels})
 		}
+	case "job":
+		h.micropubJob(w, r)
+	case "post-types":
+		postTypes := h.conf.GetPostTypes()
+		if len(postTypes) == 0 {
+			serveError(w, h.conf.Logger, ErrNotFound)
+		} else {
+			serveJSON(w, http.StatusOK, map[string]any{"post-types": postTypes})
+		}
 	default:
-		serveError(w, ErrNotFound)
+		serveError(w, h.conf.Logger, ErrNotFound)
 	}
 }
 
-func (h *handler) micropubSource(w http.ResponseWriter, r *http.Request) {
-	url := r.URL.Query().Get("url")
-	if url == "" {
-		limitStr := r.URL.Query().Get("limit")
-		if limitStr == "" {
-			limitStr = "-1"
+// queries returns the queries supported by the handler, which are advertised
+// in the "q" member of the configuration. Apart from "config" and "source",
+// which are always supported, the queries depend on the configured options
+// and the registered [QueryHandler]s.
+func (h *handler) queries() []string {
+	queries := []string{"config", "source"}
+	for _, query := range []struct {
+		name      string
+		supported bool
+	}{
+		{"syndicate-to", len(h.conf.GetSyndicateTo()) != 0},
+		{"category", h.conf.CategoryProvider != nil || len(h.conf.GetCategories()) != 0},
+		{"channel", len(h.conf.GetChannels()) != 0},
+		{"post-types", len(h.conf.GetPostTypes()) != 0},
+		{"contact", h.conf.ContactProvider != nil},
+		{"geo", h.conf.GeoProvider != nil},
+		{"job", h.asyncCreates()},
+	} {
+		if query.supported {
+			queries = append(queries, query.name)
 		}
+	}
 
-		offsetStr := r.URL.Query().Get("offset")
-		if offsetStr == "" {
-			offsetStr = "0"
+	for _, name := range sortedKeys(h.conf.QueryHandlers) {
+		if !slices.Contains(queries, name) {
+			queries = append(queries, name)
 		}
+	}
+
+	return queries
+}
 
-		limit, err := strconv.Atoi(limitStr)
+func (h *handler) micropubSource(w http.ResponseWriter, r *http.Request) {
+	url := r.URL.Query().Get("url")
+	if url == "" {
+		query, err := parseSourceQuery(r.URL.Query())
 		if err != nil {
-			serveError(w, errors.Join(ErrBadRequest, err))
+			serveError(w, h.conf.Logger, err)
 			return
 		}
 
-		offset, err := strconv.Atoi(offsetStr)
+		list, err := h.impl.SourceList(r.Context(), query)
 		if err != nil {
-			serveError(w, errors.Join(ErrBadRequest, err))
+			serveError(w, h.conf.Logger, err)
 			return
 		}
+		if list == nil {
+			list = &SourceList{}
+		}
 
-		items, err := h.impl.SourceMany(limit, offset)
-		if err != nil {
-			serveError(w, err)
-			return
+		for i, item := range list.Items {
+			list.Items[i] = projectSource(item, query.Properties)
 		}
 
-		serveJSON(w, http.StatusOK, map[string]any{
-			"items": items,
-		})
+		serveJSON(w, http.StatusOK, list)
 		return
 	}
 
-	item, err := h.impl.Source(url)
+	item, err := h.impl.Source(r.Context(), url)
 	if err != nil {
-		serveError(w, err)
+		serveError(w, h.conf.Logger, err)
 		return
 	}
 
-	serveJSON(w, http.StatusOK, item)
+	serveJSON(w, http.StatusOK, projectSource(item, sourceProperties(r.URL.Query())))
 }
 
 func (h *handler) micropubPost(w http.ResponseWriter, r *http.Request) {
-	mr, err := ParseRequest(r)
+	mr, files, err := parseRequest(w, r, &h.conf.Media)
 	if err != nil {
-		serveError(w, errors.Join(ErrBadRequest, err))
+		serveError(w, h.conf.Logger, errors.Join(ErrBadRequest, err))
 		return
 	}
+	defer removeMultipartForm(r)
 
 	switch mr.Action {
 	case ActionCreate:
 		if !h.checkScope(w, r, "create") {
 			return
 		}
-		location, err := h.impl.Create(mr)
+		if !h.runBeforeHooks(w, r, mr) {
+			return
+		}
+		if err := h.prepareCreate(mr, files); err != nil {
+			h.runAfterHooks(r, mr, "", err)
+			serveError(w, h.conf.Logger, err)
+			return
+		}
+		if creator, ok := asyncCreator(h.impl); ok && h.conf.Jobs != nil {
+			h.micropubCreateAsync(w, r, creator, mr)
+			return
+		}
+		location, err := h.impl.Create(r.Context(), mr)
+		h.runAfterHooks(r, mr, location, err)
 		if err != nil {
-			serveError(w, err)
+			serveError(w, h.conf.Logger, err)
 			return
 		}
 		w.Header().Set("Content-Type", "text/plain")
@@ -324,9 +503,19 @@ func (h *handler) micropubPost(w http.ResponseWriter, r *http.Request) {
 		if !h.checkScope(w, r, "update") {
 			return
 		}
-		location, err := h.impl.Update(mr)
+		if !h.runBeforeHooks(w, r, mr) {
+			return
+		}
+		if err := h.conf.validateCommands(mr); err != nil {
+			err = errors.Join(ErrBadRequest, err)
+			h.runAfterHooks(r, mr, "", err)
+			serveError(w, h.conf.Logger, err)
+			return
+		}
+		location, err := h.impl.Update(r.Context(), mr)
+		h.runAfterHooks(r, mr, location, err)
 		if err != nil {
-			serveError(w, err)
+			serveError(w, h.conf.Logger, err)
 			return
 		}
 		http.Redirect(w, r, location, http.StatusOK)
@@ -334,9 +523,13 @@ func (h *handler) micropubPost(w http.ResponseWriter, r *http.Request) {
 		if !h.checkScope(w, r, "delete") {
 			return
 		}
-		err = h.impl.Delete(mr.URL)
+		if !h.runBeforeHooks(w, r, mr) {
+			return
+		}
+		err = h.impl.Delete(r.Context(), mr.URL)
+		h.runAfterHooks(r, mr, "", err)
 		if err != nil {
-			serveError(w, err)
+			serveError(w, h.conf.Logger, err)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
@@ -344,36 +537,111 @@ func (h *handler) micropubPost(w http.ResponseWriter, r *http.Request) {
 		if !h.checkScope(w, r, "undelete") {
 			return
 		}
-		err = h.impl.Undelete(mr.URL)
+		if !h.runBeforeHooks(w, r, mr) {
+			return
+		}
+		err = h.impl.Undelete(r.Context(), mr.URL)
+		h.runAfterHooks(r, mr, "", err)
 		if err != nil {
-			serveError(w, err)
+			serveError(w, h.conf.Logger, err)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
 	default:
-		serveError(w, fmt.Errorf("%w: invalid action '%q'", ErrBadRequest, mr.Action))
+		serveError(w, h.conf.Logger, fmt.Errorf("%w: invalid action '%q'", ErrBadRequest, mr.Action))
+	}
+}
+
+// prepareCreate validates the create request and uploads its files, replacing
+// them by their locations. The request is validated before uploading the files,
+// such that invalid requests do not leave uploaded files behind. The locations
+// of the files are validated after uploading them.
+func (h *handler) prepareCreate(mr *Request, files map[string][]*multipart.FileHeader) error {
+	if err := h.conf.validateCommands(mr); err != nil {
+		return errors.Join(ErrBadRequest, err)
+	}
+
+	var validator *PostTypeValidator
+	if h.conf.ValidatePostTypes {
+		validator = &PostTypeValidator{PostTypes: h.conf.GetPostTypes(), Strict: h.conf.StrictPostTypes}
+		if err := validator.validate(mr, fileProperties(files)); err != nil {
+			return err
+		}
 	}
+
+	if err := uploadFiles(mr, files, h.conf.MediaUploader, &h.conf.Media); errors.Is(err, ErrNoMediaUploader) || errors.Is(err, ErrMediaTooLarge) {
+		return errors.Join(ErrBadRequest, err)
+	} else if err != nil {
+		return err
+	}
+
+	if validator != nil && len(files) != 0 {
+		return validator.Validate(mr)
+	}
+
+	return nil
 }
 
 func (h *handler) checkScope(w http.ResponseWriter, r *http.Request, scope string) bool {
-	if !h.impl.HasScope(r, scope) {
-		serveErrorJSON(w, http.StatusForbidden, "insufficient_scope", "Insufficient scope.")
+	if !h.impl.HasScope(r.Context(), scope) {
+		serveError(w, h.conf.Logger, &Error{
+			StatusCode:  http.StatusForbidden,
+			Code:        "insufficient_scope",
+			Description: "Insufficient scope.",
+		})
 		return false
 	}
 
 	return true
 }
 
-func serveError(w http.ResponseWriter, err error) {
-	if errors.Is(err, ErrNotFound) {
+// serveError replies with the JSON error response for the given error. Errors
+// that are not an [Error], a [ValidationError], or one of [ErrNotFound],
+// [ErrBadRequest] and [ErrNotImplemented], are internal errors: they are logged
+// with the given logger and replied with a generic description, such that no
+// internal details are leaked to the client.
+func serveError(w http.ResponseWriter, logger *slog.Logger, err error) {
+	var (
+		validationErr *ValidationError
+		e             *Error
+	)
+	if errors.As(err, &validationErr) {
+		serveJSON(w, http.StatusBadRequest, map[string]any{
+			"error":             "invalid_request",
+			"error_description": err.Error(),
+			"fields":            validationErr.Fields,
+		})
+	} else if errors.As(err, &e) {
+		res := *e
+		res.Code = e.code()
+		serveJSON(w, e.status(), &res)
+	} else if errors.Is(err, ErrNotFound) {
 		serveErrorJSON(w, http.StatusNotFound, "invalid_request", err.Error())
 	} else if errors.Is(err, ErrBadRequest) {
 		serveErrorJSON(w, http.StatusBadRequest, "invalid_request", err.Error())
 	} else if errors.Is(err, ErrNotImplemented) {
 		serveErrorJSON(w, http.StatusNotImplemented, "invalid_request", err.Error())
 	} else {
-		serveErrorJSON(w, http.StatusInternalServerError, "server_error", err.Error())
+		logger.Error("micropub: internal server error", "error", err)
+		serveErrorJSON(w, http.StatusInternalServerError, "server_error", "Internal server error.")
+	}
+}
+
+// errorDescription returns the description of the given error that can be
+// exposed to the client. Internal errors, as defined by [serveError], get a
+// generic description.
+func errorDescription(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		if e.Description == "" {
+			return e.code()
+		}
+		return e.Description
+	} else if errors.Is(err, ErrNotFound) || errors.Is(err, ErrBadRequest) || errors.Is(err, ErrNotImplemented) {
+		return err.Error()
 	}
+
+	return "Internal server error."
 }
 
 func serveJSON(w http.ResponseWriter, code int, data interface{}) {
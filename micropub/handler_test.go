@@ -149,7 +149,7 @@ func TestRouterGet(t *testing.T) {
 		assert.Equal(t, http.StatusOK, w.Result().StatusCode)
 		body, err := io.ReadAll(w.Result().Body)
 		assert.NoError(t, err)
-		assert.EqualValues(t, `{"categories":["a","b"],"media-endpoint":"https://example.com/media","post-status":["published","draft"],"visibility":["public"]}`+"\n", string(body))
+		assert.EqualValues(t, `{"categories":["a","b"],"media-endpoint":"https://example.com/media","post-status":["published","draft"],"q":["config","source","category"],"visibility":["public"]}`+"\n", string(body))
 	})
 
 	t.Run("?q=category, syndicate-to, channel", func(t *testing.T) {
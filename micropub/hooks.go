@@ -0,0 +1,57 @@
+package micropub
+
+import (
+	"net/http"
+)
+
+// BeforeHook runs before the action of a [Request], e.g., to normalize its
+// content or to enforce quotas. The action is given by [Request.Action]. The
+// hook can modify the request, or reject it by returning an error, which is
+// replied to the client in the same way as the errors of an [Implementation].
+type BeforeHook func(r *http.Request, req *Request) error
+
+// AfterHook runs after the action of a [Request] with its resulting location,
+// if any, and error, e.g., to send Webmentions once a post is created. For
+// asynchronous creates, the location is the one of the job status. See
+// [WithAsyncCreates].
+type AfterHook func(r *http.Request, req *Request, location string, err error)
+
+// WithBeforeHooks appends hooks that run, in order, before each action and after
+// checking the scope of the request. If a hook returns an error, the remaining
+// hooks and the action do not run.
+func WithBeforeHooks(hooks ...BeforeHook) Option {
+	return func(conf *Configuration) {
+		conf.BeforeHooks = append(conf.BeforeHooks, hooks...)
+	}
+}
+
+// WithAfterHooks appends hooks that run, in order, after the implementation
+// handles each action. They also run when the request is rejected after the
+// before hooks, e.g., by the validation of its commands or post type, or when
+// uploading its files fails, in which case the implementation is not called.
+// They do not run when the request cannot be parsed, lacks the scope, or is
+// rejected by a before hook.
+func WithAfterHooks(hooks ...AfterHook) Option {
+	return func(conf *Configuration) {
+		conf.AfterHooks = append(conf.AfterHooks, hooks...)
+	}
+}
+
+// runBeforeHooks runs the before hooks and replies with the error of the first
+// hook that fails. Returns whether the action can proceed.
+func (h *handler) runBeforeHooks(w http.ResponseWriter, r *http.Request, req *Request) bool {
+	for _, hook := range h.conf.BeforeHooks {
+		if err := hook(r, req); err != nil {
+			serveError(w, h.conf.Logger, err)
+			return false
+		}
+	}
+
+	return true
+}
+
+func (h *handler) runAfterHooks(r *http.Request, req *Request, location string, err error) {
+	for _, hook := range h.conf.AfterHooks {
+		hook(r, req, location, err)
+	}
+}
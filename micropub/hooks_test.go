@@ -0,0 +1,91 @@
+package micropub
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHooks(t *testing.T) {
+	t.Parallel()
+
+	impl := &mockRouterImplementation{}
+	impl.Mock.On("HasScope", mock.Anything, mock.Anything).Return(true)
+	impl.Mock.On("Create", &Request{
+		Action:     ActionCreate,
+		Type:       "h-entry",
+		Properties: map[string][]any{"content": {"hello"}, "category": {"auto"}},
+		Commands:   map[string][]any{},
+	}).Return("https://example.com/1", nil)
+	impl.Mock.On("Delete", "https://example.com/1").Return(ErrNotFound)
+
+	var calls []string
+	handler := NewHandler(impl,
+		WithBeforeHooks(func(r *http.Request, req *Request) error {
+			calls = append(calls, "normalize "+string(req.Action))
+			if req.Action == ActionCreate {
+				req.Properties["content"] = []any{strings.TrimSpace(req.Properties["content"][0].(string))}
+			}
+			return nil
+		}, func(r *http.Request, req *Request) error {
+			calls = append(calls, "tag "+string(req.Action))
+			if req.Action == ActionCreate {
+				req.Properties["category"] = []any{"auto"}
+			}
+			if r.Header.Get("X-Quota") == "exceeded" {
+				return fmt.Errorf("%w: quota exceeded", ErrBadRequest)
+			}
+			return nil
+		}),
+		WithAfterHooks(func(r *http.Request, req *Request, location string, err error) {
+			calls = append(calls, fmt.Sprintf("after %s %q %v", req.Action, location, err))
+		}),
+	)
+
+	post := func(body, quota string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/micropub", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("X-Quota", quota)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := post("h=entry&content=+hello+", "")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, []string{
+		"normalize create",
+		"tag create",
+		`after create "https://example.com/1" <nil>`,
+	}, calls)
+
+	calls = nil
+	w = post("h=entry&content=hello", "exceeded")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []string{"normalize create", "tag create"}, calls)
+
+	// The after hooks also run when the request is rejected after the before
+	// hooks, e.g., due to invalid commands.
+	calls = nil
+	w = post("h=entry&content=hello&mp-slug=a&mp-slug=b", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	require.Len(t, calls, 3)
+	assert.True(t, strings.HasPrefix(calls[2], `after create "" invalid request`), calls[2])
+
+	calls = nil
+	w = post("action=delete&url=https://example.com/1", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, []string{
+		"normalize delete",
+		"tag delete",
+		fmt.Sprintf(`after delete "" %v`, ErrNotFound),
+	}, calls)
+
+	impl.AssertNumberOfCalls(t, "Create", 1)
+}
@@ -0,0 +1,295 @@
+package micropub
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrIdempotencyKeyNotFound is returned when there is no response stored for
+// an idempotency key.
+var ErrIdempotencyKeyNotFound = errors.New("idempotency key not found")
+
+const (
+	// DefaultIdempotencyWindow is the default duration during which the
+	// responses of create requests with an Idempotency-Key header are replayed.
+	// See [WithIdempotency].
+	DefaultIdempotencyWindow = 24 * time.Hour
+
+	// DefaultBodyIdempotencyWindow is the maximum duration during which the
+	// responses of create requests without an Idempotency-Key header, which are
+	// keyed on their body, are replayed. Identical posts created on purpose, such
+	// as two likes of the same post, are only deduplicated within this window.
+	DefaultBodyIdempotencyWindow = 5 * time.Minute
+)
+
+// IdempotentResponse is the stored response of a create request, which is
+// replayed for repeated requests.
+type IdempotentResponse struct {
+	// BodyHash is the hash of the body of the request. Requests that reuse an
+	// Idempotency-Key header with a different body are rejected.
+	BodyHash string
+
+	StatusCode  int
+	Location    string
+	ContentType string
+	Body        []byte
+	Expires     time.Time
+}
+
+// IdempotencyStore stores the [IdempotentResponse]s of create requests.
+type IdempotencyStore interface {
+	// Get returns the response stored for the given key. Must return
+	// [ErrIdempotencyKeyNotFound] if there is none, or if it has expired.
+	Get(ctx context.Context, key string) (*IdempotentResponse, error)
+
+	// Put stores the response for the given key until it expires.
+	Put(ctx context.Context, key string, res *IdempotentResponse) error
+}
+
+type memoryIdempotencyStore struct {
+	mu        sync.Mutex
+	responses map[string]*IdempotentResponse
+}
+
+// NewMemoryIdempotencyStore creates a new in-memory [IdempotencyStore].
+// Expired responses are removed when new responses are stored.
+func NewMemoryIdempotencyStore() IdempotencyStore {
+	return &memoryIdempotencyStore{
+		responses: map[string]*IdempotentResponse{},
+	}
+}
+
+func (m *memoryIdempotencyStore) Get(_ context.Context, key string) (*IdempotentResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res, ok := m.responses[key]
+	if !ok || time.Now().After(res.Expires) {
+		return nil, ErrIdempotencyKeyNotFound
+	}
+
+	cp := *res
+	return &cp, nil
+}
+
+func (m *memoryIdempotencyStore) Put(_ context.Context, key string, res *IdempotentResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for k, r := range m.responses {
+		if now.After(r.Expires) {
+			delete(m.responses, k)
+		}
+	}
+
+	cp := *res
+	m.responses[key] = &cp
+	return nil
+}
+
+// WithIdempotency enables the deduplication of create requests, such that
+// clients retrying a request do not create duplicate posts. Requests are keyed
+// on their Idempotency-Key header or, if not present, on their body. Keys are
+// scoped to the access token. Within the window, repeated requests are replied
+// with the stored response and the Idempotent-Replayed header. Requests that
+// reuse an Idempotency-Key header with a different body are rejected with 422
+// Unprocessable Entity. If the window is zero, [DefaultIdempotencyWindow] is
+// used. Requests keyed on their body are replayed for at most
+// [DefaultBodyIdempotencyWindow]. Bodies are limited to the maximum media size,
+// and multipart bodies are kept in a temporary file while the request is handled.
+func WithIdempotency(store IdempotencyStore, window time.Duration) Option {
+	return func(conf *Configuration) {
+		if window == 0 {
+			window = DefaultIdempotencyWindow
+		}
+
+		conf.Idempotency = store
+		conf.IdempotencyWindow = window
+	}
+}
+
+// hashBody reads the body of the request, limited to maxSize bytes if not zero,
+// and returns its hash. The body is replaced, such that it can be read again.
+// Multipart bodies, which may contain large files, are hashed as they stream
+// into a temporary file, which is removed by the returned function, instead of
+// being kept in memory.
+func hashBody(w http.ResponseWriter, r *http.Request, maxSize int64) (string, func(), error) {
+	if maxSize != 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	}
+
+	h := sha256.New()
+	_, _ = io.WriteString(h, r.Header.Get("Content-Type")+"\n")
+	body := io.TeeReader(r.Body, h)
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != "multipart/form-data" {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return "", nil, err
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		return hex.EncodeToString(h.Sum(nil)), func() {}, nil
+	}
+
+	f, err := os.CreateTemp("", "micropub-body-*")
+	if err != nil {
+		return "", nil, err
+	}
+
+	remove := func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+
+	if _, err := io.Copy(f, body); err != nil {
+		remove()
+		return "", nil, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		remove()
+		return "", nil, err
+	}
+
+	r.Body = io.NopCloser(f)
+	return hex.EncodeToString(h.Sum(nil)), remove, nil
+}
+
+// idempotencyKey returns the key of the request with the given body hash, and
+// whether the key was derived from the body.
+func idempotencyKey(r *http.Request, bodyHash string) (string, bool) {
+	h := sha256.New()
+	_, _ = io.WriteString(h, r.Header.Get("Authorization")+"\n")
+	if info := TokenInfoFromContext(r.Context()); info != nil {
+		_, _ = io.WriteString(h, info.Me+"\n"+info.ClientID+"\n")
+	}
+
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		_, _ = io.WriteString(h, "key:"+key)
+		return hex.EncodeToString(h.Sum(nil)), false
+	}
+
+	_, _ = io.WriteString(h, "body:"+bodyHash)
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// serveIdempotent serves the POST request with next, replaying the stored
+// response of create requests with the same key. Requests with the same key
+// are handled one at a time.
+func (h *handler) serveIdempotent(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	bodyHash, remove, err := hashBody(w, r, h.conf.Media.MaxMediaSize)
+	if err != nil {
+		serveError(w, h.conf.Logger, errors.Join(ErrBadRequest, err))
+		return
+	}
+	defer remove()
+
+	key, fromBody := idempotencyKey(r, bodyHash)
+
+	unlock := h.lockKey(key)
+	defer unlock()
+
+	res, err := h.conf.Idempotency.Get(r.Context(), key)
+	if err == nil {
+		if res.BodyHash != bodyHash {
+			serveError(w, h.conf.Logger, &Error{
+				StatusCode:  http.StatusUnprocessableEntity,
+				Code:        "invalid_request",
+				Description: "The Idempotency-Key header was reused with a different request.",
+			})
+			return
+		}
+		if res.Location != "" {
+			w.Header().Set("Location", res.Location)
+		}
+		if res.ContentType != "" {
+			w.Header().Set("Content-Type", res.ContentType)
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(res.StatusCode)
+		_, _ = w.Write(res.Body)
+		return
+	} else if !errors.Is(err, ErrIdempotencyKeyNotFound) {
+		serveError(w, h.conf.Logger, err)
+		return
+	}
+
+	rec := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+	next(rec, r)
+
+	window := h.conf.IdempotencyWindow
+	if fromBody {
+		window = min(window, DefaultBodyIdempotencyWindow)
+	}
+
+	// Only successful creates are stored. Other requests can be safely retried.
+	if rec.statusCode == http.StatusCreated || rec.statusCode == http.StatusAccepted {
+		err := h.conf.Idempotency.Put(r.Context(), key, &IdempotentResponse{
+			BodyHash:    bodyHash,
+			StatusCode:  rec.statusCode,
+			Location:    rec.Header().Get("Location"),
+			ContentType: rec.Header().Get("Content-Type"),
+			Body:        rec.body.Bytes(),
+			Expires:     time.Now().Add(window),
+		})
+		if err != nil {
+			h.conf.Logger.Error("micropub: failed to store idempotent response", "error", err)
+		}
+	}
+}
+
+// lockKey waits until no other request with the given key is being handled,
+// and returns the function to unlock it.
+func (h *handler) lockKey(key string) func() {
+	for {
+		h.inflightMu.Lock()
+		ch, ok := h.inflight[key]
+		if !ok {
+			ch = make(chan struct{})
+			h.inflight[key] = ch
+			h.inflightMu.Unlock()
+
+			return func() {
+				h.inflightMu.Lock()
+				delete(h.inflight, key)
+				h.inflightMu.Unlock()
+				close(ch)
+			}
+		}
+		h.inflightMu.Unlock()
+		<-ch
+	}
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if !r.wroteHeader {
+		r.statusCode = statusCode
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
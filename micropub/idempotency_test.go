@@ -0,0 +1,211 @@
+package micropub
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotency(t *testing.T) {
+	t.Parallel()
+
+	newHandler := func(window time.Duration) (http.Handler, *mockRouterImplementation) {
+		impl := &mockRouterImplementation{}
+		impl.Mock.On("HasScope", mock.Anything, mock.Anything).Return(true)
+		impl.Mock.On("Create", mock.Anything).Return("https://example.com/1", nil)
+		return NewHandler(impl, WithIdempotency(NewMemoryIdempotencyStore(), window)), impl
+	}
+
+	create := func(handler http.Handler, body, token, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/micropub", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Authorization", "Bearer "+token)
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("Idempotency Key", func(t *testing.T) {
+		t.Parallel()
+
+		handler, impl := newHandler(0)
+
+		w := create(handler, "h=entry&content=hello", "a", "1")
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, "https://example.com/1", w.Header().Get("Location"))
+		assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+
+		w = create(handler, "h=entry&content=hello", "a", "1")
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, "https://example.com/1", w.Header().Get("Location"))
+		assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+
+		// Reusing the key with a different body is rejected.
+		w = create(handler, "h=entry&content=hello+again", "a", "1")
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), `"invalid_request"`)
+
+		w = create(handler, "h=entry&content=hello", "a", "2")
+		assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+
+		// Keys are scoped to the access token.
+		w = create(handler, "h=entry&content=hello", "b", "1")
+		assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+
+		impl.AssertNumberOfCalls(t, "Create", 3)
+	})
+
+	t.Run("Body", func(t *testing.T) {
+		t.Parallel()
+
+		impl := &mockRouterImplementation{}
+		impl.Mock.On("HasScope", mock.Anything, mock.Anything).Return(true)
+		impl.Mock.On("Create", mock.Anything).Return("https://example.com/1", nil)
+		store := &recordingIdempotencyStore{IdempotencyStore: NewMemoryIdempotencyStore()}
+		handler := NewHandler(impl, WithIdempotency(store, 0))
+
+		w := create(handler, "h=entry&content=hello", "a", "")
+		assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+
+		// Requests keyed on their body are replayed for a shorter window.
+		require.NotNil(t, store.last)
+		assert.WithinDuration(t, time.Now().Add(DefaultBodyIdempotencyWindow), store.last.Expires, time.Minute)
+
+		w = create(handler, "h=entry&content=hello", "a", "")
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+
+		w = create(handler, "h=entry&content=bye", "a", "")
+		assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+
+		impl.AssertNumberOfCalls(t, "Create", 2)
+	})
+
+	t.Run("Failures Are Not Stored", func(t *testing.T) {
+		t.Parallel()
+
+		handler, impl := newHandler(0)
+
+		w := create(handler, "h=entry&content=hello&mp-slug=a&mp-slug=b", "a", "1")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = create(handler, "h=entry&content=hello", "a", "1")
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+
+		impl.AssertNumberOfCalls(t, "Create", 1)
+	})
+
+	t.Run("Max Size", func(t *testing.T) {
+		t.Parallel()
+
+		impl := &mockRouterImplementation{}
+		handler := NewHandler(impl, WithIdempotency(NewMemoryIdempotencyStore(), 0), WithMediaUploader(nil, WithMaxMediaSize(10)))
+
+		w := create(handler, "h=entry&content=hello", "a", "1")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		impl.AssertNotCalled(t, "Create", mock.Anything)
+	})
+
+	t.Run("Failed Store", func(t *testing.T) {
+		t.Parallel()
+
+		impl := &mockRouterImplementation{}
+		impl.Mock.On("HasScope", mock.Anything, mock.Anything).Return(true)
+		impl.Mock.On("Create", mock.Anything).Return("https://example.com/1", nil)
+
+		var logs bytes.Buffer
+		store := &failingIdempotencyStore{IdempotencyStore: NewMemoryIdempotencyStore()}
+		handler := NewHandler(impl, WithIdempotency(store, 0), WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+
+		// The request succeeds, but the failure to store the response is logged.
+		w := create(handler, "h=entry&content=hello", "a", "1")
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Contains(t, logs.String(), "disk is full")
+	})
+
+	t.Run("Window", func(t *testing.T) {
+		t.Parallel()
+
+		handler, impl := newHandler(time.Nanosecond)
+
+		create(handler, "h=entry&content=hello", "a", "1")
+		time.Sleep(time.Millisecond)
+		w := create(handler, "h=entry&content=hello", "a", "1")
+		assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+
+		impl.AssertNumberOfCalls(t, "Create", 2)
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		t.Parallel()
+
+		handler, impl := newHandler(0)
+
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				w := create(handler, "h=entry&content=hello", "a", "1")
+				assert.Equal(t, http.StatusAccepted, w.Code)
+			}()
+		}
+		wg.Wait()
+
+		impl.AssertNumberOfCalls(t, "Create", 1)
+	})
+}
+
+type failingIdempotencyStore struct {
+	IdempotencyStore
+}
+
+func (s *failingIdempotencyStore) Put(ctx context.Context, key string, res *IdempotentResponse) error {
+	return errors.New("disk is full")
+}
+
+type recordingIdempotencyStore struct {
+	IdempotencyStore
+	last *IdempotentResponse
+}
+
+func (s *recordingIdempotencyStore) Put(ctx context.Context, key string, res *IdempotentResponse) error {
+	s.last = res
+	return s.IdempotencyStore.Put(ctx, key, res)
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryIdempotencyStore()
+	ctx := context.Background()
+
+	_, err := store.Get(ctx, "a")
+	assert.ErrorIs(t, err, ErrIdempotencyKeyNotFound)
+
+	require.NoError(t, store.Put(ctx, "a", &IdempotentResponse{StatusCode: http.StatusCreated, Expires: time.Now().Add(time.Hour)}))
+	require.NoError(t, store.Put(ctx, "b", &IdempotentResponse{StatusCode: http.StatusCreated, Expires: time.Now().Add(-time.Hour)}))
+
+	res, err := store.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+
+	_, err = store.Get(ctx, "b")
+	assert.ErrorIs(t, err, ErrIdempotencyKeyNotFound)
+}
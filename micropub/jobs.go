@@ -0,0 +1,204 @@
+package micropub
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ErrJobNotFound is returned when a job does not exist.
+var ErrJobNotFound = errors.New("job not found")
+
+// JobStatus is the status of an asynchronous create [Job].
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobBuilding  JobStatus = "building"
+	JobPublished JobStatus = "published"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job tracks the progress of an asynchronous create request. See [AsyncCreator].
+type Job struct {
+	ID     string    `json:"id"`
+	Status JobStatus `json:"status"`
+
+	// Location is the location of the post. It may be set before the post is
+	// published, if it is known in advance.
+	Location string `json:"location,omitempty"`
+
+	// Error is the description of the error of failed jobs. Internal errors
+	// are not exposed, in the same way as in error responses.
+	Error string `json:"error,omitempty"`
+
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// JobStore stores the [Job]s of asynchronous create requests.
+type JobStore interface {
+	// Get returns the job with the given ID. Must return [ErrJobNotFound] if
+	// the job does not exist.
+	Get(ctx context.Context, id string) (*Job, error)
+
+	// Put stores the job, replacing any existing job with the same ID.
+	Put(ctx context.Context, job *Job) error
+}
+
+type memoryJobStore struct {
+	mu   sync.RWMutex
+	jobs map[string]*Job
+}
+
+// NewMemoryJobStore creates a new in-memory [JobStore].
+func NewMemoryJobStore() JobStore {
+	return &memoryJobStore{
+		jobs: map[string]*Job{},
+	}
+}
+
+func (m *memoryJobStore) Get(_ context.Context, id string) (*Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+
+	cp := *job
+	return &cp, nil
+}
+
+func (m *memoryJobStore) Put(_ context.Context, job *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cp := *job
+	m.jobs[job.ID] = &cp
+	return nil
+}
+
+// AsyncCreator can be implemented by a [ContextImplementation], or an
+// [Implementation], to create posts asynchronously when [WithAsyncCreates] is
+// set. This is useful when publishing a post takes long, e.g., when a static
+// site must be rebuilt.
+type AsyncCreator interface {
+	// CreateAsync queues the create request. The given job has already been
+	// stored as queued, and the implementation is responsible for updating it
+	// in the [JobStore] as it progresses. CreateAsync must not block until the
+	// post is published, and must not use the context after returning.
+	CreateAsync(ctx context.Context, req *Request, job *Job) error
+}
+
+// WithAsyncCreates enables asynchronous create requests, whose jobs are tracked
+// in the given [JobStore]. If the implementation is an [AsyncCreator], create
+// requests are replied with 202 Accepted and the Location of the job status,
+// which is served at ?q=job&id=<id>. Otherwise, posts are created synchronously,
+// and the job query is not supported.
+func WithAsyncCreates(store JobStore) Option {
+	return func(conf *Configuration) {
+		conf.Jobs = store
+	}
+}
+
+// asyncCreates returns whether create requests are asynchronous, that is,
+// whether there is a [JobStore] and the implementation is an [AsyncCreator].
+func (h *handler) asyncCreates() bool {
+	_, ok := asyncCreator(h.impl)
+	return ok && h.conf.Jobs != nil
+}
+
+// asyncCreator returns the [AsyncCreator] of the implementation, if any.
+func asyncCreator(impl ContextImplementation) (AsyncCreator, bool) {
+	if adapter, ok := impl.(*implementationAdapter); ok {
+		creator, ok := adapter.impl.(AsyncCreator)
+		return creator, ok
+	}
+
+	creator, ok := impl.(AsyncCreator)
+	return creator, ok
+}
+
+func (h *handler) micropubCreateAsync(w http.ResponseWriter, r *http.Request, creator AsyncCreator, mr *Request) {
+	id, err := newJobID()
+	if err != nil {
+		serveError(w, h.conf.Logger, err)
+		return
+	}
+
+	now := time.Now()
+	job := &Job{
+		ID:      id,
+		Status:  JobQueued,
+		Created: now,
+		Updated: now,
+	}
+	if err := h.conf.Jobs.Put(r.Context(), job); err != nil {
+		serveError(w, h.conf.Logger, err)
+		return
+	}
+
+	err = creator.CreateAsync(r.Context(), mr, job)
+	h.runAfterHooks(r, mr, jobURL(id), err)
+	if err != nil {
+		job.Status = JobFailed
+		job.Error = errorDescription(err)
+		job.Updated = time.Now()
+		_ = h.conf.Jobs.Put(r.Context(), job)
+		serveError(w, h.conf.Logger, err)
+		return
+	}
+
+	// The implementation may have already updated the job.
+	if stored, err := h.conf.Jobs.Get(r.Context(), id); err == nil {
+		job = stored
+	}
+
+	w.Header().Set("Location", jobURL(id))
+	serveJSON(w, http.StatusAccepted, job)
+}
+
+func (h *handler) micropubJob(w http.ResponseWriter, r *http.Request) {
+	if !h.asyncCreates() {
+		serveError(w, h.conf.Logger, ErrNotFound)
+		return
+	}
+
+	job, err := h.conf.Jobs.Get(r.Context(), r.URL.Query().Get("id"))
+	if errors.Is(err, ErrJobNotFound) {
+		serveError(w, h.conf.Logger, errors.Join(ErrNotFound, err))
+		return
+	} else if err != nil {
+		serveError(w, h.conf.Logger, err)
+		return
+	}
+
+	if job.Status == JobPublished && job.Location != "" {
+		w.Header().Set("Location", job.Location)
+	}
+
+	serveJSON(w, http.StatusOK, job)
+}
+
+// jobURL returns the URL of the status of the job with the given ID. It is
+// relative to the URL of the endpoint, such that it works regardless of where
+// the handler is mounted.
+func jobURL(id string) string {
+	return "?q=job&id=" + url.QueryEscape(id)
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	_, err := cryptorand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,136 @@
+package micropub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type asyncImplementation struct {
+	contextImplementation
+
+	store  JobStore
+	jobs   chan *Job
+	failed chan *Job
+}
+
+func (a *asyncImplementation) CreateAsync(ctx context.Context, req *Request, job *Job) error {
+	switch req.Properties["content"][0] {
+	case "fail":
+		a.failed <- job
+		return errors.New("queue is full at 10.0.0.1")
+	case "invalid":
+		a.failed <- job
+		return fmt.Errorf("%w: content is invalid", ErrBadRequest)
+	}
+
+	job.Location = "https://example.com/1"
+	if err := a.store.Put(ctx, job); err != nil {
+		return err
+	}
+
+	a.jobs <- job
+	return nil
+}
+
+func TestAsyncCreates(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryJobStore()
+	impl := &asyncImplementation{store: store, jobs: make(chan *Job, 1), failed: make(chan *Job, 1)}
+	handler := NewContextHandler(impl, WithAsyncCreates(store))
+
+	create := func(content string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/micropub", strings.NewReader("h=entry&content="+content))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r = r.WithContext(ContextWithTokenInfo(r.Context(), &TokenInfo{Scopes: []string{"create"}}))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	status := func(location string) (*httptest.ResponseRecorder, *Job) {
+		u, err := url.Parse("https://example.com/micropub")
+		require.NoError(t, err)
+		u, err = u.Parse(location)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, u.String(), nil))
+
+		job := &Job{}
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(job))
+		}
+		return w, job
+	}
+
+	w := create("hello")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	location := w.Header().Get("Location")
+	require.True(t, strings.HasPrefix(location, "?q=job&id="), location)
+
+	// The create request returns immediately, while the job is queued.
+	job := <-impl.jobs
+	_, queued := status(location)
+	assert.Equal(t, job.ID, queued.ID)
+	assert.Equal(t, JobQueued, queued.Status)
+	assert.Equal(t, "https://example.com/1", queued.Location)
+
+	job.Status = JobBuilding
+	require.NoError(t, store.Put(context.Background(), job))
+	w, building := status(location)
+	assert.Equal(t, JobBuilding, building.Status)
+	assert.Empty(t, w.Header().Get("Location"))
+
+	job.Status = JobPublished
+	require.NoError(t, store.Put(context.Background(), job))
+	w, published := status(location)
+	assert.Equal(t, JobPublished, published.Status)
+	assert.Equal(t, "https://example.com/1", w.Header().Get("Location"))
+
+	// Internal errors are not exposed through the job status.
+	w = create("fail")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	_, failed := status(jobURL((<-impl.failed).ID))
+	assert.Equal(t, JobFailed, failed.Status)
+	assert.Equal(t, "Internal server error.", failed.Error)
+
+	w = create("invalid")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	_, failed = status(jobURL((<-impl.failed).ID))
+	assert.Equal(t, JobFailed, failed.Status)
+	assert.Equal(t, "invalid request: content is invalid", failed.Error)
+
+	w, _ = status("?q=job&id=missing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/micropub?q=config", nil))
+	assert.JSONEq(t, `{"q":["config","source","job"]}`, w.Body.String())
+
+	// Without a job store, the status of the jobs is not served.
+	w = httptest.NewRecorder()
+	NewContextHandler(impl).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/micropub?q=job&id="+job.ID, nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Neither is it if the implementation does not create posts asynchronously.
+	syncHandler := NewContextHandler(&impl.contextImplementation, WithAsyncCreates(store))
+	w = httptest.NewRecorder()
+	syncHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/micropub?q=job&id="+job.ID, nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	syncHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/micropub?q=config", nil))
+	assert.JSONEq(t, `{"q":["config","source"]}`, w.Body.String())
+}
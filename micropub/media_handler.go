@@ -3,6 +3,7 @@ package micropub
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
 )
@@ -10,6 +11,10 @@ import (
 const (
 	// DefaultMaxMediaSize is the default max media size, which is 20 MiB.
 	DefaultMaxMediaSize = 20 << 20
+
+	// DefaultMaxMemory is the default amount of the uploads kept in memory,
+	// which is 32 MiB, as in [http.Request.FormFile].
+	DefaultMaxMemory = 32 << 20
 )
 
 // MediaUploader is the media upload function. Must return the location (e.g., URL)
@@ -24,6 +29,7 @@ type ScopeChecker func(r *http.Request, scope string) bool
 type MediaConfiguration struct {
 	MaxMediaSize int64
 	MaxMemory    int64
+	Logger       *slog.Logger
 }
 
 // MediaOption is an option that configures [MediaConfiguration].
@@ -38,30 +44,52 @@ func WithMaxMediaSize(size int64) MediaOption {
 }
 
 // WithMaxMemory configures how much of the uploads are kept in memory. See
-// [http.Request.ParseMultipartForm] for more details. By default it is 0, meaning
-// that the upload is kept in temporary files.
+// [http.Request.ParseMultipartForm] for more details. By default it is 32 MiB.
+// The rest of the uploads are kept in temporary files, which are removed once
+// the request is handled.
 func WithMaxMemory(size int64) MediaOption {
 	return func(conf *MediaConfiguration) {
-		conf.MaxMediaSize = size
+		conf.MaxMemory = size
 	}
 }
 
-// NewMediaHandler creates a Micropub [media endpoint] handler with the given
-// configuration.
-//
-// [media endpoint]: https://micropub.spec.indieweb.org/#x3-6-media-endpoint
-func NewMediaHandler(mediaUploader MediaUploader, scopeChecker ScopeChecker, options ...MediaOption) http.Handler {
+// WithMediaLogger configures the logger of the internal errors of
+// [NewMediaHandler], which are replied to the client with a generic description.
+// By default, [slog.Default] is used.
+func WithMediaLogger(logger *slog.Logger) MediaOption {
+	return func(conf *MediaConfiguration) {
+		conf.Logger = logger
+	}
+}
+
+func newMediaConfiguration(options []MediaOption) *MediaConfiguration {
 	conf := &MediaConfiguration{
 		MaxMediaSize: DefaultMaxMediaSize,
+		MaxMemory:    DefaultMaxMemory,
+		Logger:       slog.Default(),
 	}
 
 	for _, option := range options {
 		option(conf)
 	}
 
+	return conf
+}
+
+// NewMediaHandler creates a Micropub [media endpoint] handler with the given
+// configuration.
+//
+// [media endpoint]: https://micropub.spec.indieweb.org/#x3-6-media-endpoint
+func NewMediaHandler(mediaUploader MediaUploader, scopeChecker ScopeChecker, options ...MediaOption) http.Handler {
+	conf := newMediaConfiguration(options)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !scopeChecker(r, "media") {
-			serveErrorJSON(w, http.StatusForbidden, "insufficient_scope", "Insufficient scope.")
+			serveError(w, conf.Logger, &Error{
+				StatusCode:  http.StatusForbidden,
+				Code:        "insufficient_scope",
+				Description: "Insufficient scope.",
+			})
 			return
 		}
 
@@ -71,13 +99,16 @@ func NewMediaHandler(mediaUploader MediaUploader, scopeChecker ScopeChecker, opt
 
 		err := r.ParseMultipartForm(conf.MaxMemory)
 		if err != nil {
-			serveError(w, fmt.Errorf("%w: %w", ErrBadRequest, err))
+			serveError(w, conf.Logger, fmt.Errorf("%w: %w", ErrBadRequest, err))
 			return
 		}
+		defer func() {
+			_ = r.MultipartForm.RemoveAll()
+		}()
 
 		file, header, err := r.FormFile("file")
 		if err != nil {
-			serveError(w, errors.Join(ErrBadRequest, err))
+			serveError(w, conf.Logger, errors.Join(ErrBadRequest, err))
 			return
 		}
 		defer func() {
@@ -86,7 +117,7 @@ func NewMediaHandler(mediaUploader MediaUploader, scopeChecker ScopeChecker, opt
 
 		redirect, err := mediaUploader(file, header)
 		if err != nil {
-			serveError(w, err)
+			serveError(w, conf.Logger, err)
 			return
 		}
 
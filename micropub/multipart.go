@@ -0,0 +1,147 @@
+package micropub
+
+import (
+	"errors"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrNoMediaUploader = errors.New("multipart requests with files are not supported")
+	ErrMediaTooLarge   = errors.New("media file is too large")
+)
+
+// ParseMultipartRequest parses a Micropub POST [http.Request] into a [Request]
+// object in the same way as [ParseRequest], but also supports multipart create
+// requests with files, as per the [specification]. The files are uploaded with
+// the given [MediaUploader], respecting the [MediaOption]s, and their locations
+// are added to [Request.Properties] under the name of their parts, such as
+// "photo", "video" or "audio".
+//
+// The caller is responsible for checking whether the request is authorized
+// before calling this function, since files are uploaded.
+//
+// [specification]: https://micropub.spec.indieweb.org/#uploading-files
+func ParseMultipartRequest(r *http.Request, mediaUploader MediaUploader, options ...MediaOption) (*Request, error) {
+	conf := newMediaConfiguration(options)
+
+	req, files, err := parseRequest(nil, r, conf)
+	if err != nil {
+		return nil, err
+	}
+	defer removeMultipartForm(r)
+
+	if err := uploadFiles(req, files, mediaUploader, conf); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// parseRequest parses the request. If it is a multipart create request, the
+// files are returned such that they can be uploaded with [uploadFiles]. The
+// caller must remove the temporary files with [removeMultipartForm] afterwards.
+// The response writer, if given, is used to limit the size of the body.
+func parseRequest(w http.ResponseWriter, r *http.Request, conf *MediaConfiguration) (*Request, map[string][]*multipart.FileHeader, error) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != "multipart/form-data" {
+		req, err := ParseRequest(r)
+		return req, nil, err
+	}
+
+	if conf.MaxMediaSize != 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, conf.MaxMediaSize)
+	}
+
+	if err := r.ParseMultipartForm(conf.MaxMemory); err != nil {
+		removeMultipartForm(r)
+		return nil, nil, err
+	}
+
+	req, err := parseFormEncoded(r.Form)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if req.Action != ActionCreate {
+		return req, nil, nil
+	}
+
+	return req, r.MultipartForm.File, nil
+}
+
+// removeMultipartForm removes the temporary files of the multipart form of the
+// request, if any. The [http.Server] only removes them for the original request,
+// not for the copies made by [http.Request.WithContext].
+func removeMultipartForm(r *http.Request) {
+	if r.MultipartForm != nil {
+		_ = r.MultipartForm.RemoveAll()
+	}
+}
+
+// uploadFiles uploads the given files and adds their locations to the properties
+// of the request. The sizes of all the files are checked before uploading any.
+// Uploaded files are not removed if a later upload fails, nor if the request is
+// rejected afterwards, since [MediaUploader] has no way of doing so.
+func uploadFiles(req *Request, files map[string][]*multipart.FileHeader, mediaUploader MediaUploader, conf *MediaConfiguration) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	if mediaUploader == nil {
+		return ErrNoMediaUploader
+	}
+
+	names := make([]string, 0, len(files))
+	for name, headers := range files {
+		names = append(names, name)
+
+		for _, header := range headers {
+			if conf.MaxMediaSize != 0 && header.Size > conf.MaxMediaSize {
+				return ErrMediaTooLarge
+			}
+		}
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		property := strings.TrimSuffix(name, "[]")
+
+		for _, header := range files[name] {
+			location, err := uploadFile(header, mediaUploader)
+			if err != nil {
+				return err
+			}
+
+			req.Properties[property] = append(req.Properties[property], location)
+		}
+	}
+
+	return nil
+}
+
+// fileProperties returns the names of the properties that receive the locations
+// of the given files.
+func fileProperties(files map[string][]*multipart.FileHeader) []string {
+	properties := []string{}
+	for name := range files {
+		properties = append(properties, strings.TrimSuffix(name, "[]"))
+	}
+	slices.Sort(properties)
+	return slices.Compact(properties)
+}
+
+func uploadFile(header *multipart.FileHeader, mediaUploader MediaUploader) (string, error) {
+	file, err := header.Open()
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	return mediaUploader(file, header)
+}
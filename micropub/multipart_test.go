@@ -0,0 +1,208 @@
+package micropub
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMultipartRequest(t *testing.T) {
+	t.Parallel()
+
+	newRequest := func(t *testing.T, fields map[string]string, files map[string][]byte) *http.Request {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+
+		for name, value := range fields {
+			require.NoError(t, mw.WriteField(name, value))
+		}
+
+		for name, data := range files {
+			part, err := mw.CreateFormFile(name, name+".jpg")
+			require.NoError(t, err)
+			_, err = part.Write(data)
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, mw.Close())
+
+		r := httptest.NewRequest(http.MethodPost, "/micropub", body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		return r
+	}
+
+	var uploaded [][]byte
+	uploader := func(file multipart.File, header *multipart.FileHeader) (string, error) {
+		data, err := io.ReadAll(file)
+		require.NoError(t, err)
+		uploaded = append(uploaded, data)
+		return "https://example.com/media/" + header.Filename, nil
+	}
+
+	t.Run("Files", func(t *testing.T) {
+		uploaded = nil
+
+		r := newRequest(t, map[string]string{
+			"h":       "entry",
+			"content": "hello world",
+			"photo[]": "https://example.com/existing.jpg",
+		}, map[string][]byte{
+			"photo[]": []byte("photo"),
+			"video":   []byte("video"),
+		})
+
+		req, err := ParseMultipartRequest(r, uploader)
+		require.NoError(t, err)
+		assert.Equal(t, &Request{
+			Action:   ActionCreate,
+			Type:     "h-entry",
+			Commands: map[string][]any{},
+			Properties: map[string][]any{
+				"content": {"hello world"},
+				"photo":   {"https://example.com/existing.jpg", "https://example.com/media/photo[].jpg"},
+				"video":   {"https://example.com/media/video.jpg"},
+			},
+		}, req)
+		assert.Equal(t, [][]byte{[]byte("photo"), []byte("video")}, uploaded)
+	})
+
+	t.Run("No Files", func(t *testing.T) {
+		r := newRequest(t, map[string]string{"h": "entry", "content": "hello world"}, nil)
+		req, err := ParseRequest(r)
+		require.NoError(t, err)
+		assert.Equal(t, map[string][]any{"content": {"hello world"}}, req.Properties)
+	})
+
+	t.Run("No Uploader", func(t *testing.T) {
+		r := newRequest(t, map[string]string{"h": "entry"}, map[string][]byte{"photo": []byte("photo")})
+		_, err := ParseRequest(r)
+		assert.ErrorIs(t, err, ErrNoMediaUploader)
+	})
+
+	t.Run("Too Large", func(t *testing.T) {
+		r := newRequest(t, map[string]string{"h": "entry"}, map[string][]byte{"photo": makeRandomBytes(t, 1024)})
+		_, err := ParseMultipartRequest(r, uploader, WithMaxMediaSize(512))
+		var maxBytesError *http.MaxBytesError
+		assert.ErrorAs(t, err, &maxBytesError)
+	})
+
+	t.Run("Handler", func(t *testing.T) {
+		impl := &mockRouterImplementation{}
+		impl.Mock.On("HasScope", mock.Anything, "create").Return(true).Once()
+		impl.Mock.On("HasScope", mock.Anything, "create").Return(false).Once()
+		impl.Mock.On("Create", &Request{
+			Action:     ActionCreate,
+			Type:       "h-entry",
+			Commands:   map[string][]any{},
+			Properties: map[string][]any{"photo": {"https://example.com/media/photo.jpg"}},
+		}).Return("https://example.com/1", nil)
+
+		uploaded = nil
+		handler := NewHandler(impl, WithMediaUploader(uploader))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, newRequest(t, map[string]string{"h": "entry"}, map[string][]byte{"photo": []byte("photo")}))
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Len(t, uploaded, 1)
+
+		// Files are not uploaded without the create scope.
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, newRequest(t, map[string]string{"h": "entry"}, map[string][]byte{"photo": []byte("photo")}))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Len(t, uploaded, 1)
+
+		// Files are rejected without a media uploader.
+		impl.Mock.On("HasScope", mock.Anything, "create").Return(true)
+		w = httptest.NewRecorder()
+		NewHandler(impl).ServeHTTP(w, newRequest(t, map[string]string{"h": "entry"}, map[string][]byte{"photo": []byte("photo")}))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		// Requests are validated before uploading the files, which count as the
+		// properties they are uploaded to.
+		validating := NewHandler(impl, WithMediaUploader(uploader), WithGetPostTypes(func() []PostType { return testPostTypes }), WithPostTypeValidation(true))
+
+		w = httptest.NewRecorder()
+		validating.ServeHTTP(w, newRequest(t, map[string]string{"h": "entry", "published": "yesterday"}, map[string][]byte{"photo": []byte("photo")}))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Len(t, uploaded, 1)
+
+		w = httptest.NewRecorder()
+		validating.ServeHTTP(w, newRequest(t, map[string]string{"h": "entry"}, map[string][]byte{"photo": []byte("photo")}))
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Len(t, uploaded, 2)
+
+		impl.AssertExpectations(t)
+	})
+}
+
+// TestMultipartTempFiles is not parallel, since it changes the temporary
+// directory, in which the uploads that do not fit in memory are kept.
+func TestMultipartTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+
+	impl := &mockRouterImplementation{}
+	impl.Mock.On("HasScope", mock.Anything, mock.Anything).Return(true)
+	impl.Mock.On("Create", mock.Anything).Return("https://example.com/1", nil)
+
+	uploader := func(file multipart.File, header *multipart.FileHeader) (string, error) {
+		return "https://example.com/media/" + header.Filename, nil
+	}
+
+	newRequest := func(name string) *http.Request {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		require.NoError(t, mw.WriteField("h", "entry"))
+		part, err := mw.CreateFormFile(name, name+".jpg")
+		require.NoError(t, err)
+		_, err = part.Write(makeRandomBytes(t, 1024))
+		require.NoError(t, err)
+		require.NoError(t, mw.Close())
+
+		r := httptest.NewRequest(http.MethodPost, "/micropub", body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		return r
+	}
+
+	handler := NewHandler(impl, WithMediaUploader(uploader, WithMaxMemory(0)))
+	idempotent := NewHandler(impl, WithMediaUploader(uploader, WithMaxMemory(0)), WithIdempotency(NewMemoryIdempotencyStore(), 0))
+	mediaHandler := NewMediaHandler(uploader, func(r *http.Request, scope string) bool { return true }, WithMaxMemory(0))
+
+	for range 3 {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, newRequest("photo"))
+		assert.Equal(t, http.StatusAccepted, w.Code)
+
+		w = httptest.NewRecorder()
+		mediaHandler.ServeHTTP(w, newRequest("file"))
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		_, err := ParseMultipartRequest(newRequest("photo"), uploader, WithMaxMemory(0))
+		require.NoError(t, err)
+
+		// With idempotency, the body is hashed into a temporary file.
+		w = httptest.NewRecorder()
+		idempotent.ServeHTTP(w, newRequest("photo"))
+		assert.Equal(t, http.StatusAccepted, w.Code)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	// The uploads were indeed kept in temporary files.
+	r := newRequest("photo")
+	require.NoError(t, r.ParseMultipartForm(0))
+	files, err = filepath.Glob(filepath.Join(dir, "multipart-*"))
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+	require.NoError(t, r.MultipartForm.RemoveAll())
+}
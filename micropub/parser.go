@@ -15,6 +15,7 @@ var (
 	ErrNoData         = errors.New("no micropub data was found in the request")
 	ErrNoActionCreate = errors.New("cannot specify an action when creating a post")
 	ErrMultipleTypes  = errors.New("type must have a single value")
+	ErrInvalidFormKey = errors.New("form-encoded key conflicts with another key")
 )
 
 // Action represents a Micropub action.
@@ -27,10 +28,12 @@ const (
 	ActionUndelete Action = "undelete"
 )
 
+// RequestUpdate describes the operations of an update [Request]. Use
+// [ApplyUpdate] to apply them to the properties of a post.
 type RequestUpdate struct {
 	Replace map[string][]any
 	Add     map[string][]any
-	Delete  any
+	Delete  RequestDelete
 }
 
 // Request describes a Micropub request.
@@ -44,13 +47,19 @@ type Request struct {
 }
 
 // ParseRequest parses a Micropub POST [http.Request] into a [Request] object.
-// Supports both JSON and form-encoded requests.
+// Supports both JSON and form-encoded requests. Multipart requests are supported
+// as long as they do not contain files. Otherwise, [ErrNoMediaUploader] is
+// returned. See [ParseMultipartRequest].
 func ParseRequest(r *http.Request) (*Request, error) {
 	contentType := r.Header.Get("Content-type")
 	if strings.Contains(contentType, "application/json") {
 		return parseJSON(r.Body)
 	}
 
+	if strings.HasPrefix(contentType, "multipart/form-data") {
+		return ParseMultipartRequest(r, nil)
+	}
+
 	err := r.ParseForm()
 	if err != nil {
 		return nil, err
@@ -68,27 +77,37 @@ func parseFormEncoded(body url.Values) (*Request, error) {
 		req.Action = ActionCreate
 		req.Type = "h-" + typ
 
-		delete(body, "h")
-		delete(body, "access_token")
-
 		if _, ok := body["action"]; ok {
 			return nil, ErrNoActionCreate
 		}
 
+		fields := url.Values{}
 		for key, val := range body {
-			if len(val) == 0 {
-				continue
+			if key != "h" && key != "access_token" {
+				fields[key] = val
 			}
+		}
+
+		decoded, err := decodeForm(fields)
+		if err != nil {
+			return nil, err
+		}
 
-			// TODO: some wild micropub clients seem to be posting stuff
-			// such as properties[checkin][location]. It'd be great to have
-			// a way to parse that easily. Look into libraries.
-			key = strings.TrimSuffix(key, "[]")
+		// Some clients wrap the properties, e.g., properties[checkin][type].
+		if properties, ok := decoded["properties"].(map[string]any); ok {
+			delete(decoded, "properties")
+			for key, val := range properties {
+				if _, ok := decoded[key]; !ok {
+					decoded[key] = val
+				}
+			}
+		}
 
+		for key, val := range decoded {
 			if strings.HasPrefix(key, "mp-") {
-				req.Commands[strings.TrimPrefix(key, "mp-")] = asAnySlice(val)
+				req.Commands[strings.TrimPrefix(key, "mp-")] = asSlice(val)
 			} else {
-				req.Properties[key] = asAnySlice(val)
+				req.Properties[key] = asSlice(val)
 			}
 		}
 
@@ -120,7 +139,7 @@ type requestJSON struct {
 	Properties map[string][]any `json:"properties,omitempty"`
 	Replace    map[string][]any `json:"replace,omitempty"`
 	Add        map[string][]any `json:"add,omitempty"`
-	Delete     interface{}      `json:"delete,omitempty"`
+	Delete     *RequestDelete   `json:"delete,omitempty"`
 }
 
 func parseJSON(r io.Reader) (*Request, error) {
@@ -169,7 +188,9 @@ func parseJSON(r io.Reader) (*Request, error) {
 		if body.Action == ActionUpdate {
 			req.Updates.Add = body.Add
 			req.Updates.Replace = body.Replace
-			req.Updates.Delete = body.Delete
+			if body.Delete != nil {
+				req.Updates.Delete = *body.Delete
+			}
 
 			// Best effort to get all commands by unmarshaling one more time
 			other := map[string]any{}
@@ -2,8 +2,12 @@ package micropub
 
 import (
 	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -63,6 +67,20 @@ var (
 				},
 			},
 		},
+		{
+			"h=entry&content=hello+world&photo[value]=https://example.com/a.jpg&photo[alt]=A+cat&weird[key=value",
+			"application/x-www-form-urlencoded",
+			&Request{
+				Action:   ActionCreate,
+				Type:     "h-entry",
+				Commands: map[string][]any{},
+				Properties: map[string][]any{
+					"content":   {"hello world"},
+					"photo":     {map[string]any{"value": "https://example.com/a.jpg", "alt": "A cat"}},
+					"weird[key": {"value"},
+				},
+			},
+		},
 		{
 			"action=delete&url=https://example.com/test",
 			"application/x-www-form-urlencoded",
@@ -131,7 +149,7 @@ var (
 				URL:      "https://example.com/test",
 				Commands: map[string][]any{},
 				Updates: RequestUpdate{
-					Delete: []any{"category"},
+					Delete: RequestDelete{Properties: []string{"category"}},
 				},
 			},
 		},
@@ -143,9 +161,9 @@ var (
 				URL:      "https://example.com/test",
 				Commands: map[string][]any{},
 				Updates: RequestUpdate{
-					Delete: map[string]any{
-						"category": []any{"indieweb"},
-					},
+					Delete: RequestDelete{Values: map[string][]any{
+						"category": {"indieweb"},
+					}},
 				},
 			},
 		},
@@ -160,9 +178,9 @@ var (
 					"slug":    {"slug"},
 				},
 				Updates: RequestUpdate{
-					Delete: map[string]any{
-						"category": []any{"indieweb"},
-					},
+					Delete: RequestDelete{Values: map[string][]any{
+						"category": {"indieweb"},
+					}},
 				},
 			},
 		},
@@ -174,11 +192,15 @@ var (
 		{"action=delete", "application/x-www-form-urlencoded", ErrNoURL},
 		{"action=undelete", "application/x-www-form-urlencoded", ErrNoURL},
 		{"action=update&url=https://example.com/test", "application/x-www-form-urlencoded", ErrNoFormUpdate},
+		{"h=entry&photo=https://example.com/a.jpg&photo[alt]=A+cat", "application/x-www-form-urlencoded", ErrInvalidFormKey},
+		{"h=entry&photo[][value]=https://example.com/a.jpg&photo[]=https://example.com/b.jpg", "application/x-www-form-urlencoded", ErrInvalidFormKey},
 		{"{}", "application/json", ErrNoData},
 		{`{"type":["h-entry", "h-review"],"properties":{"category":["foo","bar"],"content":["hello world"],"mp-command":["blah"]}}`, "application/json", ErrMultipleTypes},
 		{`{"action":"delete"}`, "application/json", ErrNoURL},
 		{`{"action":"undelete"}`, "application/json", ErrNoURL},
 		{`{"action":"update"}`, "application/json", ErrNoURL},
+		{`{"action":"update","url":"https://example.com/test","delete":"category"}`, "application/json", ErrInvalidDelete},
+		{`{"action":"update","url":"https://example.com/test","delete":{"category":"indieweb"}}`, "application/json", ErrInvalidDelete},
 	}
 )
 
@@ -205,3 +227,48 @@ func TestParseRequest(t *testing.T) {
 		}
 	})
 }
+
+// TestParseFormFixtures parses the form-encoded requests in testdata/forms,
+// each with the expected request as JSON. A fixture has one key-value pair per
+// line, or the raw body of the request on a single line.
+//
+// The current fixtures are synthetic, each exercising a shape of the bracket
+// notation: photos with alternative text, a reply with a nested location, and a
+// check-in with nested Microformats objects. Requests captured from Quill,
+// Indigenous and OwnYourSwarm are still missing. Add them as
+// <client>-<case>.txt, with the raw body logged by a Micropub endpoint, and
+// they are covered without further changes.
+func TestParseFormFixtures(t *testing.T) {
+	t.Parallel()
+
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "forms", "*.txt"))
+	require.NoError(t, err)
+	require.NotEmpty(t, fixtures)
+
+	for _, fixture := range fixtures {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".txt")
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			body, err := os.ReadFile(fixture)
+			require.NoError(t, err)
+
+			expected, err := os.ReadFile(strings.TrimSuffix(fixture, ".txt") + ".json")
+			require.NoError(t, err)
+
+			r := httptest.NewRequest(http.MethodPost, "/micropub", strings.NewReader(strings.Join(strings.Fields(string(body)), "&")))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req, err := ParseRequest(r)
+			require.NoError(t, err)
+
+			actual, err := json.Marshal(map[string]any{
+				"type":       req.Type,
+				"properties": req.Properties,
+				"commands":   req.Commands,
+			})
+			require.NoError(t, err)
+			require.JSONEq(t, string(expected), string(actual))
+		})
+	}
+}
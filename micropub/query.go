@@ -0,0 +1,301 @@
+package micropub
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ListQuery is a query for a list of categories or contacts. See
+// [WithCategoryProvider] and [WithContactProvider].
+type ListQuery struct {
+	// Filter filters the results by the given text, as per the [category] and
+	// [contact] extensions.
+	//
+	// [category]: https://indieweb.org/Micropub-extensions#Query_for_Category.2FTag_List
+	// [contact]: https://indieweb.org/Micropub-extensions#Query_for_Contacts
+	Filter string
+
+	// Limit is the maximum amount of results to return, or -1 for no limit.
+	Limit int
+
+	// Offset is the amount of results to skip.
+	Offset int
+
+	// Before and After are the cursors of the page to return, as returned in the
+	// [Paging] of a previous response. Their format is up to the provider.
+	Before string
+	After  string
+}
+
+// CategoryList is a list of categories returned for a [ListQuery].
+type CategoryList struct {
+	Categories []string `json:"categories"`
+	Paging     *Paging  `json:"paging,omitempty"`
+}
+
+// Contact is a contact returned by the [contact] query.
+//
+// [contact]: https://indieweb.org/Micropub-extensions#Query_for_Contacts
+type Contact struct {
+	Name     string `json:"name,omitempty"`
+	Nickname string `json:"nickname,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Photo    string `json:"photo,omitempty"`
+
+	// Silos maps the names of silos, e.g., "github", to the usernames of the
+	// contact in those silos.
+	Silos map[string]string `json:"silos,omitempty"`
+}
+
+// ContactList is a list of contacts returned for a [ListQuery].
+type ContactList struct {
+	Contacts []Contact `json:"contacts"`
+	Paging   *Paging   `json:"paging,omitempty"`
+}
+
+// GeoQuery is a query for the [location] at the given coordinates.
+//
+// [location]: https://indieweb.org/Micropub-extensions#Query_for_Location
+type GeoQuery struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// Place is a named location.
+type Place struct {
+	Label     string  `json:"label,omitempty"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	URL       string  `json:"url,omitempty"`
+}
+
+// GeoResult is the result of a [GeoQuery]: the location at the coordinates and,
+// optionally, the places nearby.
+type GeoResult struct {
+	Geo    *Place  `json:"geo,omitempty"`
+	Places []Place `json:"places,omitempty"`
+}
+
+// CategoryProvider returns the categories that match the given query.
+type CategoryProvider func(ctx context.Context, query *ListQuery) (*CategoryList, error)
+
+// ContactProvider returns the contacts that match the given query.
+type ContactProvider func(ctx context.Context, query *ListQuery) (*ContactList, error)
+
+// GeoProvider returns the location and places at the given coordinates.
+type GeoProvider func(ctx context.Context, query *GeoQuery) (*GeoResult, error)
+
+// WithCategoryProvider configures the provider of the categories for the
+// q=category query, which takes precedence over [WithGetCategories]. Use it
+// when there are too many categories to list at once.
+func WithCategoryProvider(provider CategoryProvider) Option {
+	return func(conf *Configuration) {
+		conf.CategoryProvider = provider
+	}
+}
+
+// WithContactProvider configures the provider of the contacts for the
+// [contact] query. If this is not set, the query is not supported.
+//
+// [contact]: https://indieweb.org/Micropub-extensions#Query_for_Contacts
+func WithContactProvider(provider ContactProvider) Option {
+	return func(conf *Configuration) {
+		conf.ContactProvider = provider
+	}
+}
+
+// WithGeoProvider configures the provider of the locations for the [geo]
+// query. If this is not set, the query is not supported.
+//
+// [geo]: https://indieweb.org/Micropub-extensions#Query_for_Location
+func WithGeoProvider(provider GeoProvider) Option {
+	return func(conf *Configuration) {
+		conf.GeoProvider = provider
+	}
+}
+
+// QueryHandler handles a custom query. The returned value is encoded as the
+// JSON response. The handler can return [ErrNotFound], [ErrBadRequest] and
+// [ErrNotImplemented], in the same way as an [Implementation].
+type QueryHandler func(ctx context.Context, query url.Values) (any, error)
+
+// WithQueryHandler registers a handler for the custom query with the given name,
+// such as "visibility". Custom queries take precedence over the built-in ones,
+// except for "config", and are advertised in the "q" member of the configuration.
+func WithQueryHandler(name string, handler QueryHandler) Option {
+	return func(conf *Configuration) {
+		if conf.QueryHandlers == nil {
+			conf.QueryHandlers = map[string]QueryHandler{}
+		}
+		conf.QueryHandlers[name] = handler
+	}
+}
+
+// parseListQuery parses the [ListQuery] of a q=category or q=contact request.
+func parseListQuery(values url.Values) (*ListQuery, error) {
+	limit, offset, err := parseLimitOffset(values)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ListQuery{
+		Filter: values.Get("filter"),
+		Limit:  limit,
+		Offset: offset,
+		Before: values.Get("before"),
+		After:  values.Get("after"),
+	}, nil
+}
+
+// parseLimitOffset parses the limit and offset parameters, which default to -1
+// and 0, respectively.
+func parseLimitOffset(values url.Values) (limit, offset int, err error) {
+	limit = -1
+	if str := values.Get("limit"); str != "" {
+		if limit, err = strconv.Atoi(str); err != nil {
+			return 0, 0, errors.Join(ErrBadRequest, err)
+		}
+	}
+
+	if str := values.Get("offset"); str != "" {
+		if offset, err = strconv.Atoi(str); err != nil {
+			return 0, 0, errors.Join(ErrBadRequest, err)
+		}
+	}
+
+	return limit, offset, nil
+}
+
+// parseGeoQuery parses the [GeoQuery] of a q=geo request.
+func parseGeoQuery(values url.Values) (*GeoQuery, error) {
+	latitude, err := strconv.ParseFloat(values.Get("lat"), 64)
+	if err != nil || latitude < -90 || latitude > 90 {
+		return nil, errors.Join(ErrBadRequest, errors.New("lat must be a number between -90 and 90"))
+	}
+
+	longitude, err := strconv.ParseFloat(values.Get("lon"), 64)
+	if err != nil || longitude < -180 || longitude > 180 {
+		return nil, errors.Join(ErrBadRequest, errors.New("lon must be a number between -180 and 180"))
+	}
+
+	return &GeoQuery{Latitude: latitude, Longitude: longitude}, nil
+}
+
+// categories returns the categories for the given query. Without a
+// [CategoryProvider], the categories of [Configuration.GetCategories] are
+// filtered and paginated with the limit and offset.
+func (conf *Configuration) categories(ctx context.Context, query *ListQuery) (*CategoryList, error) {
+	if conf.CategoryProvider != nil {
+		return conf.CategoryProvider(ctx, query)
+	}
+
+	var categories []string
+	filter := strings.ToLower(query.Filter)
+	for _, category := range conf.GetCategories() {
+		if strings.Contains(strings.ToLower(category), filter) {
+			categories = append(categories, category)
+		}
+	}
+
+	categories = categories[min(max(query.Offset, 0), len(categories)):]
+	if query.Limit >= 0 {
+		categories = categories[:min(query.Limit, len(categories))]
+	}
+
+	return &CategoryList{Categories: categories}, nil
+}
+
+func (h *handler) micropubCategory(w http.ResponseWriter, r *http.Request) {
+	if h.conf.CategoryProvider == nil && len(h.conf.GetCategories()) == 0 {
+		serveError(w, h.conf.Logger, ErrNotFound)
+		return
+	}
+
+	query, err := parseListQuery(r.URL.Query())
+	if err != nil {
+		serveError(w, h.conf.Logger, err)
+		return
+	}
+
+	list, err := h.conf.categories(r.Context(), query)
+	if err != nil {
+		serveError(w, h.conf.Logger, err)
+		return
+	}
+
+	if list == nil {
+		list = &CategoryList{}
+	}
+	if list.Categories == nil {
+		list.Categories = []string{}
+	}
+
+	serveJSON(w, http.StatusOK, list)
+}
+
+func (h *handler) micropubContact(w http.ResponseWriter, r *http.Request) {
+	if h.conf.ContactProvider == nil {
+		serveError(w, h.conf.Logger, ErrNotFound)
+		return
+	}
+
+	query, err := parseListQuery(r.URL.Query())
+	if err != nil {
+		serveError(w, h.conf.Logger, err)
+		return
+	}
+
+	list, err := h.conf.ContactProvider(r.Context(), query)
+	if err != nil {
+		serveError(w, h.conf.Logger, err)
+		return
+	}
+
+	if list == nil {
+		list = &ContactList{}
+	}
+	if list.Contacts == nil {
+		list.Contacts = []Contact{}
+	}
+
+	serveJSON(w, http.StatusOK, list)
+}
+
+func (h *handler) micropubGeo(w http.ResponseWriter, r *http.Request) {
+	if h.conf.GeoProvider == nil {
+		serveError(w, h.conf.Logger, ErrNotFound)
+		return
+	}
+
+	query, err := parseGeoQuery(r.URL.Query())
+	if err != nil {
+		serveError(w, h.conf.Logger, err)
+		return
+	}
+
+	result, err := h.conf.GeoProvider(r.Context(), query)
+	if err != nil {
+		serveError(w, h.conf.Logger, err)
+		return
+	}
+
+	if result == nil {
+		result = &GeoResult{}
+	}
+
+	serveJSON(w, http.StatusOK, result)
+}
+
+func (h *handler) micropubCustomQuery(w http.ResponseWriter, r *http.Request, queryHandler QueryHandler) {
+	res, err := queryHandler(r.Context(), r.URL.Query())
+	if err != nil {
+		serveError(w, h.conf.Logger, err)
+		return
+	}
+
+	serveJSON(w, http.StatusOK, res)
+}
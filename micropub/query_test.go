@@ -0,0 +1,159 @@
+package micropub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerQueries(t *testing.T) {
+	t.Parallel()
+
+	get := func(t *testing.T, handler http.Handler, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	t.Run("Category", func(t *testing.T) {
+		t.Parallel()
+
+		handler := NewHandler(&mockRouterImplementation{}, WithGetCategories(func() []string {
+			return []string{"IndieWeb", "indieauth", "go", "micropub"}
+		}))
+
+		for _, testCase := range []struct {
+			query    string
+			expected string
+		}{
+			{"?q=category", `{"categories":["IndieWeb","indieauth","go","micropub"]}`},
+			{"?q=category&filter=indie", `{"categories":["IndieWeb","indieauth"]}`},
+			{"?q=category&filter=indie&limit=1&offset=1", `{"categories":["indieauth"]}`},
+			{"?q=category&filter=nothing", `{"categories":[]}`},
+			{"?q=category&offset=10", `{"categories":[]}`},
+		} {
+			w := get(t, handler, "/micropub"+testCase.query)
+			assert.Equal(t, http.StatusOK, w.Code, testCase.query)
+			assert.JSONEq(t, testCase.expected, w.Body.String(), testCase.query)
+		}
+
+		w := get(t, handler, "/micropub?q=category&limit=all")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Category Provider", func(t *testing.T) {
+		t.Parallel()
+
+		var queries []*ListQuery
+		handler := NewHandler(&mockRouterImplementation{},
+			WithGetCategories(func() []string { return []string{"ignored"} }),
+			WithCategoryProvider(func(ctx context.Context, query *ListQuery) (*CategoryList, error) {
+				queries = append(queries, query)
+				return &CategoryList{Categories: []string{"indieweb"}, Paging: &Paging{After: "indieweb"}}, nil
+			}),
+		)
+
+		w := get(t, handler, "/micropub?q=category&filter=indie&limit=1&after=go")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"categories":["indieweb"],"paging":{"after":"indieweb"}}`, w.Body.String())
+		require.Len(t, queries, 1)
+		assert.Equal(t, &ListQuery{Filter: "indie", Limit: 1, After: "go"}, queries[0])
+	})
+
+	t.Run("Contact", func(t *testing.T) {
+		t.Parallel()
+
+		w := get(t, NewHandler(&mockRouterImplementation{}), "/micropub?q=contact")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		handler := NewHandler(&mockRouterImplementation{}, WithContactProvider(func(ctx context.Context, query *ListQuery) (*ContactList, error) {
+			if query.Filter != "aaron" {
+				return nil, nil
+			}
+			return &ContactList{Contacts: []Contact{{
+				Name:     "Aaron Parecki",
+				Nickname: "aaronpk",
+				URL:      "https://aaronparecki.com/",
+				Silos:    map[string]string{"github": "aaronpk"},
+			}}}, nil
+		}))
+
+		w = get(t, handler, "/micropub?q=contact&filter=aaron")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"contacts":[{"name":"Aaron Parecki","nickname":"aaronpk","url":"https://aaronparecki.com/","silos":{"github":"aaronpk"}}]}`, w.Body.String())
+
+		w = get(t, handler, "/micropub?q=contact&filter=someone")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"contacts":[]}`, w.Body.String())
+	})
+
+	t.Run("Geo", func(t *testing.T) {
+		t.Parallel()
+
+		w := get(t, NewHandler(&mockRouterImplementation{}), "/micropub?q=geo&lat=45.5&lon=-122.6")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		handler := NewHandler(&mockRouterImplementation{}, WithGeoProvider(func(ctx context.Context, query *GeoQuery) (*GeoResult, error) {
+			return &GeoResult{
+				Geo:    &Place{Label: "Portland, Oregon", Latitude: query.Latitude, Longitude: query.Longitude},
+				Places: []Place{{Label: "Powell's Books", Latitude: 45.523, Longitude: -122.681, URL: "https://www.powells.com/"}},
+			}, nil
+		}))
+
+		w = get(t, handler, "/micropub?q=geo&lat=45.5&lon=-122.6")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"geo":{"label":"Portland, Oregon","latitude":45.5,"longitude":-122.6},"places":[{"label":"Powell's Books","latitude":45.523,"longitude":-122.681,"url":"https://www.powells.com/"}]}`, w.Body.String())
+
+		for _, query := range []string{"?q=geo", "?q=geo&lat=91&lon=0", "?q=geo&lat=0&lon=east"} {
+			w = get(t, handler, "/micropub"+query)
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+		}
+
+		w = get(t, handler, "/micropub?q=config")
+		assert.JSONEq(t, `{"q":["config","source","geo"]}`, w.Body.String())
+	})
+	t.Run("Custom", func(t *testing.T) {
+		t.Parallel()
+
+		handler := NewHandler(&mockRouterImplementation{},
+			WithGetSyndicateTo(func() []Syndication { return []Syndication{{UID: "https://social.example.com/"}} }),
+			WithGetPostTypes(func() []PostType { return []PostType{{Type: "note", Name: "Note"}} }),
+			WithQueryHandler("visibility", func(ctx context.Context, query url.Values) (any, error) {
+				return map[string]any{"visibility": []string{"public", query.Get("extra")}}, nil
+			}),
+			WithQueryHandler("channel", func(ctx context.Context, query url.Values) (any, error) {
+				return nil, ErrNotImplemented
+			}),
+			WithQueryHandler("config", func(ctx context.Context, query url.Values) (any, error) {
+				return nil, errors.New("config cannot be overridden")
+			}),
+		)
+
+		w := get(t, handler, "/micropub?q=visibility&extra=unlisted")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"visibility":["public","unlisted"]}`, w.Body.String())
+
+		w = get(t, handler, "/micropub?q=channel")
+		assert.Equal(t, http.StatusNotImplemented, w.Code)
+
+		w = get(t, handler, "/micropub?q=post-types")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"post-types":[{"type":"note","name":"Note"}]}`, w.Body.String())
+
+		w = get(t, handler, "/micropub?q=config")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var config struct {
+			Q []string `json:"q"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&config))
+		assert.Equal(t, []string{"config", "source", "syndicate-to", "post-types", "channel", "visibility"}, config.Q)
+	})
+}
@@ -0,0 +1,155 @@
+package micropub
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+)
+
+// SourceQuery is a query for the Microformats source of a list of posts, as
+// per the [post list] extension.
+//
+// [post list]: https://indieweb.org/Micropub-extensions#Query_for_Post_List
+type SourceQuery struct {
+	// Limit is the maximum amount of posts to return, or -1 for no limit.
+	Limit int
+
+	// Offset is the amount of posts to skip. It is usually not used along with
+	// the cursors.
+	Offset int
+
+	// Before and After are the cursors of the page to return, as returned in the
+	// [Paging] of a previous [SourceList]. Their format is up to the implementation.
+	Before string
+	After  string
+
+	// PostType filters the posts by their [post type], e.g., "note" or "photo".
+	//
+	// [post type]: https://indieweb.org/Micropub-extensions#Query_by_Post_Type
+	PostType string
+
+	// Search filters the posts by the given full-text search terms.
+	Search string
+
+	// Properties are the properties to return. The handler takes care of the
+	// projection, such that implementations can ignore it.
+	Properties []string
+}
+
+// Paging contains the cursors of the previous and next pages of a [SourceList].
+type Paging struct {
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// SourceList is a list of posts returned for a [SourceQuery].
+type SourceList struct {
+	Items  []map[string]any `json:"items"`
+	Paging *Paging          `json:"paging,omitempty"`
+}
+
+// SourceLister can be implemented by an [Implementation] to support the filters
+// and cursors of a [SourceQuery]. Otherwise, [Implementation.SourceMany] is used,
+// and only the limit and offset are supported.
+type SourceLister interface {
+	// SourceList returns the Microformats source of the posts that match the
+	// given query.
+	SourceList(query *SourceQuery) (*SourceList, error)
+}
+
+// parseSourceQuery parses the [SourceQuery] of a q=source request without URL.
+// Properties can be given as "properties" or "properties[]".
+func parseSourceQuery(values url.Values) (*SourceQuery, error) {
+	limit, offset, err := parseLimitOffset(values)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SourceQuery{
+		Limit:      limit,
+		Offset:     offset,
+		Before:     values.Get("before"),
+		After:      values.Get("after"),
+		PostType:   values.Get("post-type"),
+		Search:     values.Get("search"),
+		Properties: sourceProperties(values),
+	}, nil
+}
+
+func sourceProperties(values url.Values) []string {
+	return append(values["properties"], values["properties[]"]...)
+}
+
+// values encodes the query as URL parameters of a q=source request.
+func (q *SourceQuery) values() url.Values {
+	values := url.Values{"q": {"source"}}
+	if q.Limit > 0 {
+		values.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Offset != 0 {
+		values.Set("offset", strconv.Itoa(q.Offset))
+	}
+	for key, value := range map[string]string{
+		"before":    q.Before,
+		"after":     q.After,
+		"post-type": q.PostType,
+		"search":    q.Search,
+	} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	for _, property := range q.Properties {
+		values.Add("properties[]", property)
+	}
+	return values
+}
+
+// sourceList adapts [Implementation.SourceMany] to a [SourceList] for
+// implementations that are not a [SourceLister].
+func sourceList(impl Implementation, query *SourceQuery) (*SourceList, error) {
+	if lister, ok := impl.(SourceLister); ok {
+		return lister.SourceList(query)
+	}
+
+	if query.Before != "" || query.After != "" || query.PostType != "" || query.Search != "" {
+		return nil, fmt.Errorf("%w: post type, search and cursors are not supported", ErrNotImplemented)
+	}
+
+	items, err := impl.SourceMany(query.Limit, query.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SourceList{Items: items}, nil
+}
+
+// projectSource returns the source with only the given properties, as per the
+// [Micropub specification]. If no properties are given, the source is returned
+// as is.
+//
+// [Micropub specification]: https://micropub.spec.indieweb.org/#source-content
+func projectSource(source map[string]any, properties []string) map[string]any {
+	if len(properties) == 0 {
+		return source
+	}
+
+	projected := map[string]any{}
+	switch sourceProperties := source["properties"].(type) {
+	case map[string][]any:
+		for key, value := range sourceProperties {
+			if slices.Contains(properties, key) {
+				projected[key] = value
+			}
+		}
+	case map[string]any:
+		for key, value := range sourceProperties {
+			if slices.Contains(properties, key) {
+				projected[key] = value
+			}
+		}
+	}
+
+	return map[string]any{"properties": projected}
+}
@@ -0,0 +1,114 @@
+package micropub
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSourceQuery(t *testing.T) {
+	t.Parallel()
+
+	values, err := url.ParseQuery("q=source&limit=5&offset=2&after=abc&post-type=photo&search=cat&properties[]=name&properties=content")
+	require.NoError(t, err)
+
+	query, err := parseSourceQuery(values)
+	require.NoError(t, err)
+	assert.Equal(t, &SourceQuery{
+		Limit:      5,
+		Offset:     2,
+		After:      "abc",
+		PostType:   "photo",
+		Search:     "cat",
+		Properties: []string{"content", "name"},
+	}, query)
+
+	query, err = parseSourceQuery(url.Values{"q": {"source"}})
+	require.NoError(t, err)
+	assert.Equal(t, &SourceQuery{Limit: -1}, query)
+
+	_, err = parseSourceQuery(url.Values{"limit": {"many"}})
+	assert.ErrorIs(t, err, ErrBadRequest)
+
+	// The client encodes queries such that they are parsed back as they were.
+	query = &SourceQuery{Limit: 10, Before: "b", Search: "hello world", Properties: []string{"name", "photo"}}
+	parsed, err := parseSourceQuery(query.values())
+	require.NoError(t, err)
+	assert.Equal(t, query, parsed)
+}
+
+type sourceListImplementation struct {
+	ContextImplementation
+
+	queries []*SourceQuery
+}
+
+func (s *sourceListImplementation) Source(ctx context.Context, url string) (map[string]any, error) {
+	return map[string]any{
+		"type":       []any{"h-entry"},
+		"properties": map[string][]any{"name": {"A"}, "content": {"Hello"}, "category": {"foo"}},
+	}, nil
+}
+
+func (s *sourceListImplementation) SourceList(ctx context.Context, query *SourceQuery) (*SourceList, error) {
+	s.queries = append(s.queries, query)
+	return &SourceList{
+		Items: []map[string]any{
+			{"type": []any{"h-entry"}, "properties": map[string][]any{"name": {"A"}, "content": {"Hello"}}},
+			{"type": []any{"h-entry"}, "properties": map[string]any{"name": []any{"B"}}},
+		},
+		Paging: &Paging{After: "2"},
+	}, nil
+}
+
+func TestHandlerSourceList(t *testing.T) {
+	t.Parallel()
+
+	impl := &sourceListImplementation{}
+	handler := NewContextHandler(impl)
+
+	get := func(target string) string {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		body, err := io.ReadAll(w.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	body := get("/micropub?q=source&limit=2&post-type=note&properties[]=name")
+	assert.JSONEq(t, `{"items":[{"properties":{"name":["A"]}},{"properties":{"name":["B"]}}],"paging":{"after":"2"}}`, body)
+	require.Len(t, impl.queries, 1)
+	assert.Equal(t, &SourceQuery{Limit: 2, PostType: "note", Properties: []string{"name"}}, impl.queries[0])
+
+	body = get("/micropub?q=source&url=https://example.com/1&properties[]=content&properties[]=category")
+	assert.JSONEq(t, `{"properties":{"content":["Hello"],"category":["foo"]}}`, body)
+
+	body = get("/micropub?q=source&url=https://example.com/1")
+	assert.JSONEq(t, `{"type":["h-entry"],"properties":{"name":["A"],"content":["Hello"],"category":["foo"]}}`, body)
+}
+
+func TestAdaptImplementationSourceList(t *testing.T) {
+	t.Parallel()
+
+	impl := &mockRouterImplementation{}
+	impl.Mock.On("SourceMany", 5, 0).Return([]map[string]any{{"type": []any{"h-entry"}}}, nil)
+
+	adapted := AdaptImplementation(impl)
+
+	list, err := adapted.SourceList(context.Background(), &SourceQuery{Limit: 5})
+	require.NoError(t, err)
+	assert.Equal(t, &SourceList{Items: []map[string]any{{"type": []any{"h-entry"}}}}, list)
+
+	_, err = adapted.SourceList(context.Background(), &SourceQuery{Limit: 5, After: "abc"})
+	assert.ErrorIs(t, err, ErrNotImplemented)
+
+	impl.AssertNumberOfCalls(t, "SourceMany", 1)
+}
@@ -0,0 +1,215 @@
+package micropub
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+)
+
+var (
+	ErrInvalidDelete = errors.New("delete must be an array of property names or an object of values")
+	ErrInvalidUpdate = errors.New("update property names must not be empty")
+)
+
+// RequestDelete is the delete operation of an update [Request]. As per the
+// [specification], it either deletes entire properties, or specific values of
+// properties, but not both.
+//
+// [specification]: https://micropub.spec.indieweb.org/#remove
+type RequestDelete struct {
+	// Properties are the names of the properties to delete entirely.
+	Properties []string
+
+	// Values are the values to delete from each property.
+	Values map[string][]any
+}
+
+// IsZero returns whether the delete operation has nothing to delete.
+func (d RequestDelete) IsZero() bool {
+	return len(d.Properties) == 0 && len(d.Values) == 0
+}
+
+func (d RequestDelete) MarshalJSON() ([]byte, error) {
+	switch {
+	case len(d.Properties) != 0 && len(d.Values) != 0:
+		return nil, ErrInvalidDelete
+	case len(d.Values) != 0:
+		return json.Marshal(d.Values)
+	default:
+		return json.Marshal(d.Properties)
+	}
+}
+
+func (d *RequestDelete) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*d = RequestDelete{}
+
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []any:
+		for _, property := range v {
+			name, ok := property.(string)
+			if !ok {
+				return ErrInvalidDelete
+			}
+			d.Properties = append(d.Properties, name)
+		}
+	case map[string]any:
+		d.Values = map[string][]any{}
+		for property, values := range v {
+			arr, ok := values.([]any)
+			if !ok {
+				return ErrInvalidDelete
+			}
+			d.Values[property] = arr
+		}
+	default:
+		return ErrInvalidDelete
+	}
+
+	return nil
+}
+
+// UpdateDiff describes the changes made to the properties by [ApplyUpdate].
+type UpdateDiff struct {
+	// Added are the values added to each property.
+	Added map[string][]any
+
+	// Removed are the values removed from each property.
+	Removed map[string][]any
+}
+
+// IsZero returns whether nothing has changed.
+func (d *UpdateDiff) IsZero() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+// Changed returns the names of the properties that have changed, sorted.
+func (d *UpdateDiff) Changed() []string {
+	names := []string{}
+	for name := range d.Added {
+		names = append(names, name)
+	}
+	for name := range d.Removed {
+		if _, ok := d.Added[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// ApplyUpdate applies the replacements, additions and deletions of the update,
+// in this order, to a copy of the given Microformats properties, as per the
+// [specification]. Properties left without values are removed. Values are
+// compared by their JSON representation, such that nested objects can be
+// deleted by value. Returns the updated properties and the changes made.
+//
+// [specification]: https://micropub.spec.indieweb.org/#update
+func ApplyUpdate(properties map[string][]any, update RequestUpdate) (map[string][]any, *UpdateDiff, error) {
+	for _, operation := range []map[string][]any{update.Replace, update.Add, update.Delete.Values} {
+		if _, ok := operation[""]; ok {
+			return nil, nil, ErrInvalidUpdate
+		}
+	}
+	if slices.Contains(update.Delete.Properties, "") {
+		return nil, nil, ErrInvalidUpdate
+	}
+
+	updated := map[string][]any{}
+	for name, values := range properties {
+		updated[name] = slices.Clone(values)
+	}
+
+	for name, values := range update.Replace {
+		updated[name] = slices.Clone(values)
+	}
+
+	for name, values := range update.Add {
+		updated[name] = append(updated[name], values...)
+	}
+
+	for _, name := range update.Delete.Properties {
+		delete(updated, name)
+	}
+
+	for name, values := range update.Delete.Values {
+		updated[name] = slices.DeleteFunc(updated[name], func(value any) bool {
+			return slices.ContainsFunc(values, func(v any) bool {
+				return valuesEqual(v, value)
+			})
+		})
+	}
+
+	for name, values := range updated {
+		if len(values) == 0 {
+			delete(updated, name)
+		}
+	}
+
+	diff := &UpdateDiff{
+		Added:   map[string][]any{},
+		Removed: map[string][]any{},
+	}
+
+	for name, values := range updated {
+		if added := subtractValues(values, properties[name]); len(added) != 0 {
+			diff.Added[name] = added
+		}
+	}
+
+	for name, values := range properties {
+		if removed := subtractValues(values, updated[name]); len(removed) != 0 {
+			diff.Removed[name] = removed
+		}
+	}
+
+	return updated, diff, nil
+}
+
+// subtractValues returns the values of a that are not in b, taking into account
+// the number of occurrences of each value.
+func subtractValues(a, b []any) []any {
+	remaining := slices.Clone(b)
+	result := []any{}
+
+	for _, value := range a {
+		i := slices.IndexFunc(remaining, func(v any) bool {
+			return valuesEqual(v, value)
+		})
+		if i == -1 {
+			result = append(result, value)
+		} else {
+			remaining = slices.Delete(remaining, i, i+1)
+		}
+	}
+
+	return result
+}
+
+// valuesEqual returns whether two values are equal. Values of different types,
+// such as map[string][]any and map[string]any, are compared by their JSON
+// representation.
+func valuesEqual(a, b any) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+
+	aj, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+
+	bj, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+
+	return string(aj) == string(bj)
+}
@@ -0,0 +1,199 @@
+package micropub
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestDeleteJSON(t *testing.T) {
+	t.Parallel()
+
+	for _, testCase := range []struct {
+		json     string
+		expected RequestDelete
+	}{
+		{`["category","photo"]`, RequestDelete{Properties: []string{"category", "photo"}}},
+		{`{"category":["indieweb"]}`, RequestDelete{Values: map[string][]any{"category": {"indieweb"}}}},
+	} {
+		var d RequestDelete
+		require.NoError(t, json.Unmarshal([]byte(testCase.json), &d))
+		assert.Equal(t, testCase.expected, d)
+
+		data, err := json.Marshal(d)
+		require.NoError(t, err)
+		assert.JSONEq(t, testCase.json, string(data))
+	}
+
+	for _, invalid := range []string{`"category"`, `[1]`, `{"category":"indieweb"}`} {
+		var d RequestDelete
+		assert.ErrorIs(t, json.Unmarshal([]byte(invalid), &d), ErrInvalidDelete)
+	}
+
+	_, err := json.Marshal(RequestDelete{Properties: []string{"a"}, Values: map[string][]any{"b": {"c"}}})
+	assert.ErrorIs(t, err, ErrInvalidDelete)
+}
+
+func TestApplyUpdate(t *testing.T) {
+	t.Parallel()
+
+	checkin := map[string][]any{
+		"name": {"Cafe"},
+		"url":  {"https://example.com/cafe"},
+	}
+
+	properties := func() map[string][]any {
+		return map[string][]any{
+			"content":  {"hello world"},
+			"category": {"foo", "bar", "foo"},
+			"checkin":  {map[string]any{"type": []string{"h-card"}, "properties": checkin}},
+		}
+	}
+
+	for _, testCase := range []struct {
+		name     string
+		update   RequestUpdate
+		expected map[string][]any
+		diff     *UpdateDiff
+	}{
+		{
+			"Replace",
+			RequestUpdate{Replace: map[string][]any{"content": {"hello moon"}, "name": {"Title"}}},
+			map[string][]any{
+				"content":  {"hello moon"},
+				"name":     {"Title"},
+				"category": {"foo", "bar", "foo"},
+				"checkin":  properties()["checkin"],
+			},
+			&UpdateDiff{
+				Added:   map[string][]any{"content": {"hello moon"}, "name": {"Title"}},
+				Removed: map[string][]any{"content": {"hello world"}},
+			},
+		},
+		{
+			"Replace With Empty",
+			RequestUpdate{Replace: map[string][]any{"content": {}}},
+			map[string][]any{
+				"category": {"foo", "bar", "foo"},
+				"checkin":  properties()["checkin"],
+			},
+			&UpdateDiff{
+				Added:   map[string][]any{},
+				Removed: map[string][]any{"content": {"hello world"}},
+			},
+		},
+		{
+			"Add",
+			RequestUpdate{Add: map[string][]any{"category": {"baz"}, "syndication": {"https://social.example.com/1"}}},
+			map[string][]any{
+				"content":     {"hello world"},
+				"category":    {"foo", "bar", "foo", "baz"},
+				"syndication": {"https://social.example.com/1"},
+				"checkin":     properties()["checkin"],
+			},
+			&UpdateDiff{
+				Added:   map[string][]any{"category": {"baz"}, "syndication": {"https://social.example.com/1"}},
+				Removed: map[string][]any{},
+			},
+		},
+		{
+			"Delete Properties",
+			RequestUpdate{Delete: RequestDelete{Properties: []string{"category", "missing"}}},
+			map[string][]any{
+				"content": {"hello world"},
+				"checkin": properties()["checkin"],
+			},
+			&UpdateDiff{
+				Added:   map[string][]any{},
+				Removed: map[string][]any{"category": {"foo", "bar", "foo"}},
+			},
+		},
+		{
+			"Delete Values",
+			RequestUpdate{Delete: RequestDelete{Values: map[string][]any{"category": {"foo", "missing"}, "content": {"hello world"}}}},
+			map[string][]any{
+				"category": {"bar"},
+				"checkin":  properties()["checkin"],
+			},
+			&UpdateDiff{
+				Added:   map[string][]any{},
+				Removed: map[string][]any{"category": {"foo", "foo"}, "content": {"hello world"}},
+			},
+		},
+		{
+			"Delete Nested Object",
+			RequestUpdate{Delete: RequestDelete{Values: map[string][]any{"checkin": {map[string]any{
+				"type":       []any{"h-card"},
+				"properties": map[string]any{"name": []any{"Cafe"}, "url": []any{"https://example.com/cafe"}},
+			}}}}},
+			map[string][]any{
+				"content":  {"hello world"},
+				"category": {"foo", "bar", "foo"},
+			},
+			&UpdateDiff{
+				Added:   map[string][]any{},
+				Removed: map[string][]any{"checkin": properties()["checkin"]},
+			},
+		},
+		{
+			"All Operations",
+			RequestUpdate{
+				Replace: map[string][]any{"content": {"hello moon"}},
+				Add:     map[string][]any{"category": {"baz"}},
+				Delete:  RequestDelete{Values: map[string][]any{"category": {"bar"}}},
+			},
+			map[string][]any{
+				"content":  {"hello moon"},
+				"category": {"foo", "foo", "baz"},
+				"checkin":  properties()["checkin"],
+			},
+			&UpdateDiff{
+				Added:   map[string][]any{"content": {"hello moon"}, "category": {"baz"}},
+				Removed: map[string][]any{"content": {"hello world"}, "category": {"bar"}},
+			},
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			original := properties()
+			updated, diff, err := ApplyUpdate(original, testCase.update)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expected, updated)
+			assert.Equal(t, testCase.diff, diff)
+			assert.Equal(t, properties(), original)
+		})
+	}
+
+	t.Run("No Changes", func(t *testing.T) {
+		t.Parallel()
+
+		_, diff, err := ApplyUpdate(properties(), RequestUpdate{Delete: RequestDelete{Properties: []string{"missing"}}})
+		require.NoError(t, err)
+		assert.True(t, diff.IsZero())
+		assert.Empty(t, diff.Changed())
+	})
+
+	t.Run("Changed", func(t *testing.T) {
+		t.Parallel()
+
+		_, diff, err := ApplyUpdate(properties(), RequestUpdate{
+			Replace: map[string][]any{"content": {"hello moon"}},
+			Delete:  RequestDelete{Properties: []string{"category"}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"category", "content"}, diff.Changed())
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+
+		_, _, err := ApplyUpdate(properties(), RequestUpdate{Add: map[string][]any{"": {"value"}}})
+		assert.ErrorIs(t, err, ErrInvalidUpdate)
+
+		_, _, err = ApplyUpdate(properties(), RequestUpdate{Delete: RequestDelete{Properties: []string{""}}})
+		assert.ErrorIs(t, err, ErrInvalidUpdate)
+	})
+}
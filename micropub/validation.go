@@ -0,0 +1,294 @@
+package micropub
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.hacdias.com/indielib/microformats"
+)
+
+// FieldError is a validation error of a single property.
+type FieldError struct {
+	Property string `json:"property"`
+	Message  string `json:"message"`
+}
+
+// ValidationError is returned by [PostTypeValidator.Validate] with the errors of
+// each invalid property. It matches [ErrBadRequest] with [errors.Is], and the
+// fields are included in the "fields" member of the JSON error body.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Property + ": " + field.Message
+	}
+	return "invalid properties: " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrBadRequest
+}
+
+// PostTypeValidator validates create requests against the [PostType]s of the
+// endpoint. The post type of the request is discovered with
+// [microformats.DiscoverType], after which the required properties are checked.
+// Regardless of the post type, the values of well-known properties must have the
+// right shape: URLs, dates and geographic locations.
+type PostTypeValidator struct {
+	PostTypes []PostType
+
+	// Strict rejects requests whose post type is not listed in PostTypes, as
+	// well as properties that are not listed in the properties of the post type.
+	Strict bool
+}
+
+var (
+	urlProperties = []string{
+		"url", "uid", "photo", "video", "audio", "featured", "syndication",
+		"in-reply-to", "like-of", "repost-of", "bookmark-of", "follow-of",
+		"read-of", "watch-of", "listen-of",
+	}
+	dateProperties = []string{"published", "updated", "start", "end"}
+	rsvpValues     = []string{"yes", "no", "maybe", "interested"}
+	dateLayouts    = []string{
+		time.RFC3339,
+		"2006-01-02T15:04:05Z0700",
+		"2006-01-02T15:04:05",
+		"2006-01-02T15:04",
+		"2006-01-02 15:04:05",
+		"2006-01-02",
+	}
+)
+
+// Validate validates the given create request. Returns a [ValidationError] if
+// the request is invalid.
+func (v *PostTypeValidator) Validate(req *Request) error {
+	return v.validate(req, nil)
+}
+
+// validate validates the given create request, where the given pending
+// properties are yet to receive the locations of uploaded files. Pending
+// properties are considered present, such that the request can be validated
+// before uploading the files.
+func (v *PostTypeValidator) validate(req *Request, pending []string) error {
+	e := &ValidationError{}
+	addError := func(property, format string, args ...any) {
+		e.Fields = append(e.Fields, FieldError{Property: property, Message: fmt.Sprintf(format, args...)})
+	}
+
+	properties := req.Properties
+	if len(pending) != 0 {
+		properties = maps.Clone(req.Properties)
+		for _, property := range pending {
+			properties[property] = append(slices.Clone(properties[property]), "")
+		}
+	}
+
+	typ, _ := microformats.DiscoverType(map[string]any{
+		"type":       []any{req.Type},
+		"properties": properties,
+	})
+
+	i := slices.IndexFunc(v.PostTypes, func(p PostType) bool { return p.Type == string(typ) })
+	if i == -1 && v.Strict {
+		addError("type", "post type %q is not supported", typ)
+	} else if i != -1 {
+		postType := v.PostTypes[i]
+
+		for _, property := range postType.Required {
+			if len(properties[property]) == 0 {
+				addError(property, "is required for %s posts", typ)
+			}
+		}
+
+		if v.Strict {
+			for _, property := range sortedKeys(properties) {
+				if !slices.Contains(postType.Properties, property) && !slices.Contains(postType.Required, property) {
+					addError(property, "is not supported for %s posts", typ)
+				}
+			}
+		}
+	}
+
+	for _, property := range sortedKeys(req.Properties) {
+		for _, value := range req.Properties[property] {
+			if msg := validateValue(property, value); msg != "" {
+				addError(property, "%s", msg)
+				break
+			}
+		}
+	}
+
+	if len(e.Fields) != 0 {
+		return e
+	}
+
+	return nil
+}
+
+// validateValue validates the shape of a single value of the given property.
+// Returns the error message, or an empty string if the value is valid.
+func validateValue(property string, value any) string {
+	switch {
+	case slices.Contains(urlProperties, property):
+		// Nested Microformats, e.g., citations, and photos with alternative
+		// text are objects.
+		if obj, ok := value.(map[string]any); ok {
+			if v, ok := obj["value"]; ok && !isAbsoluteURL(v) {
+				return "must be an absolute URL"
+			}
+			return ""
+		}
+
+		if !isAbsoluteURL(value) {
+			return "must be an absolute URL"
+		}
+	case slices.Contains(dateProperties, property):
+		s, ok := value.(string)
+		if !ok || !isDate(s) {
+			return "must be a date"
+		}
+	case property == "location":
+		if obj, ok := value.(map[string]any); ok {
+			return validateLocation(obj)
+		}
+
+		s, ok := value.(string)
+		if !ok {
+			return "must be a geo URI, a URL or an object"
+		}
+
+		if strings.HasPrefix(s, "geo:") {
+			if !isGeoURI(s) {
+				return "must be a valid geo URI"
+			}
+		} else if !isAbsoluteURL(s) {
+			return "must be a geo URI, a URL or an object"
+		}
+	case property == "latitude" || property == "longitude":
+		limit := 90.0
+		if property == "longitude" {
+			limit = 180
+		}
+
+		if !isCoordinate(value, limit) {
+			return fmt.Sprintf("must be a number between -%g and %g", limit, limit)
+		}
+	case property == "rsvp":
+		if s, _ := value.(string); !slices.Contains(rsvpValues, s) {
+			return "must be one of " + strings.Join(rsvpValues, ", ")
+		}
+	}
+
+	return ""
+}
+
+// validateLocation validates the latitude and longitude of a location object,
+// either a nested h-geo or h-adr, or an object with the coordinates as keys, as
+// given by the bracket notation of form-encoded requests.
+func validateLocation(obj map[string]any) string {
+	if properties, ok := obj["properties"].(map[string]any); ok {
+		obj = properties
+	}
+
+	for _, property := range []string{"latitude", "longitude"} {
+		values, ok := obj[property].([]any)
+		if !ok {
+			values = []any{obj[property]}
+		}
+
+		for _, value := range values {
+			if value == nil {
+				continue
+			}
+
+			if msg := validateValue(property, value); msg != "" {
+				return property + " " + msg
+			}
+		}
+	}
+
+	return ""
+}
+
+// isCoordinate reports whether the value is a number, or a string with a number,
+// between -limit and limit. JSON requests give numbers as float64.
+func isCoordinate(value any, limit float64) bool {
+	var (
+		f   float64
+		err error
+	)
+
+	switch v := value.(type) {
+	case float64:
+		f = v
+	case json.Number:
+		f, err = v.Float64()
+	case string:
+		f, err = strconv.ParseFloat(v, 64)
+	default:
+		return false
+	}
+
+	return err == nil && f >= -limit && f <= limit
+}
+
+func isAbsoluteURL(value any) bool {
+	s, ok := value.(string)
+	if !ok {
+		return false
+	}
+
+	u, err := url.Parse(s)
+	return err == nil && u.IsAbs() && u.Host != ""
+}
+
+func isDate(s string) bool {
+	for _, layout := range dateLayouts {
+		if _, err := time.Parse(layout, s); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// isGeoURI validates the coordinates of a geo URI, as per RFC 5870, such as
+// "geo:37.786971,-122.399677;u=35".
+func isGeoURI(s string) bool {
+	coordinates, _, _ := strings.Cut(strings.TrimPrefix(s, "geo:"), ";")
+	parts := strings.Split(coordinates, ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return false
+	}
+
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return false
+		}
+
+		if (i == 0 && (f < -90 || f > 90)) || (i == 1 && (f < -180 || f > 180)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
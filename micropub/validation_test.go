@@ -0,0 +1,212 @@
+package micropub
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var testPostTypes = []PostType{
+	{Type: "note", Name: "Note", Properties: []string{"content", "category", "published", "location"}, Required: []string{"content"}},
+	{Type: "photo", Name: "Photo", Properties: []string{"content", "photo"}, Required: []string{"photo"}},
+	{Type: "rsvp", Name: "RSVP", Properties: []string{"content", "in-reply-to"}, Required: []string{"rsvp", "in-reply-to"}},
+}
+
+func TestPostTypeValidator(t *testing.T) {
+	t.Parallel()
+
+	for _, testCase := range []struct {
+		name       string
+		strict     bool
+		properties map[string][]any
+		fields     []FieldError
+	}{
+		{
+			"Valid Note",
+			false,
+			map[string][]any{"content": {"hello"}, "published": {"2024-01-02T03:04:05Z"}, "location": {"geo:37.786971,-122.399677;u=35"}},
+			nil,
+		},
+		{
+			"Valid Photo With Alternative Text",
+			true,
+			map[string][]any{"photo": {map[string]any{"value": "https://example.com/a.jpg", "alt": "A cat"}}},
+			nil,
+		},
+		{
+			"Missing Required",
+			false,
+			map[string][]any{"rsvp": {"yes"}},
+			[]FieldError{{"in-reply-to", "is required for rsvp posts"}},
+		},
+		{
+			"Unknown Property",
+			true,
+			map[string][]any{"content": {"hello"}, "mood": {"happy"}},
+			[]FieldError{{"mood", "is not supported for note posts"}},
+		},
+		{
+			"Unknown Post Type",
+			true,
+			map[string][]any{"like-of": {"https://example.com/1"}},
+			[]FieldError{{"type", `post type "like" is not supported`}},
+		},
+		{
+			"Unknown Post Type Not Strict",
+			false,
+			map[string][]any{"like-of": {"https://example.com/1"}},
+			nil,
+		},
+		{
+			"Invalid Shapes",
+			false,
+			map[string][]any{
+				"content":   {"hello"},
+				"url":       {"/relative"},
+				"published": {"yesterday"},
+				"location":  {"geo:100,0"},
+				"latitude":  {"north"},
+				"longitude": {"-181"},
+			},
+			[]FieldError{
+				{"latitude", "must be a number between -90 and 90"},
+				{"location", "must be a valid geo URI"},
+				{"longitude", "must be a number between -180 and 180"},
+				{"published", "must be a date"},
+				{"url", "must be an absolute URL"},
+			},
+		},
+		{
+			"Valid Nested Locations",
+			false,
+			map[string][]any{
+				"content": {"hello"},
+				"location": {
+					map[string]any{"type": []any{"h-geo"}, "properties": map[string]any{"latitude": []any{37.78}, "longitude": []any{"-122.39"}}},
+					map[string]any{"latitude": "51.5074", "longitude": "-0.1278"},
+				},
+				"latitude":  {37.78},
+				"longitude": {json.Number("-122.39")},
+			},
+			nil,
+		},
+		{
+			"Invalid Nested Location",
+			false,
+			map[string][]any{
+				"content":  {"hello"},
+				"location": {map[string]any{"type": []any{"h-adr"}, "properties": map[string]any{"latitude": []any{91.0}, "longitude": []any{"west"}}}},
+			},
+			[]FieldError{{"location", "latitude must be a number between -90 and 90"}},
+		},
+		{
+			"Invalid Form Location",
+			false,
+			map[string][]any{
+				"content":  {"hello"},
+				"location": {map[string]any{"latitude": "51.5074", "longitude": "NaN"}},
+			},
+			[]FieldError{{"location", "longitude must be a number between -180 and 180"}},
+		},
+		{
+			"Invalid RSVP",
+			false,
+			map[string][]any{"rsvp": {"perhaps"}, "in-reply-to": {"https://example.com/event"}},
+			[]FieldError{{"rsvp", "must be one of yes, no, maybe, interested"}},
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			validator := &PostTypeValidator{PostTypes: testPostTypes, Strict: testCase.strict}
+			err := validator.Validate(&Request{Type: "h-entry", Properties: testCase.properties})
+			if testCase.fields == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			var e *ValidationError
+			require.ErrorAs(t, err, &e)
+			assert.ErrorIs(t, err, ErrBadRequest)
+			assert.Equal(t, testCase.fields, e.Fields)
+		})
+	}
+}
+
+func TestHandlerValidatesPostTypes(t *testing.T) {
+	t.Parallel()
+
+	impl := &mockRouterImplementation{}
+	impl.Mock.On("HasScope", mock.Anything, mock.Anything).Return(true)
+	impl.Mock.On("Create", mock.Anything).Return("https://example.com/1", nil)
+
+	handler := NewHandler(impl,
+		WithGetPostTypes(func() []PostType { return testPostTypes }),
+		WithPostTypeValidation(true),
+	)
+
+	r := httptest.NewRequest(http.MethodPost, "/micropub", bytes.NewReader([]byte("h=entry&content=hello")))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	r = httptest.NewRequest(http.MethodPost, "/micropub", bytes.NewReader([]byte("h=entry&content=hello&published=yesterday")))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var body struct {
+		Error  string       `json:"error"`
+		Fields []FieldError `json:"fields"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Equal(t, "invalid_request", body.Error)
+	assert.Equal(t, []FieldError{{"published", "must be a date"}}, body.Fields)
+
+	// JSON requests give coordinates as numbers, including in nested locations.
+	for _, testCase := range []struct {
+		body string
+		code int
+	}{
+		{`{"type":["h-entry"],"properties":{"content":["hello"],"location":[{"type":["h-adr"],"properties":{"latitude":[37],"longitude":["-122.39"]}}]}}`, http.StatusAccepted},
+		{`{"type":["h-entry"],"properties":{"content":["hello"],"location":[{"type":["h-geo"],"properties":{"latitude":[37.78],"longitude":[-122.39]}}]}}`, http.StatusAccepted},
+		{`{"type":["h-entry"],"properties":{"content":["hello"],"location":[{"type":["h-geo"],"properties":{"latitude":[137.78]}}]}}`, http.StatusBadRequest},
+		{`{"type":["h-entry"],"properties":{"content":["hello"],"location":[{"type":["h-geo"],"properties":{"latitude":[37.78],"longitude":[-222.39]}}]}}`, http.StatusBadRequest},
+	} {
+		r = httptest.NewRequest(http.MethodPost, "/micropub", bytes.NewReader([]byte(testCase.body)))
+		r.Header.Set("Content-Type", "application/json")
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, testCase.code, w.Code, testCase.body)
+	}
+
+	// Notes do not list top-level coordinates, which are thus checked without the
+	// strict validation.
+	lenient := NewHandler(impl,
+		WithGetPostTypes(func() []PostType { return testPostTypes }),
+		WithPostTypeValidation(false),
+	)
+	for _, testCase := range []struct {
+		body string
+		code int
+	}{
+		{`{"type":["h-entry"],"properties":{"content":["hello"],"latitude":[37.78],"longitude":[-122.39]}}`, http.StatusAccepted},
+		{`{"type":["h-entry"],"properties":{"content":["hello"],"latitude":[-90.5]}}`, http.StatusBadRequest},
+	} {
+		r = httptest.NewRequest(http.MethodPost, "/micropub", bytes.NewReader([]byte(testCase.body)))
+		r.Header.Set("Content-Type", "application/json")
+		w = httptest.NewRecorder()
+		lenient.ServeHTTP(w, r)
+		assert.Equal(t, testCase.code, w.Code, testCase.body)
+	}
+
+	impl.AssertNumberOfCalls(t, "Create", 4)
+}